@@ -35,7 +35,11 @@ func run() error {
 	sig := <-sigs
 
 	zlog.Logger.Infof("Stop metrics agent by signal=%v\n", sig)
-	metricsAgent.Stop()
+
+	// waits the final report of collected metrics
+	if err := metricsAgent.Stop(); err != nil {
+		zlog.Logger.Errorf("Metrics agent wasn't flushed, err=%s", err)
+	}
 
 	return nil
 }
@@ -46,8 +46,10 @@ func run() error {
 		if err := srvr.Stop(); err != nil {
 			return fmt.Errorf("stop server err=%s", err)
 		}
-	case <-srvr.Wait():
-		zlog.Logger.Info("Server stopped")
+	case err := <-srvr.Wait():
+		if err != nil {
+			return fmt.Errorf("server err=%w", err)
+		}
 	}
 
 	return nil
@@ -1,38 +1,92 @@
 package agent
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"time"
 
+	"github.com/kuzhukin/metrics-collector/internal/agent/cgroup"
 	"github.com/kuzhukin/metrics-collector/internal/agent/config"
 	"github.com/kuzhukin/metrics-collector/internal/agent/controller"
+	"github.com/kuzhukin/metrics-collector/internal/agent/relabel"
 	"github.com/kuzhukin/metrics-collector/internal/agent/reporter"
+	"github.com/kuzhukin/metrics-collector/internal/agent/runtimemetrics"
+	"github.com/kuzhukin/metrics-collector/internal/instance"
 	"github.com/kuzhukin/metrics-collector/internal/zlog"
 )
 
 type Agent struct {
 	ctrl *controller.Controller
+	// max duration of the final report on stop
+	shutdownTimeout time.Duration
 }
 
 // StartNew - creats and starts new metrics agent
 func StartNew(config config.Config) (*Agent, error) {
-	reporter, err := reporter.New(config)
+	identity, err := instance.Detect(config.InstanceID, config.UseMachineID)
+	if err != nil {
+		return nil, fmt.Errorf("detect instance identity, err=%w", err)
+	}
+
+	reporter, err := reporter.New(config, identity)
 	if err != nil {
 		return nil, fmt.Errorf("new reporter, err=%w", err)
 	}
 
+	pipeline, err := relabel.New(config.RelabelRules)
+	if err != nil {
+		return nil, fmt.Errorf("new relabel pipeline, err=%w", err)
+	}
+
+	collectors, err := makeCollectors(config)
+	if err != nil {
+		return nil, fmt.Errorf("make collectors, err=%w", err)
+	}
+
 	agent := Agent{
-		ctrl: controller.New(reporter, config.PollInterval, config.ReportInterval),
+		ctrl:            controller.New(reporter, pipeline, config.PollInterval, config.ReportInterval, collectors...),
+		shutdownTimeout: time.Second * time.Duration(config.ShutdownTimeout),
 	}
 
 	go agent.ctrl.Start()
 
-	zlog.Logger.Infof("Metrics Agent started  config=%+v", config)
+	zlog.Logger.Infof("Metrics Agent started  config=%+v, identity=%+v", config, identity)
 
 	return &agent, nil
 }
 
-func (a *Agent) Stop() {
+func makeCollectors(config config.Config) ([]controller.Collector, error) {
+	collectors := []controller.Collector{runtimemetrics.New(config.LegacyMemStats)}
+
+	if config.CollectCgroup {
+		cgroupCollector, err := cgroup.New(config.CgroupPath)
+		if err != nil {
+			if !errors.Is(err, cgroup.ErrCgroupV1) {
+				return nil, fmt.Errorf("new cgroup collector, err=%w", err)
+			}
+
+			// host-wide memory and cpu metrics from gopsutil are still reported
+			zlog.Logger.Warnf("Cgroup collector is disabled, err=%s", err)
+		} else {
+			collectors = append(collectors, cgroupCollector)
+		}
+	}
+
+	return collectors, nil
+}
+
+// Stop - stops the agent and flushes not reported metrics to server,
+// returns error if the final report wasn't successful during the shutdown timeout
+func (a *Agent) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), a.shutdownTimeout)
+	defer cancel()
+
+	if err := a.ctrl.Stop(ctx); err != nil {
+		return fmt.Errorf("stop controller, err=%w", err)
+	}
+
 	zlog.Logger.Infof("Metrics Agent stopped")
 
-	a.ctrl.Stop()
+	return nil
 }
@@ -0,0 +1,255 @@
+// package cgroup - collects resource usage of the agent's container from cgroup v2 files
+package cgroup
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	mountPointDefault = "/sys/fs/cgroup"
+	selfCgroupFile    = "/proc/self/cgroup"
+)
+
+var ErrCgroupV1 = errors.New("cgroup v1 isn't supported")
+var ErrNoCgroup = errors.New("cgroup isn't found")
+
+// Collector - reads metrics of the single cgroup v2
+type Collector struct {
+	// directory of the cgroup
+	path string
+	// previous values of cumulative stats for calculation of counter increments
+	prev map[string]int64
+}
+
+// New - creates collector for the cgroup by path, the agent's own cgroup is used if path is empty
+func New(path string) (*Collector, error) {
+	return newCollector(mountPointDefault, selfCgroupFile, path)
+}
+
+func newCollector(mountPoint string, selfCgroup string, path string) (*Collector, error) {
+	if err := checkVersion(mountPoint); err != nil {
+		return nil, err
+	}
+
+	if path == "" {
+		own, err := readOwnCgroup(selfCgroup)
+		if err != nil {
+			return nil, fmt.Errorf("read own cgroup, err=%w", err)
+		}
+
+		path = filepath.Join(mountPoint, own)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("cgroup path=%s, err=%w", path, errors.Join(ErrNoCgroup, err))
+	}
+
+	return &Collector{path: path, prev: make(map[string]int64)}, nil
+}
+
+// cgroup v2 has cgroup.controllers file in the root of unified hierarchy
+func checkVersion(mountPoint string) error {
+	if _, err := os.Stat(filepath.Join(mountPoint, "cgroup.controllers")); err == nil {
+		return nil
+	}
+
+	if _, err := os.Stat(filepath.Join(mountPoint, "memory")); err == nil {
+		return ErrCgroupV1
+	}
+
+	return ErrNoCgroup
+}
+
+// the unified hierarchy is described by the line "0::/path"
+func readOwnCgroup(selfCgroup string) (string, error) {
+	file, err := os.Open(selfCgroup)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if path, ok := strings.CutPrefix(scanner.Text(), "0::"); ok {
+			return path, nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return "", ErrNoCgroup
+}
+
+// Collect - reads current values of the cgroup stats,
+// cumulative stats are returned as increments since the previous call
+func (c *Collector) Collect() (map[string]float64, map[string]int64, error) {
+	gauges := make(map[string]float64)
+	cumulative := make(map[string]int64)
+
+	var joinedErr error
+
+	joinedErr = errors.Join(joinedErr, c.readSingleValue("memory.current", "CgroupMemoryCurrent", gauges))
+	joinedErr = errors.Join(joinedErr, c.readSingleValue("memory.max", "CgroupMemoryMax", gauges))
+	joinedErr = errors.Join(joinedErr, c.readSingleValue("pids.current", "CgroupPidsCurrent", gauges))
+	joinedErr = errors.Join(joinedErr, c.readMemoryStat(gauges, cumulative))
+	joinedErr = errors.Join(joinedErr, c.readCPUStat(cumulative))
+	joinedErr = errors.Join(joinedErr, c.readIOStat(cumulative))
+
+	return gauges, c.increments(cumulative), joinedErr
+}
+
+func (c *Collector) increments(cumulative map[string]int64) map[string]int64 {
+	counters := make(map[string]int64, len(cumulative))
+
+	for name, value := range cumulative {
+		prev, ok := c.prev[name]
+		c.prev[name] = value
+
+		// the first value is a baseline, counters are reported since start of the collector
+		if !ok || value < prev {
+			counters[name] = 0
+			continue
+		}
+
+		counters[name] = value - prev
+	}
+
+	return counters
+}
+
+// reads files with a single value, "max" means no limit and isn't reported
+func (c *Collector) readSingleValue(file string, name string, gauges map[string]float64) error {
+	data, err := c.read(file)
+	if err != nil || data == nil {
+		return err
+	}
+
+	raw := strings.TrimSpace(string(data))
+	if raw == "max" {
+		return nil
+	}
+
+	value, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return fmt.Errorf("parse file=%s, err=%w", file, err)
+	}
+
+	gauges[name] = float64(value)
+
+	return nil
+}
+
+// memory.stat contains "key value" lines, page and workingset events are cumulative
+func (c *Collector) readMemoryStat(gauges map[string]float64, cumulative map[string]int64) error {
+	return c.readKeyValues("memory.stat", func(key string, value int64) {
+		name := "CgroupMemoryStat" + camelCase(key)
+
+		if isMemoryEvent(key) {
+			cumulative[name] = value
+		} else {
+			gauges[name] = float64(value)
+		}
+	})
+}
+
+func isMemoryEvent(key string) bool {
+	return strings.HasPrefix(key, "pg") || strings.HasPrefix(key, "workingset_") || strings.HasPrefix(key, "thp_")
+}
+
+// cpu.stat contains cumulative usage and throttling stats
+func (c *Collector) readCPUStat(cumulative map[string]int64) error {
+	return c.readKeyValues("cpu.stat", func(key string, value int64) {
+		cumulative["CgroupCPU"+camelCase(key)] = value
+	})
+}
+
+// io.stat contains lines "MAJ:MIN rbytes=1 wbytes=2 rios=3 wios=4 dbytes=5 dios=6"
+func (c *Collector) readIOStat(cumulative map[string]int64) error {
+	data, err := c.read("io.stat")
+	if err != nil || data == nil {
+		return err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		device := strings.ReplaceAll(fields[0], ":", "_")
+
+		for _, field := range fields[1:] {
+			key, raw, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+
+			value, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				return fmt.Errorf("parse io.stat field=%s, err=%w", field, err)
+			}
+
+			cumulative["CgroupIO"+camelCase(key)+"_"+device] = value
+		}
+	}
+
+	return nil
+}
+
+func (c *Collector) readKeyValues(file string, fn func(key string, value int64)) error {
+	data, err := c.read(file)
+	if err != nil || data == nil {
+		return err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		key, raw, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok {
+			continue
+		}
+
+		value, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("parse file=%s line=%s, err=%w", file, line, err)
+		}
+
+		fn(key, value)
+	}
+
+	return nil
+}
+
+// returns nil data if the controller of the file isn't enabled for the cgroup
+func (c *Collector) read(file string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(c.path, file))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("read file=%s, err=%w", file, err)
+	}
+
+	return data, nil
+}
+
+// nr_throttled -> NrThrottled
+func camelCase(key string) string {
+	parts := strings.Split(key, "_")
+
+	for i, part := range parts {
+		if part != "" {
+			parts[i] = strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+
+	return strings.Join(parts, "")
+}
@@ -0,0 +1,84 @@
+package cgroup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollect(t *testing.T) {
+	mountPoint := t.TempDir()
+	selfCgroup := filepath.Join(t.TempDir(), "cgroup")
+	cgroupPath := filepath.Join(mountPoint, "agent.slice")
+
+	require.NoError(t, os.Mkdir(cgroupPath, 0755))
+	writeFile(t, mountPoint, "cgroup.controllers", "cpu io memory pids")
+	writeFile(t, filepath.Dir(selfCgroup), "cgroup", "0::/agent.slice\n")
+
+	writeFile(t, cgroupPath, "memory.current", "1024\n")
+	writeFile(t, cgroupPath, "memory.max", "max\n")
+	writeFile(t, cgroupPath, "pids.current", "7\n")
+	writeFile(t, cgroupPath, "memory.stat", "anon 100\nfile 200\npgfault 10\n")
+	writeFile(t, cgroupPath, "cpu.stat", "usage_usec 1000\nnr_periods 5\nnr_throttled 1\nthrottled_usec 300\n")
+	writeFile(t, cgroupPath, "io.stat", "8:0 rbytes=100 wbytes=200 rios=1 wios=2\n")
+
+	collector, err := newCollector(mountPoint, selfCgroup, "")
+	require.NoError(t, err)
+
+	gauges, counters, err := collector.Collect()
+	require.NoError(t, err)
+
+	require.Equal(t, map[string]float64{
+		"CgroupMemoryCurrent":  1024,
+		"CgroupPidsCurrent":    7,
+		"CgroupMemoryStatAnon": 100,
+		"CgroupMemoryStatFile": 200,
+	}, gauges)
+
+	// the first collection is a baseline for counters
+	for name, value := range counters {
+		require.Zero(t, value, name)
+	}
+
+	require.Contains(t, counters, "CgroupCPUNrThrottled")
+	require.Contains(t, counters, "CgroupIORbytes_8_0")
+
+	writeFile(t, cgroupPath, "memory.max", "2048\n")
+	writeFile(t, cgroupPath, "memory.stat", "anon 100\nfile 200\npgfault 15\n")
+	writeFile(t, cgroupPath, "cpu.stat", "usage_usec 1500\nnr_periods 8\nnr_throttled 3\nthrottled_usec 500\n")
+	writeFile(t, cgroupPath, "io.stat", "8:0 rbytes=150 wbytes=200 rios=2 wios=2\n")
+
+	gauges, counters, err = collector.Collect()
+	require.NoError(t, err)
+
+	require.Equal(t, float64(2048), gauges["CgroupMemoryMax"])
+	require.Equal(t, map[string]int64{
+		"CgroupMemoryStatPgfault": 5,
+		"CgroupCPUUsageUsec":      500,
+		"CgroupCPUNrPeriods":      3,
+		"CgroupCPUNrThrottled":    2,
+		"CgroupCPUThrottledUsec":  200,
+		"CgroupIORbytes_8_0":      50,
+		"CgroupIOWbytes_8_0":      0,
+		"CgroupIORios_8_0":        1,
+		"CgroupIOWios_8_0":        0,
+	}, counters)
+}
+
+func TestDetectVersion(t *testing.T) {
+	mountPoint := t.TempDir()
+
+	_, err := newCollector(mountPoint, "", "")
+	require.ErrorIs(t, err, ErrNoCgroup)
+
+	require.NoError(t, os.Mkdir(filepath.Join(mountPoint, "memory"), 0755))
+
+	_, err = newCollector(mountPoint, "", "")
+	require.ErrorIs(t, err, ErrCgroupV1)
+}
+
+func writeFile(t *testing.T, dir string, name string, data string) {
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(data), 0644))
+}
@@ -1,8 +1,10 @@
 package config
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"os"
 
 	"github.com/caarlos0/env/v6"
 )
@@ -11,6 +13,19 @@ const (
 	hostportDefault        = "localhost:8080"
 	pollIntervalSecDefault = 2
 	reportIntervalDefault  = 10
+	shutdownTimeoutDefault = 5
+
+	retryMaxAttemptsDefault   = 4
+	retryBaseDelayDefault     = 1000
+	retryMaxDelayDefault      = 5000
+	breakerThresholdDefault   = 5
+	breakerOpenTimeoutDefault = 30
+
+	grpcTimeoutDefault   = 5000
+	grpcKeepaliveDefault = 30
+
+	maxBatchMetricsDefault = 1000
+	maxPayloadSizeDefault  = 1 << 20
 )
 
 type Config struct {
@@ -28,6 +43,66 @@ type Config struct {
 	RealIP string `env:"REAL_IP"`
 	// use grpc
 	UseGRPC bool `env:"USE_GRPC"`
+	// max number of attempts of the single report
+	RetryMaxAttempts int `env:"RETRY_MAX_ATTEMPTS"`
+	// delay in milliseconds before the first retry, it's doubled with every retry
+	RetryBaseDelay int `env:"RETRY_BASE_DELAY"`
+	// max delay in milliseconds between retries
+	RetryMaxDelay int `env:"RETRY_MAX_DELAY"`
+	// number of consecutive failed attempts which opens the circuit breaker, 0 disables the breaker
+	BreakerThreshold int `env:"BREAKER_THRESHOLD"`
+	// duration in seconds of the open breaker state before probing of the server
+	BreakerOpenTimeout int `env:"BREAKER_OPEN_TIMEOUT"`
+	// max number of metrics in the single request
+	MaxBatchMetrics int `env:"MAX_BATCH_METRICS"`
+	// max size in bytes of the compressed payload of the single request
+	MaxPayloadSize int `env:"MAX_PAYLOAD_SIZE"`
+	// max duration in seconds of the final report on shutdown
+	ShutdownTimeout int `env:"SHUTDOWN_TIMEOUT"`
+	// id of the agent's instance, metrics are namespaced by it if it isn't empty,
+	// agents reporting to the same server should have different ids, otherwise they overwrite metrics of each other
+	InstanceID string `env:"INSTANCE_ID"`
+	// attach machine-id to the instance identity
+	UseMachineID bool `env:"USE_MACHINE_ID"`
+	// export go-runtime metrics with legacy runtime.MemStats names
+	LegacyMemStats bool `env:"LEGACY_MEMSTATS"`
+	// enable collecting of container resources from cgroup v2
+	CollectCgroup bool `env:"COLLECT_CGROUP"`
+	// path to the cgroup directory, the agent's own cgroup is used if it's empty
+	CgroupPath string `env:"CGROUP_PATH"`
+	// grpc server address:port, server address is used if it's empty
+	GRPCAddress string `env:"GRPC_ADDRESS"`
+	// path to the CA certificate of grpc server, enables TLS
+	GRPCCACert string `env:"GRPC_CA_CERT"`
+	// paths to the client certificate and key for grpc TLS connection
+	GRPCClientCert string `env:"GRPC_CLIENT_CERT"`
+	GRPCClientKey  string `env:"GRPC_CLIENT_KEY"`
+	// deadline in milliseconds of the single grpc call
+	GRPCTimeout int `env:"GRPC_TIMEOUT"`
+	// interval in seconds of grpc keepalive pings
+	GRPCKeepalive int `env:"GRPC_KEEPALIVE"`
+	// send gauges to the long-lived grpc stream instead of batch requests,
+	// counters are always sent in batches, because the stream doesn't acknowledge them
+	GRPCStreaming bool `env:"GRPC_STREAMING"`
+	// path to the json file with relabeling rules
+	RelabelRulesPath string `env:"RELABEL_RULES"`
+	// relabeling rules which are applied to metrics before reporting
+	RelabelRules []RelabelRule
+}
+
+// RelabelRule - a single rule of the metrics relabeling pipeline
+type RelabelRule struct {
+	// one of: keep, drop, rename, prefix, coerce
+	Action string `json:"action"`
+	// regular expression for metric name, matches the whole name
+	Regex string `json:"regex"`
+	// new name for rename action, can contain capture groups: $1, ${name},
+	// if gauges get the same name, the gauge with the first original name in sorted order is kept, counters are summed
+	Replacement string `json:"replacement"`
+	// static prefix for prefix action
+	Prefix string `json:"prefix"`
+	// target metric kind for coerce action: gauge or counter
+	Kind string `json:"kind"`
 }
 
 func MakeConfig() (Config, error) {
@@ -39,11 +114,55 @@ func MakeConfig() (Config, error) {
 	flag.StringVar(&config.SingnatureKey, "k", "", "Signature key")
 	flag.StringVar(&config.CryptoKey, "crypto-key", "", "Crypto key")
 	flag.BoolVar(&config.UseGRPC, "use-grpc", false, "Use GRPC")
+	flag.IntVar(&config.RetryMaxAttempts, "retry-attempts", retryMaxAttemptsDefault, "Max number of attempts of the single report")
+	flag.IntVar(&config.RetryBaseDelay, "retry-base-delay", retryBaseDelayDefault, "Delay in milliseconds before the first retry")
+	flag.IntVar(&config.RetryMaxDelay, "retry-max-delay", retryMaxDelayDefault, "Max delay in milliseconds between retries")
+	flag.IntVar(&config.BreakerThreshold, "breaker-threshold", breakerThresholdDefault, "Number of consecutive failures which opens the circuit breaker")
+	flag.IntVar(&config.BreakerOpenTimeout, "breaker-open-timeout", breakerOpenTimeoutDefault, "Duration in seconds of the open circuit breaker")
+	flag.IntVar(&config.MaxBatchMetrics, "max-batch-metrics", maxBatchMetricsDefault, "Max number of metrics in the single request")
+	flag.IntVar(&config.MaxPayloadSize, "max-payload-size", maxPayloadSizeDefault, "Max size in bytes of the compressed payload of the single request")
+	flag.IntVar(&config.ShutdownTimeout, "shutdown-timeout", shutdownTimeoutDefault, "Max duration in seconds of the final report on shutdown")
+	flag.StringVar(&config.InstanceID, "instance-id", "", "Instance id, metrics aren't namespaced by instance by default")
+	flag.BoolVar(&config.UseMachineID, "machine-id", false, "Attach machine-id to the instance identity")
+	flag.BoolVar(&config.LegacyMemStats, "legacy-memstats", true, "Export go-runtime metrics with legacy MemStats names")
+	flag.BoolVar(&config.CollectCgroup, "cgroup", false, "Collect container resources from cgroup v2")
+	flag.StringVar(&config.CgroupPath, "cgroup-path", "", "Path to the cgroup directory, own cgroup by default")
+	flag.StringVar(&config.GRPCAddress, "grpc-address", "", "Set ip:port of grpc server")
+	flag.StringVar(&config.GRPCCACert, "grpc-ca-cert", "", "Path to CA certificate of grpc server")
+	flag.StringVar(&config.GRPCClientCert, "grpc-client-cert", "", "Path to client certificate for grpc")
+	flag.StringVar(&config.GRPCClientKey, "grpc-client-key", "", "Path to client key for grpc")
+	flag.IntVar(&config.GRPCTimeout, "grpc-timeout", grpcTimeoutDefault, "Deadline in milliseconds of the single grpc call")
+	flag.IntVar(&config.GRPCKeepalive, "grpc-keepalive", grpcKeepaliveDefault, "Interval in seconds of grpc keepalive pings")
+	flag.BoolVar(&config.GRPCStreaming, "grpc-streaming", false, "Send gauges to the long-lived grpc stream, counters are sent in batches")
+	flag.StringVar(&config.RelabelRulesPath, "relabel-rules", "", "Path to json file with relabeling rules")
 	flag.Parse()
 
 	if err := env.Parse(&config); err != nil {
 		return config, fmt.Errorf("parse env err=%w", err)
 	}
 
+	if config.RelabelRulesPath != "" {
+		rules, err := readRelabelRules(config.RelabelRulesPath)
+		if err != nil {
+			return config, fmt.Errorf("read relabel rules err=%w", err)
+		}
+
+		config.RelabelRules = rules
+	}
+
 	return config, nil
 }
+
+func readRelabelRules(path string) ([]RelabelRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read file=%s, err=%w", path, err)
+	}
+
+	rules := make([]RelabelRule, 0)
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("unmarshal file=%s, err=%w", path, err)
+	}
+
+	return rules, nil
+}
@@ -1,18 +1,27 @@
 package controller
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math/rand"
-	"runtime"
 	"sync"
 	"time"
 
+	"github.com/kuzhukin/metrics-collector/internal/agent/relabel"
 	"github.com/kuzhukin/metrics-collector/internal/agent/reporter"
+	"github.com/kuzhukin/metrics-collector/internal/metric"
 	"github.com/kuzhukin/metrics-collector/internal/zlog"
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/mem"
 )
 
+// Collector - source of additional metrics which are polled with the polling interval
+type Collector interface {
+	// returns gauges and increments of counters since the previous call
+	Collect() (map[string]float64, map[string]int64, error)
+}
+
 // Controller - collects metrics from go-runtime and sends them to server
 type Controller struct {
 	// metric collections
@@ -22,9 +31,23 @@ type Controller struct {
 
 	// reporter for sending metrics to server
 	reporter reporter.Reporter
+	// filtering and relabeling rules applied before reporting
+	pipeline *relabel.Pipeline
+	// additional sources of metrics
+	collectors []Collector
 
 	done chan struct{}
 	wg   sync.WaitGroup
+	// closed when all goroutines of the controller are stopped
+	stopped chan struct{}
+	// the controller is stopped once, repeated calls of Stop return the result of the first one
+	stopOnce sync.Once
+	stopErr  error
+
+	// context of reporting, it's cancelled only if the current report isn't finished before the stop deadline,
+	// because the server could apply the interrupted report and its counters would be sent again by the flush
+	ctx    context.Context
+	cancel context.CancelFunc
 
 	// interval of polling metrics from system
 	polingInterval int
@@ -33,27 +56,76 @@ type Controller struct {
 }
 
 // New returns a new agent
-func New(reporter reporter.Reporter, pollingInterval, reportInterval int) *Controller {
+func New(reporter reporter.Reporter, pipeline *relabel.Pipeline, pollingInterval, reportInterval int, collectors ...Collector) *Controller {
+	ctx, cancel := context.WithCancel(context.Background())
+
 	return &Controller{
 		polingInterval: pollingInterval,
 		reportInterval: reportInterval,
 		gaugeMetrics:   make(map[string]float64),
 		counterMetrics: make(map[string]int64),
 		reporter:       reporter,
+		pipeline:       pipeline,
+		collectors:     collectors,
 		done:           make(chan struct{}),
+		stopped:        make(chan struct{}),
+		ctx:            ctx,
+		cancel:         cancel,
 	}
 }
 
 // Start agent
 func (c *Controller) Start() {
+	defer close(c.stopped)
+
 	zlog.Logger.Infof("Controller started")
 	c.start()
 	zlog.Logger.Infof("Controller stopped")
 }
 
-// Stop agent
-func (c *Controller) Stop() {
+// Stop agent, metrics which were collected after the last report are flushed to server.
+// The context limits the time of the final report, returns error if the final report was failed
+func (c *Controller) Stop(ctx context.Context) error {
+	c.stopOnce.Do(func() {
+		c.stopErr = c.stop(ctx)
+	})
+
+	return c.stopErr
+}
+
+func (c *Controller) stop(ctx context.Context) error {
 	close(c.done)
+	defer c.cancel()
+
+	select {
+	case <-c.stopped:
+	case <-ctx.Done():
+		return fmt.Errorf("wait controller stop, err=%w", ctx.Err())
+	}
+
+	flushErr := c.flush(ctx)
+
+	if err := c.reporter.Close(); err != nil {
+		zlog.Logger.Warnf("close reporter, err=%s", err)
+	}
+
+	return flushErr
+}
+
+// runs the final collection and report,
+// gopsutil metrics aren't collected because cpu utilization is measured during a second
+func (c *Controller) flush(ctx context.Context) error {
+	c.collectMetrics()
+
+	for _, collector := range c.collectors {
+		c.collectExternalMetrics(collector)
+	}
+
+	if err := c.report(ctx); err != nil {
+		return fmt.Errorf("final report, err=%w", err)
+	}
+
+	return nil
 }
 
 func (c *Controller) start() {
@@ -63,6 +135,10 @@ func (c *Controller) start() {
 	c.startReporter()
 	// starting cpu util collector goroutine
 	c.startGoPsUtilCollector()
+	// starting goroutines of additional collectors
+	for _, collector := range c.collectors {
+		c.startExternalCollector(collector)
+	}
 	// wait started goroutines
 	c.wg.Wait()
 }
@@ -105,6 +181,25 @@ func (c *Controller) startGoPsUtilCollector() {
 	}()
 }
 
+func (c *Controller) startExternalCollector(collector Collector) {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		pollingTicker := time.NewTicker(time.Second * time.Duration(c.polingInterval))
+		defer pollingTicker.Stop()
+
+		for {
+			select {
+			case <-pollingTicker.C:
+				c.collectExternalMetrics(collector)
+			case <-c.done:
+				return
+			}
+		}
+	}()
+}
+
 func (c *Controller) startReporter() {
 	c.wg.Add(1)
 	go func() {
@@ -116,7 +211,9 @@ func (c *Controller) startReporter() {
 		for {
 			select {
 			case <-reportTicker.C:
-				c.reporter.Report(c.getMetrics())
+				if err := c.report(c.ctx); err != nil {
+					zlog.Logger.Errorf("report metrics err=%s", err)
+				}
 			case <-c.done:
 				return
 			}
@@ -124,47 +221,53 @@ func (c *Controller) startReporter() {
 	}()
 }
 
+// the server adds reported counters to the stored ones, so the applied increments are subtracted after the report,
+// increments of failed chunks are kept for the next report
+func (c *Controller) report(ctx context.Context) error {
+	gauges, counters := c.getMetrics()
+	relabeledGauges, relabeledCounters := c.pipeline.Apply(gauges, counters)
+
+	err := c.reporter.Report(ctx, relabeledGauges, relabeledCounters)
+
+	var partial *reporter.PartialReportError
+	if err != nil && !errors.As(err, &partial) {
+		return err
+	}
+
+	if partial != nil {
+		counters = c.appliedCounters(counters, partial)
+	}
+
+	c.subtractCounters(counters)
+
+	return err
+}
+
+// returns collected counters which are reported in applied chunks, dropped counters aren't reported at all
+func (c *Controller) appliedCounters(counters map[string]int64, partial *reporter.PartialReportError) map[string]int64 {
+	applied := make(map[string]int64, len(counters))
+
+	for name, value := range counters {
+		target, kind, ok := c.pipeline.Target(name, metric.Counter)
+		if !ok || partial.Applied(target, kind) {
+			applied[name] = value
+		}
+	}
+
+	return applied
+}
+
 func (c *Controller) collectMetrics() {
 
 	c.collectGauge()
 	c.collectCounter()
 }
 
+// go-runtime metrics are read by runtime metrics collector
 func (c *Controller) collectGauge() {
-	memstats := &runtime.MemStats{}
-	runtime.ReadMemStats(memstats)
-
 	c.metricsLock.Lock()
 	defer c.metricsLock.Unlock()
 
-	c.addGauge("Alloc", float64(memstats.Alloc))
-	c.addGauge("BuckHashSys", float64(memstats.BuckHashSys))
-	c.addGauge("Frees", float64(memstats.Frees))
-	c.addGauge("GCCPUFraction", memstats.GCCPUFraction)
-	c.addGauge("GCSys", float64(memstats.GCSys))
-	c.addGauge("HeapAlloc", float64(memstats.HeapAlloc))
-	c.addGauge("HeapIdle", float64(memstats.HeapIdle))
-	c.addGauge("HeapInuse", float64(memstats.HeapInuse))
-	c.addGauge("HeapObjects", float64(memstats.HeapObjects))
-	c.addGauge("HeapReleased", float64(memstats.HeapReleased))
-	c.addGauge("HeapSys", float64(memstats.HeapSys))
-	c.addGauge("LastGC", float64(memstats.LastGC))
-	c.addGauge("Lookups", float64(memstats.Lookups))
-	c.addGauge("MCacheInuse", float64(memstats.MCacheInuse))
-	c.addGauge("MCacheSys", float64(memstats.MCacheSys))
-	c.addGauge("MSpanInuse", float64(memstats.MSpanInuse))
-	c.addGauge("MSpanSys", float64(memstats.MSpanSys))
-	c.addGauge("Mallocs", float64(memstats.Mallocs))
-	c.addGauge("NextGC", float64(memstats.NextGC))
-	c.addGauge("NumForcedGC", float64(memstats.NumForcedGC))
-	c.addGauge("NumGC", float64(memstats.NumGC))
-	c.addGauge("OtherSys", float64(memstats.OtherSys))
-	c.addGauge("PauseTotalNs", float64(memstats.PauseTotalNs))
-	c.addGauge("StackInuse", float64(memstats.StackInuse))
-	c.addGauge("StackSys", float64(memstats.StackSys))
-	c.addGauge("Sys", float64(memstats.Sys))
-	c.addGauge("TotalAlloc", float64(memstats.TotalAlloc))
-
 	// random value
 	c.addGauge("RandomValue", genRandom())
 }
@@ -194,6 +297,24 @@ func (c *Controller) collectGoPsUtilMetrics() {
 
 }
 
+func (c *Controller) collectExternalMetrics(collector Collector) {
+	gauges, counters, err := collector.Collect()
+	if err != nil {
+		zlog.Logger.Errorf("collect metrics, err=%s", err)
+	}
+
+	c.metricsLock.Lock()
+	defer c.metricsLock.Unlock()
+
+	for name, value := range gauges {
+		c.addGauge(name, value)
+	}
+
+	for name, value := range counters {
+		c.counterMetrics[name] += value
+	}
+}
+
 func (c *Controller) addGauge(name string, value float64) {
 	c.gaugeMetrics[name] = value
 }
@@ -205,6 +326,20 @@ func (c *Controller) collectCounter() {
 	c.counterMetrics["PollCount"]++
 }
 
+// increments which were collected during the report are kept for the next report
+func (c *Controller) subtractCounters(reported map[string]int64) {
+	c.metricsLock.Lock()
+	defer c.metricsLock.Unlock()
+
+	for name, value := range reported {
+		c.counterMetrics[name] -= value
+
+		if c.counterMetrics[name] == 0 {
+			delete(c.counterMetrics, name)
+		}
+	}
+}
+
 func genRandom() float64 {
 	return rand.Float64()
 }
@@ -1,10 +1,25 @@
 package controller
 
 import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/kuzhukin/metrics-collector/internal/agent/config"
+	"github.com/kuzhukin/metrics-collector/internal/agent/relabel"
+	"github.com/kuzhukin/metrics-collector/internal/agent/reporter"
 	"github.com/kuzhukin/metrics-collector/internal/agent/reporter/mockreporter"
+	"github.com/kuzhukin/metrics-collector/internal/agent/runtimemetrics"
+	"github.com/kuzhukin/metrics-collector/internal/instance"
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
 
@@ -13,7 +28,10 @@ const pollingInterval = 2
 
 func TestControllerPolling(t *testing.T) {
 	mockReporter := mockreporter.NewReporter(t)
-	controller := New(mockReporter, pollingInterval, reportInterval)
+	pipeline, err := relabel.New(nil)
+	require.NoError(t, err)
+
+	controller := New(mockReporter, pipeline, pollingInterval, reportInterval, runtimemetrics.New(true))
 	require.Len(t, controller.gaugeMetrics, 0)
 
 	go controller.Start()
@@ -22,12 +40,21 @@ func TestControllerPolling(t *testing.T) {
 	const pollIntervalsCount = 2
 	time.Sleep(time.Second*pollingInterval*pollIntervalsCount + time.Second)
 
-	controller.Stop()
+	var gauges map[string]float64
+	var counters map[string]int64
+
+	// the final report on stop
+	mockReporter.On("Report", mock.Anything, mock.Anything, mock.Anything).Return(nil).Once().Run(func(args mock.Arguments) {
+		gauges = args.Get(1).(map[string]float64)
+		counters = args.Get(2).(map[string]int64)
+	})
+	mockReporter.On("Close").Return(nil).Once()
 
-	// waiting for stop
-	time.Sleep(time.Second * 1)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	require.NoError(t, controller.Stop(ctx))
 
-	gauges, counters := controller.getMetrics()
 	require.Greater(t, len(gauges), 0)
 	require.Greater(t, len(counters), 0)
 
@@ -39,7 +66,12 @@ func TestControllerPolling(t *testing.T) {
 		require.Contains(t, counters, m)
 	}
 
-	require.Equal(t, int64(pollIntervalsCount), counters["PollCount"])
+	// the final collection on stop adds one more poll
+	require.Equal(t, int64(pollIntervalsCount+1), counters["PollCount"])
+
+	// reported counters are reset
+	_, counters = controller.getMetrics()
+	require.Empty(t, counters)
 }
 
 var allGaugeMetrics = []string{
@@ -76,3 +108,163 @@ var allGaugeMetrics = []string{
 var allCounterMetrics = []string{
 	"PollCount",
 }
+
+type fakeCollector struct {
+	delta int64
+}
+
+func (c *fakeCollector) Collect() (map[string]float64, map[string]int64, error) {
+	return nil, map[string]int64{"Requests": c.delta}, nil
+}
+
+func TestControllerReportsCounterDeltas(t *testing.T) {
+	mockReporter := mockreporter.NewReporter(t)
+	pipeline, err := relabel.New(nil)
+	require.NoError(t, err)
+
+	collector := &fakeCollector{delta: 5}
+	controller := New(mockReporter, pipeline, pollingInterval, reportInterval, collector)
+	ctx := context.Background()
+
+	// the server adds reported values, so only increments since the previous report are sent
+	mockReporter.On("Report", ctx, map[string]float64{}, map[string]int64{"Requests": 5}).Return(nil).Twice()
+
+	for i := 0; i < 2; i++ {
+		controller.collectExternalMetrics(collector)
+		require.NoError(t, controller.report(ctx))
+	}
+
+	// increments of the failed report are sent with the next one
+	reportErr := errors.New("server is unavailable")
+	mockReporter.On("Report", ctx, map[string]float64{}, map[string]int64{"Requests": 3}).Return(reportErr).Once()
+	mockReporter.On("Report", ctx, map[string]float64{}, map[string]int64{"Requests": 10}).Return(nil).Once()
+
+	collector.delta = 3
+	controller.collectExternalMetrics(collector)
+	require.ErrorIs(t, controller.report(ctx), reportErr)
+
+	collector.delta = 7
+	controller.collectExternalMetrics(collector)
+	require.NoError(t, controller.report(ctx))
+}
+
+func TestControllerStopReportsFlushError(t *testing.T) {
+	mockReporter := mockreporter.NewReporter(t)
+	pipeline, err := relabel.New(nil)
+	require.NoError(t, err)
+
+	controller := New(mockReporter, pipeline, pollingInterval, reportInterval)
+
+	go controller.Start()
+
+	flushErr := errors.New("server is unavailable")
+	mockReporter.On("Report", mock.Anything, mock.Anything, mock.Anything).Return(flushErr).Once()
+	mockReporter.On("Close").Return(nil).Once()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	require.ErrorIs(t, controller.Stop(ctx), flushErr)
+
+	// the repeated stop doesn't report metrics again
+	require.ErrorIs(t, controller.Stop(ctx), flushErr)
+}
+
+func TestControllerStopWaitsForReport(t *testing.T) {
+	mockReporter := mockreporter.NewReporter(t)
+	pipeline, err := relabel.New(nil)
+	require.NoError(t, err)
+
+	controller := New(mockReporter, pipeline, pollingInterval, 1)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	interrupted := false
+
+	// the report of the ticker is in progress on stop
+	mockReporter.On("Report", mock.Anything, mock.Anything, mock.Anything).Return(nil).Once().Run(func(args mock.Arguments) {
+		close(started)
+
+		select {
+		case <-release:
+		case <-args.Get(0).(context.Context).Done():
+			interrupted = true
+		}
+	})
+	mockReporter.On("Report", mock.Anything, mock.Anything, mock.Anything).Return(nil).Once()
+	mockReporter.On("Close").Return(nil).Once()
+
+	go controller.Start()
+	<-started
+
+	time.AfterFunc(time.Millisecond*100, func() { close(release) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	require.NoError(t, controller.Stop(ctx))
+	require.False(t, interrupted)
+}
+
+type countersCollector map[string]int64
+
+func (c countersCollector) Collect() (map[string]float64, map[string]int64, error) {
+	return nil, c, nil
+}
+
+func TestControllerKeepsCountersOfFailedChunks(t *testing.T) {
+	lock := sync.Mutex{}
+	requests := 0
+	totals := make(map[string]int64)
+
+	// the server rejects the second chunk of the first report
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		requests++
+		if requests == 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		body, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+
+		metrics := make([]*metric.Metric, 0)
+		require.NoError(t, json.NewDecoder(body).Decode(&metrics))
+
+		for _, m := range metrics {
+			totals[m.ID] += *m.Delta
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	rep, err := reporter.New(config.Config{
+		Hostport:         strings.TrimPrefix(server.URL, "http://"),
+		RetryMaxAttempts: 1,
+		MaxBatchMetrics:  1,
+	}, instance.Identity{})
+	require.NoError(t, err)
+
+	pipeline, err := relabel.New(nil)
+	require.NoError(t, err)
+
+	collector := countersCollector{"Requests": 1, "Errors": 2, "Retries": 3}
+	controller := New(rep, pipeline, pollingInterval, reportInterval, collector)
+	ctx := context.Background()
+
+	controller.collectExternalMetrics(collector)
+
+	var partial *reporter.PartialReportError
+	require.ErrorAs(t, controller.report(ctx), &partial)
+	require.Equal(t, 3, requests)
+
+	controller.collectExternalMetrics(collector)
+	require.NoError(t, controller.report(ctx))
+
+	// counters of applied chunks aren't sent twice
+	require.Equal(t, map[string]int64{"Requests": 2, "Errors": 4, "Retries": 6}, totals)
+}
@@ -0,0 +1,205 @@
+// package relabel - implements filtering and relabeling of collected metrics before reporting
+package relabel
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/kuzhukin/metrics-collector/internal/agent/config"
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+)
+
+type Action string
+
+const (
+	// keeps only metrics which names match the regex
+	Keep Action = "keep"
+	// drops metrics which names match the regex
+	Drop Action = "drop"
+	// renames metrics which names match the regex, replacement can use capture groups
+	Rename Action = "rename"
+	// adds static prefix to names of metrics which match the regex
+	Prefix Action = "prefix"
+	// changes kind of metrics which names match the regex
+	Coerce Action = "coerce"
+)
+
+var ErrUnknownAction = errors.New("unknown relabel action")
+var ErrBadRule = errors.New("bad relabel rule")
+
+type rule struct {
+	action      Action
+	regex       *regexp.Regexp
+	replacement string
+	prefix      string
+	kind        metric.Kind
+}
+
+// Pipeline - ordered list of relabeling rules
+type Pipeline struct {
+	rules []*rule
+}
+
+// New - compiles rules from config to the pipeline
+func New(rules []config.RelabelRule) (*Pipeline, error) {
+	pipeline := &Pipeline{rules: make([]*rule, 0, len(rules))}
+
+	for i, r := range rules {
+		compiled, err := compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("compile rule #%d, err=%w", i, err)
+		}
+
+		pipeline.rules = append(pipeline.rules, compiled)
+	}
+
+	return pipeline, nil
+}
+
+func compile(r config.RelabelRule) (*rule, error) {
+	expr := r.Regex
+	if expr == "" {
+		expr = ".*"
+	}
+
+	regex, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("compile regex=%s, err=%w", r.Regex, err)
+	}
+
+	compiled := &rule{
+		action:      Action(r.Action),
+		regex:       regex,
+		replacement: r.Replacement,
+		prefix:      r.Prefix,
+		kind:        metric.Kind(r.Kind),
+	}
+
+	switch compiled.action {
+	case Keep, Drop:
+	case Rename:
+		if compiled.replacement == "" {
+			return nil, fmt.Errorf("rename without replacement, err=%w", ErrBadRule)
+		}
+	case Prefix:
+		if compiled.prefix == "" {
+			return nil, fmt.Errorf("prefix without prefix value, err=%w", ErrBadRule)
+		}
+	case Coerce:
+		if compiled.kind != metric.Gauge && compiled.kind != metric.Counter {
+			return nil, fmt.Errorf("coerce to kind=%s, err=%w", r.Kind, ErrBadRule)
+		}
+	default:
+		return nil, fmt.Errorf("action=%s, err=%w", r.Action, ErrUnknownAction)
+	}
+
+	return compiled, nil
+}
+
+// Apply - runs all rules over the metrics and returns new collections, input maps aren't changed.
+// Metrics are relabeled in the order of their kinds (gauges, then counters) and names, if several metrics get
+// the same name, counters are summed and the gauge which is relabeled first is kept, so the result doesn't depend on
+// the order of maps
+func (p *Pipeline) Apply(gaugeMetrics map[string]float64, counterMetrics map[string]int64) (map[string]float64, map[string]int64) {
+	gauges := make(map[string]float64, len(gaugeMetrics))
+	counters := make(map[string]int64, len(counterMetrics))
+
+	for _, name := range sortedNames(gaugeMetrics) {
+		if m, ok := p.apply(sample{name: name, kind: metric.Gauge, gauge: gaugeMetrics[name]}); ok {
+			m.store(gauges, counters)
+		}
+	}
+
+	for _, name := range sortedNames(counterMetrics) {
+		if m, ok := p.apply(sample{name: name, kind: metric.Counter, counter: counterMetrics[name]}); ok {
+			m.store(gauges, counters)
+		}
+	}
+
+	return gauges, counters
+}
+
+func sortedNames[T any](metrics map[string]T) []string {
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Target - returns name and kind of the metric after relabeling, false if the metric is dropped
+func (p *Pipeline) Target(name string, kind metric.Kind) (string, metric.Kind, bool) {
+	s, ok := p.apply(sample{name: name, kind: kind})
+	return s.name, s.kind, ok
+}
+
+func (p *Pipeline) apply(s sample) (sample, bool) {
+	for _, r := range p.rules {
+		matched := r.regex.MatchString(s.name)
+
+		switch r.action {
+		case Keep:
+			if !matched {
+				return s, false
+			}
+		case Drop:
+			if matched {
+				return s, false
+			}
+		case Rename:
+			if matched {
+				s.name = r.regex.ReplaceAllString(s.name, r.replacement)
+			}
+		case Prefix:
+			if matched {
+				s.name = r.prefix + s.name
+			}
+		case Coerce:
+			if matched {
+				s = s.coerce(r.kind)
+			}
+		}
+	}
+
+	return s, s.name != ""
+}
+
+type sample struct {
+	name    string
+	kind    metric.Kind
+	gauge   float64
+	counter int64
+}
+
+func (s sample) coerce(kind metric.Kind) sample {
+	if s.kind == kind {
+		return s
+	}
+
+	switch kind {
+	case metric.Gauge:
+		s.gauge = float64(s.counter)
+	case metric.Counter:
+		s.counter = int64(s.gauge)
+	}
+
+	s.kind = kind
+
+	return s
+}
+
+func (s sample) store(gauges map[string]float64, counters map[string]int64) {
+	switch s.kind {
+	case metric.Gauge:
+		if _, ok := gauges[s.name]; !ok {
+			gauges[s.name] = s.gauge
+		}
+	case metric.Counter:
+		counters[s.name] += s.counter
+	}
+}
@@ -0,0 +1,170 @@
+package relabel
+
+import (
+	"testing"
+
+	"github.com/kuzhukin/metrics-collector/internal/agent/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipelineApply(t *testing.T) {
+	tests := []struct {
+		name             string
+		rules            []config.RelabelRule
+		gauges           map[string]float64
+		counters         map[string]int64
+		expectedGauges   map[string]float64
+		expectedCounters map[string]int64
+	}{
+		{
+			name:             "without rules",
+			gauges:           map[string]float64{"Alloc": 1.5},
+			counters:         map[string]int64{"PollCount": 2},
+			expectedGauges:   map[string]float64{"Alloc": 1.5},
+			expectedCounters: map[string]int64{"PollCount": 2},
+		},
+		{
+			name:             "drop",
+			rules:            []config.RelabelRule{{Action: "drop", Regex: "Lookups|MCache.*"}},
+			gauges:           map[string]float64{"Alloc": 1, "Lookups": 2, "MCacheSys": 3, "MCacheInuse": 4},
+			expectedGauges:   map[string]float64{"Alloc": 1},
+			expectedCounters: map[string]int64{},
+		},
+		{
+			name:             "keep",
+			rules:            []config.RelabelRule{{Action: "keep", Regex: "Heap.*"}},
+			gauges:           map[string]float64{"Alloc": 1, "HeapAlloc": 2},
+			counters:         map[string]int64{"PollCount": 2},
+			expectedGauges:   map[string]float64{"HeapAlloc": 2},
+			expectedCounters: map[string]int64{},
+		},
+		{
+			name:             "regex matches the whole name",
+			rules:            []config.RelabelRule{{Action: "drop", Regex: "Alloc"}},
+			gauges:           map[string]float64{"Alloc": 1, "HeapAlloc": 2},
+			expectedGauges:   map[string]float64{"HeapAlloc": 2},
+			expectedCounters: map[string]int64{},
+		},
+		{
+			name:             "rename",
+			rules:            []config.RelabelRule{{Action: "rename", Regex: "Alloc", Replacement: "go_heap_alloc_bytes"}},
+			gauges:           map[string]float64{"Alloc": 1, "HeapAlloc": 2},
+			expectedGauges:   map[string]float64{"go_heap_alloc_bytes": 1, "HeapAlloc": 2},
+			expectedCounters: map[string]int64{},
+		},
+		{
+			name:             "rename with capture groups",
+			rules:            []config.RelabelRule{{Action: "rename", Regex: "CPUutilization(\\d+)", Replacement: "cpu_${1}_percent"}},
+			gauges:           map[string]float64{"CPUutilization0": 10, "CPUutilization1": 20},
+			expectedGauges:   map[string]float64{"cpu_0_percent": 10, "cpu_1_percent": 20},
+			expectedCounters: map[string]int64{},
+		},
+		{
+			name:             "prefix",
+			rules:            []config.RelabelRule{{Action: "prefix", Regex: "Heap.*", Prefix: "go_"}},
+			gauges:           map[string]float64{"Alloc": 1, "HeapAlloc": 2},
+			counters:         map[string]int64{"PollCount": 2},
+			expectedGauges:   map[string]float64{"Alloc": 1, "go_HeapAlloc": 2},
+			expectedCounters: map[string]int64{"PollCount": 2},
+		},
+		{
+			name: "coerce",
+			rules: []config.RelabelRule{
+				{Action: "coerce", Regex: "NumGC", Kind: "counter"},
+				{Action: "coerce", Regex: "PollCount", Kind: "gauge"},
+			},
+			gauges:           map[string]float64{"NumGC": 7.9},
+			counters:         map[string]int64{"PollCount": 2},
+			expectedGauges:   map[string]float64{"PollCount": 2},
+			expectedCounters: map[string]int64{"NumGC": 7},
+		},
+		{
+			name: "rules are applied in order",
+			rules: []config.RelabelRule{
+				{Action: "rename", Regex: "Alloc", Replacement: "go_heap_alloc_bytes"},
+				{Action: "keep", Regex: "go_.*"},
+			},
+			gauges:           map[string]float64{"Alloc": 1, "HeapAlloc": 2},
+			expectedGauges:   map[string]float64{"go_heap_alloc_bytes": 1},
+			expectedCounters: map[string]int64{},
+		},
+		{
+			name:             "gauge with the first name is kept after renaming to the same name",
+			rules:            []config.RelabelRule{{Action: "rename", Regex: "Heap.*|Alloc", Replacement: "heap"}},
+			gauges:           map[string]float64{"HeapSys": 3, "HeapAlloc": 2, "Alloc": 1, "HeapIdle": 4},
+			expectedGauges:   map[string]float64{"heap": 1},
+			expectedCounters: map[string]int64{},
+		},
+		{
+			name: "gauge is kept if coerced counter gets its name",
+			rules: []config.RelabelRule{
+				{Action: "coerce", Regex: "PollCount", Kind: "gauge"},
+				{Action: "rename", Regex: "PollCount", Replacement: "Alloc"},
+			},
+			gauges:           map[string]float64{"Alloc": 1},
+			counters:         map[string]int64{"PollCount": 2},
+			expectedGauges:   map[string]float64{"Alloc": 1},
+			expectedCounters: map[string]int64{},
+		},
+		{
+			name:             "counters with the same name are summed",
+			rules:            []config.RelabelRule{{Action: "rename", Regex: "(.*)Count", Replacement: "count"}},
+			counters:         map[string]int64{"PollCount": 2, "GCCount": 3},
+			expectedGauges:   map[string]float64{},
+			expectedCounters: map[string]int64{"count": 5},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pipeline, err := New(test.rules)
+			require.NoError(t, err)
+
+			// the result doesn't depend on the order of maps
+			for i := 0; i < 10; i++ {
+				gauges, counters := pipeline.Apply(test.gauges, test.counters)
+				require.Equal(t, test.expectedGauges, gauges)
+				require.Equal(t, test.expectedCounters, counters)
+			}
+		})
+	}
+}
+
+func TestPipelineBadRules(t *testing.T) {
+	tests := []struct {
+		name          string
+		rule          config.RelabelRule
+		expectedError error
+	}{
+		{
+			name:          "unknown action",
+			rule:          config.RelabelRule{Action: "replace"},
+			expectedError: ErrUnknownAction,
+		},
+		{
+			name:          "rename without replacement",
+			rule:          config.RelabelRule{Action: "rename", Regex: "Alloc"},
+			expectedError: ErrBadRule,
+		},
+		{
+			name:          "prefix without prefix",
+			rule:          config.RelabelRule{Action: "prefix"},
+			expectedError: ErrBadRule,
+		},
+		{
+			name:          "coerce to unknown kind",
+			rule:          config.RelabelRule{Action: "coerce", Kind: "histogram"},
+			expectedError: ErrBadRule,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := New([]config.RelabelRule{test.rule})
+			require.ErrorIs(t, err, test.expectedError)
+		})
+	}
+
+	_, err := New([]config.RelabelRule{{Action: "drop", Regex: "("}})
+	require.Error(t, err)
+}
@@ -0,0 +1,63 @@
+package reporter
+
+import (
+	"fmt"
+
+	"github.com/kuzhukin/metrics-collector/internal/zlog"
+)
+
+// chunk of metrics with its encoded payload
+type chunk[T any] struct {
+	items   []T
+	payload []byte
+}
+
+// splitIntoChunks - splits items into chunks with at most maxCount items and encoded payload size at most maxSize,
+// chunks which exceed the size are divided in half, zero limits mean no limit
+func splitIntoChunks[T any](items []T, maxCount int, maxSize int, encode func([]T) ([]byte, error)) ([]chunk[T], error) {
+	chunks := make([]chunk[T], 0, 1)
+
+	if maxCount <= 0 || maxCount > len(items) {
+		maxCount = len(items)
+	}
+
+	for start := 0; start < len(items); start += maxCount {
+		finish := start + maxCount
+		if finish > len(items) {
+			finish = len(items)
+		}
+
+		var err error
+		chunks, err = splitBySize(items[start:finish], maxSize, encode, chunks)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return chunks, nil
+}
+
+func splitBySize[T any](items []T, maxSize int, encode func([]T) ([]byte, error), acc []chunk[T]) ([]chunk[T], error) {
+	payload, err := encode(items)
+	if err != nil {
+		return nil, fmt.Errorf("encode chunk, err=%w", err)
+	}
+
+	if maxSize <= 0 || len(payload) <= maxSize {
+		return append(acc, chunk[T]{items: items, payload: payload}), nil
+	}
+
+	if len(items) == 1 {
+		zlog.Logger.Warnf("single metric payload size=%d exceeds max size=%d", len(payload), maxSize)
+		return append(acc, chunk[T]{items: items, payload: payload}), nil
+	}
+
+	middle := len(items) / 2
+
+	acc, err = splitBySize(items[:middle], maxSize, encode, acc)
+	if err != nil {
+		return nil, err
+	}
+
+	return splitBySize(items[middle:], maxSize, encode, acc)
+}
@@ -0,0 +1,88 @@
+package reporter
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitIntoChunks(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+
+	// every item is encoded to 2 bytes
+	encode := func(items []int) ([]byte, error) {
+		return make([]byte, len(items)*2), nil
+	}
+
+	tests := []struct {
+		name     string
+		maxCount int
+		maxSize  int
+		expected [][]int
+	}{
+		{
+			name:     "without limits",
+			expected: [][]int{items},
+		},
+		{
+			name:     "by count",
+			maxCount: 4,
+			expected: [][]int{{1, 2, 3, 4}, {5, 6, 7, 8}, {9, 10}},
+		},
+		{
+			name:     "by size",
+			maxSize:  8,
+			expected: [][]int{{1, 2}, {3, 4, 5}, {6, 7}, {8, 9, 10}},
+		},
+		{
+			name:     "by count and size",
+			maxCount: 5,
+			maxSize:  6,
+			expected: [][]int{{1, 2}, {3, 4, 5}, {6, 7}, {8, 9, 10}},
+		},
+		{
+			name:     "single item exceeds size",
+			maxSize:  1,
+			expected: [][]int{{1}, {2}, {3}, {4}, {5}, {6}, {7}, {8}, {9}, {10}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chunks, err := splitIntoChunks(items, test.maxCount, test.maxSize, encode)
+			require.NoError(t, err)
+
+			actual := make([][]int, 0, len(chunks))
+			for _, c := range chunks {
+				actual = append(actual, c.items)
+				require.Len(t, c.payload, len(c.items)*2)
+			}
+
+			require.Equal(t, test.expected, actual)
+		})
+	}
+}
+
+func TestEncodeBatchChunks(t *testing.T) {
+	gauges := make(map[string]float64)
+	for i := 0; i < 500; i++ {
+		gauges[fmt.Sprintf("metric-%d", i)] = rand.Float64()
+	}
+
+	metrics := prepareUpdate(gauges, nil)
+
+	const maxSize = 1024
+	chunks, err := splitIntoChunks(metrics, 0, maxSize, encodeBatch)
+	require.NoError(t, err)
+	require.Greater(t, len(chunks), 1)
+
+	count := 0
+	for _, c := range chunks {
+		require.LessOrEqual(t, len(c.payload), maxSize)
+		count += len(c.items)
+	}
+
+	require.Equal(t, len(metrics), count)
+}
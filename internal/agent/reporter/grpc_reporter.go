@@ -2,51 +2,363 @@ package reporter
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
 	"time"
 
 	"github.com/kuzhukin/metrics-collector/internal/agent/config"
+	"github.com/kuzhukin/metrics-collector/internal/agent/reporter/retry"
+	"github.com/kuzhukin/metrics-collector/internal/crypto"
+	"github.com/kuzhukin/metrics-collector/internal/idempotency"
+	"github.com/kuzhukin/metrics-collector/internal/instance"
+	"github.com/kuzhukin/metrics-collector/internal/metric"
 	pb "github.com/kuzhukin/metrics-collector/internal/proto"
-	"github.com/kuzhukin/metrics-collector/internal/zlog"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 type grpcReporterImpl struct {
-	hostport string
+	// long-lived connection to the server
+	conn     *grpc.ClientConn
+	client   pb.MetricsServiceClient
+	identity instance.Identity
+	retry    *retry.Policy
+
+	// deadline of the single call
+	timeout time.Duration
+
+	// limits of the single request
+	maxBatchMetrics int
+	maxPayloadSize  int
+
+	// metrics are sent to the long-lived stream instead of batch requests if it's set
+	stream *metricsStream
 }
 
-func newGRPCReporter(config config.Config) (*grpcReporterImpl, error) {
-	return &grpcReporterImpl{
-		hostport: config.Hostport,
-	}, nil
+// options are added to default dial options, they are used for dialing of in-process servers in tests
+func newGRPCReporter(
+	config config.Config,
+	identity instance.Identity,
+	retryPolicy *retry.Policy,
+	opts ...grpc.DialOption,
+) (*grpcReporterImpl, error) {
+	creds, err := makeTransportCredentials(config)
+	if err != nil {
+		return nil, fmt.Errorf("make transport credentials err=%w", err)
+	}
+
+	interceptor, err := newPayloadInterceptor(config, identity)
+	if err != nil {
+		return nil, fmt.Errorf("new payload interceptor err=%w", err)
+	}
+
+	if config.GRPCStreaming && interceptor.encryptor != nil {
+		return nil, errors.New("grpc streaming doesn't support encryption")
+	}
+
+	dialOptions := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithUnaryInterceptor(interceptor.intercept),
+		grpc.WithStreamInterceptor(interceptor.interceptStream),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                time.Second * time.Duration(config.GRPCKeepalive),
+			Timeout:             time.Millisecond * time.Duration(config.GRPCTimeout),
+			PermitWithoutStream: true,
+		}),
+		grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff:           backoff.DefaultConfig,
+			MinConnectTimeout: time.Millisecond * time.Duration(config.GRPCTimeout),
+		}),
+	}
+
+	target := config.GRPCAddress
+	if target == "" {
+		target = config.Hostport
+	}
+
+	// the connection is established in background and is reconnected with backoff on failures
+	conn, err := grpc.Dial(target, append(dialOptions, opts...)...)
+	if err != nil {
+		return nil, fmt.Errorf("grpc dial target=%s, err=%w", target, err)
+	}
+
+	reporter := &grpcReporterImpl{
+		conn:     conn,
+		client:   pb.NewMetricsServiceClient(conn),
+		identity: identity,
+		retry:    retryPolicy,
+		timeout:  time.Millisecond * time.Duration(config.GRPCTimeout),
+
+		maxBatchMetrics: config.MaxBatchMetrics,
+		maxPayloadSize:  config.MaxPayloadSize,
+	}
+
+	if config.GRPCStreaming {
+		reporter.stream = newMetricsStream(reporter.client, retryPolicy, reporter.timeout, func() context.Context {
+			return reporter.withIdentity(context.Background())
+		})
+	}
+
+	return reporter, nil
 }
 
-func (r *grpcReporterImpl) Report(gaugeMetrics map[string]float64, counterMetrics map[string]int64) {
-	metrics := preparePbMetric(gaugeMetrics, counterMetrics)
+// TLS is enabled if CA certificate or client certificate is configured
+func makeTransportCredentials(config config.Config) (credentials.TransportCredentials, error) {
+	if config.GRPCCACert == "" && config.GRPCClientCert == "" {
+		return insecure.NewCredentials(), nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if config.GRPCCACert != "" {
+		pem, err := os.ReadFile(config.GRPCCACert)
+		if err != nil {
+			return nil, fmt.Errorf("read ca cert file=%s, err=%w", config.GRPCCACert, err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ca cert file=%s doesn't contain certificates", config.GRPCCACert)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.GRPCClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(config.GRPCClientCert, config.GRPCClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("load client cert=%s, err=%w", config.GRPCClientCert, err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// signs and encrypts requests like HTTP reporter does, sends agent's address for trusted subnet check
+type payloadInterceptor struct {
+	tokenKey  []byte
+	encryptor *crypto.Encryptor
+	ipAddr    string
+}
+
+func newPayloadInterceptor(config config.Config, identity instance.Identity) (*payloadInterceptor, error) {
+	interceptor := &payloadInterceptor{ipAddr: config.RealIP}
+
+	if config.SingnatureKey != "" {
+		interceptor.tokenKey = instance.SigningKey([]byte(config.SingnatureKey), identity.ID())
+	}
+
+	if config.CryptoKey != "" {
+		encryptor, err := crypto.NewEncryptor(config.CryptoKey)
+		if err != nil {
+			return nil, fmt.Errorf("new encryptor err=%w", err)
+		}
+
+		interceptor.encryptor = encryptor
+	}
+
+	return interceptor, nil
+}
+
+func (i *payloadInterceptor) intercept(
+	ctx context.Context,
+	method string,
+	req, reply interface{},
+	cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption,
+) error {
+	if i.ipAddr != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, pb.RealIPMetadataKey, i.ipAddr)
+	}
+
+	if i.tokenKey == nil && i.encryptor == nil {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return retry.Permanent(fmt.Errorf("request of method=%s isn't proto message", method))
+	}
 
-	conn, err := grpc.Dial(":3200", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	data, err := pb.Marshal(msg)
 	if err != nil {
-		zlog.Logger.Errorf("grpc dial err %s", err)
+		return retry.Permanent(fmt.Errorf("marshal request err=%w", err))
+	}
+
+	// the server checks signature of the decrypted request
+	if i.tokenKey != nil {
+		hasher := hmac.New(sha256.New, i.tokenKey)
+		hasher.Write(data)
+
+		ctx = metadata.AppendToOutgoingContext(ctx, pb.SignatureMetadataKey, hex.EncodeToString(hasher.Sum(nil)))
+	}
 
-		return
+	if i.encryptor != nil && pb.Sealable(msg) {
+		msg, err = pb.Seal(msg, data, i.encryptor.Encrypt)
+		if err != nil {
+			return retry.Permanent(fmt.Errorf("seal request err=%w", err))
+		}
 	}
-	defer conn.Close()
 
-	c := pb.NewMetricsServiceClient(conn)
+	return invoker(ctx, method, msg, reply, cc, opts...)
+}
+
+// messages of streams aren't signed and encrypted, only the agent's address is sent
+func (i *payloadInterceptor) interceptStream(
+	ctx context.Context,
+	desc *grpc.StreamDesc,
+	cc *grpc.ClientConn,
+	method string,
+	streamer grpc.Streamer,
+	opts ...grpc.CallOption,
+) (grpc.ClientStream, error) {
+	if i.ipAddr != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, pb.RealIPMetadataKey, i.ipAddr)
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	defer cancel()
+	return streamer(ctx, desc, cc, method, opts...)
+}
+
+func (r *grpcReporterImpl) Report(ctx context.Context, gaugeMetrics map[string]float64, counterMetrics map[string]int64) error {
+	metrics := preparePbMetric(gaugeMetrics, counterMetrics)
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	// the stream doesn't acknowledge metrics, so only gauges are sent to it: lost or repeated gauges are
+	// overwritten by the next report, counters are sent in batches which are applied once by idempotency keys
+	var streamed []*pb.Metric
+	if r.stream != nil {
+		streamed, metrics = splitGauges(metrics)
+	}
 
-	resp, err := c.BatchUpdate(ctx, &pb.BatchUpdateRequest{Metric: metrics})
+	chunks, err := splitIntoChunks(metrics, r.maxBatchMetrics, r.maxPayloadSize, encodePbBatch)
 	if err != nil {
-		zlog.Logger.Errorf("batch update err %s", err)
+		return fmt.Errorf("split metrics into chunks err=%w", err)
+	}
+
+	var joinedErr error
+	failed := make(map[metricKey]struct{})
+	applied := 0
+
+	if len(streamed) != 0 {
+		if err := r.stream.report(ctx, streamed); err != nil {
+			joinedErr = fmt.Errorf("report to stream err=%w", err)
+			addFailed(failed, streamed)
+		} else {
+			applied++
+		}
+	}
+
+	ctx = r.withIdentity(ctx)
+
+	for i, chunk := range chunks {
+		if err := r.batchUpdate(ctx, chunk.items); err != nil {
+			joinedErr = errors.Join(joinedErr, fmt.Errorf("report chunk=%d/%d size=%d, err=%w", i+1, len(chunks), len(chunk.items), err))
+			addFailed(failed, chunk.items)
+
+			continue
+		}
+
+		applied++
+	}
+
+	return partialReportError(joinedErr, applied, failed)
+}
+
+func splitGauges(metrics []*pb.Metric) ([]*pb.Metric, []*pb.Metric) {
+	gauges := make([]*pb.Metric, 0, len(metrics))
+	others := make([]*pb.Metric, 0)
+
+	for _, m := range metrics {
+		if metric.Kind(m.Type) == metric.Gauge {
+			gauges = append(gauges, m)
+		} else {
+			others = append(others, m)
+		}
 	}
 
-	if resp.Error != "" {
-		zlog.Logger.Errorf("batch update upload err %s", err)
+	return gauges, others
+}
+
+func addFailed(failed map[metricKey]struct{}, metrics []*pb.Metric) {
+	for _, m := range metrics {
+		failed[metricKey{name: m.Id, kind: metric.Kind(m.Type)}] = struct{}{}
 	}
+}
+
+func (r *grpcReporterImpl) batchUpdate(ctx context.Context, metrics []*pb.Metric) error {
+	// retries have the same key, so the server doesn't apply the batch twice if the response is lost
+	ctx = metadata.AppendToOutgoingContext(ctx, idempotency.MetadataKey, idempotency.NewKey())
+
+	return r.retry.Do(ctx, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+
+		if _, err := r.client.BatchUpdate(ctx, &pb.BatchUpdateRequest{Metric: metrics}); err != nil {
+			return classifyGRPCError(fmt.Errorf("batch update err=%w", err))
+		}
 
+		return nil
+	})
 }
+
+// the payload is used only for checking of the request size
+func encodePbBatch(metrics []*pb.Metric) ([]byte, error) {
+	return proto.Marshal(&pb.BatchUpdateRequest{Metric: metrics})
+}
+
+// Close - finishes the metrics stream and closes connection to the server
+func (r *grpcReporterImpl) Close() error {
+	var joinedErr error
+
+	if r.stream != nil {
+		joinedErr = r.stream.close()
+	}
+
+	return errors.Join(joinedErr, r.conn.Close())
+}
+
+// unavailability of the server and exhausted resources are retryable
+func classifyGRPCError(err error) error {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted, codes.DeadlineExceeded:
+		return err
+	default:
+		return retry.Permanent(err)
+	}
+}
+
+func (r *grpcReporterImpl) withIdentity(ctx context.Context) context.Context {
+	kv := []string{instance.HostnameMetadataKey, r.identity.Hostname}
+
+	if r.identity.ID() != "" {
+		kv = append(kv, instance.IDMetadataKey, r.identity.ID())
+	}
+
+	if r.identity.MachineID != "" {
+		kv = append(kv, instance.MachineIDMetadataKey, r.identity.MachineID)
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
+
 func preparePbMetric(gaugeMetrics map[string]float64, counterMetrics map[string]int64) []*pb.Metric {
 	metrics := make([]*pb.Metric, 0, len(gaugeMetrics)+len(counterMetrics))
 
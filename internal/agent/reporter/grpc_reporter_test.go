@@ -0,0 +1,315 @@
+package reporter
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kuzhukin/metrics-collector/internal/agent/config"
+	"github.com/kuzhukin/metrics-collector/internal/agent/reporter/retry"
+	"github.com/kuzhukin/metrics-collector/internal/instance"
+	pb "github.com/kuzhukin/metrics-collector/internal/proto"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+type testMetricsServer struct {
+	pb.UnimplementedMetricsServiceServer
+
+	calls  int32
+	codes  []codes.Code
+	dialed int32
+
+	received chan *pb.BatchUpdateRequest
+	ids      chan string
+}
+
+func (s *testMetricsServer) BatchUpdate(ctx context.Context, req *pb.BatchUpdateRequest) (*pb.BatchUpdateResponse, error) {
+	call := atomic.AddInt32(&s.calls, 1)
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(instance.IDMetadataKey)) > 0 {
+		s.ids <- md.Get(instance.IDMetadataKey)[0]
+	}
+
+	if int(call) <= len(s.codes) && s.codes[call-1] != codes.OK {
+		return nil, status.Error(s.codes[call-1], "test error")
+	}
+
+	s.received <- req
+
+	return &pb.BatchUpdateResponse{}, nil
+}
+
+func startTestGRPCServer(t *testing.T, server *testMetricsServer) grpc.DialOption {
+	listener := bufconn.Listen(1024 * 1024)
+
+	grpcServer := grpc.NewServer()
+	pb.RegisterMetricsServiceServer(grpcServer, server)
+
+	go func() {
+		_ = grpcServer.Serve(listener)
+	}()
+
+	t.Cleanup(grpcServer.Stop)
+
+	return grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		atomic.AddInt32(&server.dialed, 1)
+
+		return listener.DialContext(ctx)
+	})
+}
+
+func newTestGRPCReporter(t *testing.T, dialer grpc.DialOption) *grpcReporterImpl {
+	reporter, err := newGRPCReporter(
+		config.Config{
+			GRPCAddress:     "bufnet",
+			GRPCTimeout:     1000,
+			GRPCKeepalive:   30,
+			MaxBatchMetrics: 1000,
+			MaxPayloadSize:  1 << 20,
+		},
+		instance.Identity{Hostname: "host-1", InstanceID: "agent-1"},
+		retry.New(3, time.Millisecond, time.Millisecond*10, retry.NewBreaker(0, 0)),
+		dialer,
+	)
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = reporter.Close() })
+
+	return reporter
+}
+
+func TestGRPCReporterReusesConnection(t *testing.T) {
+	server := &testMetricsServer{received: make(chan *pb.BatchUpdateRequest, 10), ids: make(chan string, 10)}
+	reporter := newTestGRPCReporter(t, startTestGRPCServer(t, server))
+
+	for i := 0; i < 3; i++ {
+		err := reporter.Report(context.Background(), map[string]float64{"Alloc": 1}, map[string]int64{"PollCount": 1})
+		require.NoError(t, err)
+
+		req := <-server.received
+		require.Len(t, req.Metric, 2)
+		require.Equal(t, "agent-1", <-server.ids)
+	}
+
+	require.Equal(t, int32(1), atomic.LoadInt32(&server.dialed))
+}
+
+func TestGRPCReporterRetries(t *testing.T) {
+	tests := []struct {
+		name          string
+		codes         []codes.Code
+		expectedCalls int32
+		expectedError bool
+	}{
+		{
+			name:          "unavailable is retried",
+			codes:         []codes.Code{codes.Unavailable, codes.ResourceExhausted, codes.OK},
+			expectedCalls: 3,
+		},
+		{
+			name:          "invalid argument isn't retried",
+			codes:         []codes.Code{codes.InvalidArgument},
+			expectedCalls: 1,
+			expectedError: true,
+		},
+		{
+			name:          "attempts limit",
+			codes:         []codes.Code{codes.Unavailable, codes.Unavailable, codes.Unavailable},
+			expectedCalls: 3,
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := &testMetricsServer{
+				codes:    test.codes,
+				received: make(chan *pb.BatchUpdateRequest, 10),
+				ids:      make(chan string, 10),
+			}
+			reporter := newTestGRPCReporter(t, startTestGRPCServer(t, server))
+
+			err := reporter.Report(context.Background(), map[string]float64{"Alloc": 1}, nil)
+			if test.expectedError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			require.Equal(t, test.expectedCalls, atomic.LoadInt32(&server.calls))
+		})
+	}
+}
+
+func TestMakeTransportCredentials(t *testing.T) {
+	creds, err := makeTransportCredentials(config.Config{})
+	require.NoError(t, err)
+	require.Equal(t, "insecure", creds.Info().SecurityProtocol)
+
+	_, err = makeTransportCredentials(config.Config{GRPCCACert: "/not/existing/ca.pem"})
+	require.Error(t, err)
+}
+
+func TestGRPCReporterSignsRequests(t *testing.T) {
+	listener := bufconn.Listen(1024 * 1024)
+	received := make(chan metadata.MD, 1)
+
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(
+		func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			md, _ := metadata.FromIncomingContext(ctx)
+
+			data, err := pb.Marshal(req.(proto.Message))
+			require.NoError(t, err)
+
+			hasher := hmac.New(sha256.New, []byte("secret"))
+			hasher.Write(data)
+			md.Set("expected", hex.EncodeToString(hasher.Sum(nil)))
+
+			received <- md
+
+			return handler(ctx, req)
+		},
+	))
+	pb.RegisterMetricsServiceServer(grpcServer, &testMetricsServer{received: make(chan *pb.BatchUpdateRequest, 1), ids: make(chan string, 1)})
+
+	go func() {
+		_ = grpcServer.Serve(listener)
+	}()
+	defer grpcServer.Stop()
+
+	reporter, err := newGRPCReporter(
+		config.Config{
+			GRPCAddress:     "bufnet",
+			GRPCTimeout:     1000,
+			SingnatureKey:   "secret",
+			RealIP:          "192.168.1.10",
+			MaxBatchMetrics: 1000,
+			MaxPayloadSize:  1 << 20,
+		},
+		instance.Identity{Hostname: "host-1"},
+		retry.New(1, time.Millisecond, time.Millisecond, retry.NewBreaker(0, 0)),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+	)
+	require.NoError(t, err)
+	defer reporter.Close()
+
+	require.NoError(t, reporter.Report(context.Background(), map[string]float64{"Alloc": 1}, nil))
+
+	md := <-received
+	require.Equal(t, []string{"192.168.1.10"}, md.Get(pb.RealIPMetadataKey))
+	require.Equal(t, md.Get("expected"), md.Get(pb.SignatureMetadataKey))
+}
+
+type testStreamServer struct {
+	pb.UnimplementedMetricsServiceServer
+
+	streams  int32
+	failed   chan struct{}
+	received chan *pb.Metric
+	batches  chan *pb.BatchUpdateRequest
+}
+
+func (s *testStreamServer) BatchUpdate(_ context.Context, req *pb.BatchUpdateRequest) (*pb.BatchUpdateResponse, error) {
+	s.batches <- req
+
+	return &pb.BatchUpdateResponse{}, nil
+}
+
+// the first stream fails after receiving of the first metric
+func (s *testStreamServer) StreamUpdates(stream pb.MetricsService_StreamUpdatesServer) error {
+	number := atomic.AddInt32(&s.streams, 1)
+	summary := &pb.Summary{}
+
+	for {
+		m, err := stream.Recv()
+		if err != nil {
+			return stream.SendAndClose(summary)
+		}
+
+		summary.Received++
+		s.received <- m
+
+		if number == 1 {
+			close(s.failed)
+			return status.Error(codes.Unavailable, "test error")
+		}
+	}
+}
+
+func TestGRPCReporterStreaming(t *testing.T) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := &testStreamServer{
+		failed:   make(chan struct{}),
+		received: make(chan *pb.Metric, 10),
+		batches:  make(chan *pb.BatchUpdateRequest, 10),
+	}
+
+	grpcServer := grpc.NewServer()
+	pb.RegisterMetricsServiceServer(grpcServer, server)
+
+	go func() {
+		_ = grpcServer.Serve(listener)
+	}()
+	defer grpcServer.Stop()
+
+	reporter, err := newGRPCReporter(
+		config.Config{
+			GRPCAddress:     "bufnet",
+			GRPCTimeout:     1000,
+			GRPCStreaming:   true,
+			MaxBatchMetrics: 1000,
+			MaxPayloadSize:  1 << 20,
+		},
+		instance.Identity{Hostname: "host-1"},
+		retry.New(3, time.Millisecond, time.Millisecond*10, retry.NewBreaker(0, 0)),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+	)
+	require.NoError(t, err)
+
+	require.NoError(t, reporter.Report(context.Background(), map[string]float64{"Sys": 1}, nil))
+	require.Equal(t, "Sys", (<-server.received).Id)
+
+	<-server.failed
+
+	// metrics can be sent to the failed stream until the client receives its status,
+	// then the failure is detected and the metrics are resent to the new stream
+	require.Eventually(t, func() bool {
+		require.NoError(t, reporter.Report(context.Background(), map[string]float64{"Alloc": 1}, nil))
+
+		select {
+		case m := <-server.received:
+			return m.Id == "Alloc"
+		case <-time.After(time.Millisecond * 10):
+			return false
+		}
+	}, time.Second*5, time.Millisecond)
+
+	require.NoError(t, reporter.Report(context.Background(), map[string]float64{"HeapAlloc": 2}, nil))
+	require.Equal(t, "HeapAlloc", (<-server.received).Id)
+
+	// counters aren't sent to the stream, because it doesn't acknowledge them
+	require.NoError(t, reporter.Report(context.Background(), nil, map[string]int64{"PollCount": 1}))
+
+	batch := <-server.batches
+	require.Len(t, batch.Metric, 1)
+	require.Equal(t, "PollCount", batch.Metric[0].Id)
+	require.Empty(t, server.received)
+
+	require.NoError(t, reporter.Close())
+	require.Equal(t, int32(2), atomic.LoadInt32(&server.streams))
+}
@@ -0,0 +1,154 @@
+package reporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/kuzhukin/metrics-collector/internal/agent/reporter/retry"
+	pb "github.com/kuzhukin/metrics-collector/internal/proto"
+	"github.com/kuzhukin/metrics-collector/internal/zlog"
+	"google.golang.org/grpc/status"
+)
+
+// metricsStream - long-lived client stream which is kept open across reports and is reopened after failures,
+// metrics of the failed report are resent to the new stream, the stream doesn't acknowledge metrics,
+// so metrics sent right before the failure of the stream can be lost or applied twice,
+// therefore only gauges are sent to the stream
+type metricsStream struct {
+	sync.Mutex
+
+	client pb.MetricsServiceClient
+	retry  *retry.Policy
+	// deadline of sending of the single report
+	timeout time.Duration
+	// returns base context of the new stream, it carries metadata of the agent
+	newContext func() context.Context
+
+	stream pb.MetricsService_StreamUpdatesClient
+	cancel context.CancelFunc
+}
+
+func newMetricsStream(
+	client pb.MetricsServiceClient,
+	retryPolicy *retry.Policy,
+	timeout time.Duration,
+	newContext func() context.Context,
+) *metricsStream {
+	return &metricsStream{
+		client:     client,
+		retry:      retryPolicy,
+		timeout:    timeout,
+		newContext: newContext,
+	}
+}
+
+func (s *metricsStream) report(ctx context.Context, metrics []*pb.Metric) error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.retry.Do(ctx, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+
+		if err := s.send(ctx, metrics); err != nil {
+			s.reset()
+
+			return classifyGRPCError(err)
+		}
+
+		return nil
+	})
+}
+
+func (s *metricsStream) send(ctx context.Context, metrics []*pb.Metric) error {
+	if s.stream == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+
+	// the stream isn't bound to the report context, so blocked sending is interrupted by the stream cancellation
+	done := make(chan struct{})
+	defer close(done)
+
+	go func(cancelStream context.CancelFunc) {
+		select {
+		case <-ctx.Done():
+			// the report context is also canceled after successful sending
+			select {
+			case <-done:
+			default:
+				cancelStream()
+			}
+		case <-done:
+		}
+	}(s.cancel)
+
+	for _, m := range metrics {
+		if err := s.stream.Send(m); err != nil {
+			if ctx.Err() != nil {
+				return status.FromContextError(ctx.Err()).Err()
+			}
+
+			// the real error of the stream is returned by receiving of the response
+			if errors.Is(err, io.EOF) {
+				_, err = s.stream.CloseAndRecv()
+			}
+
+			return fmt.Errorf("stream send err=%w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *metricsStream) open() error {
+	ctx, cancel := context.WithCancel(s.newContext())
+
+	stream, err := s.client.StreamUpdates(ctx)
+	if err != nil {
+		cancel()
+		return fmt.Errorf("open stream err=%w", err)
+	}
+
+	s.stream = stream
+	s.cancel = cancel
+
+	return nil
+}
+
+func (s *metricsStream) reset() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+
+	s.stream = nil
+	s.cancel = nil
+}
+
+// close - finishes the stream and waits for applying of sent metrics by the server
+func (s *metricsStream) close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.stream == nil {
+		return nil
+	}
+
+	timer := time.AfterFunc(s.timeout, s.cancel)
+	defer timer.Stop()
+	defer s.reset()
+
+	summary, err := s.stream.CloseAndRecv()
+	if err != nil {
+		return fmt.Errorf("close stream err=%w", err)
+	}
+
+	zlog.Logger.Infof("metrics stream closed received=%d applied=%d rejected=%d", summary.Received, summary.Applied, summary.Rejected)
+
+	return nil
+}
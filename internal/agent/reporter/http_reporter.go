@@ -3,32 +3,52 @@ package reporter
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/kuzhukin/metrics-collector/internal/agent/config"
+	"github.com/kuzhukin/metrics-collector/internal/agent/reporter/retry"
 	"github.com/kuzhukin/metrics-collector/internal/crypto"
+	"github.com/kuzhukin/metrics-collector/internal/idempotency"
+	"github.com/kuzhukin/metrics-collector/internal/instance"
 	"github.com/kuzhukin/metrics-collector/internal/metric"
 	"github.com/kuzhukin/metrics-collector/internal/zlog"
 )
 
+const (
+	// max size of the response with rejected metrics
+	maxBatchResultSize = 1024 * 1024
+	// max count of rejections which are logged for the single request
+	maxLoggedRejections = 10
+)
+
 type reporterImpl struct {
 	updateURL string
 	tokenKey  []byte
 	encryptor *crypto.Encryptor
 	ipAddr    string
+	identity  instance.Identity
+	retry     *retry.Policy
+
+	// limits of the single request
+	maxBatchMetrics int
+	maxPayloadSize  int
 }
 
-func newHTTPReporter(config config.Config) (*reporterImpl, error) {
+func newHTTPReporter(config config.Config, identity instance.Identity, retryPolicy *retry.Policy) (*reporterImpl, error) {
 	var key []byte
 
 	if config.SingnatureKey != "" {
-		key = []byte(config.SingnatureKey)
+		key = instance.SigningKey([]byte(config.SingnatureKey), identity.ID())
 	}
 
 	var encryptor *crypto.Encryptor
@@ -46,31 +66,45 @@ func newHTTPReporter(config config.Config) (*reporterImpl, error) {
 		tokenKey:  key,
 		encryptor: encryptor,
 		ipAddr:    config.RealIP,
+		identity:  identity,
+		retry:     retryPolicy,
+
+		maxBatchMetrics: config.MaxBatchMetrics,
+		maxPayloadSize:  config.MaxPayloadSize,
 	}, nil
 }
 
 // sending metrics to server
-func (r *reporterImpl) Report(gaugeMetrics map[string]float64, counterMetrics map[string]int64) {
-	batch := prepareUpdate(gaugeMetrics, counterMetrics)
-	if batch.Len() == 0 {
-		return
+func (r *reporterImpl) Report(ctx context.Context, gaugeMetrics map[string]float64, counterMetrics map[string]int64) error {
+	metrics := prepareUpdate(gaugeMetrics, counterMetrics)
+	if len(metrics) == 0 {
+		return nil
 	}
 
-	if err := r.reportMetrics(batch); err != nil {
-		zlog.Logger.Errorf("report metrics err=%s", err)
+	if err := r.reportMetrics(ctx, metrics); err != nil {
+		return fmt.Errorf("report metrics err=%w", err)
 	}
+
+	return nil
 }
 
-func prepareUpdate(gaugeMetrics map[string]float64, counterMetrics map[string]int64) metric.MetricBatch {
-	batch := metric.NewBatch()
+// Close - closes idle connections to the server
+func (r *reporterImpl) Close() error {
+	http.DefaultClient.CloseIdleConnections()
 
-	batch = prepare(gaugeMetrics, metric.Gauge, batch)
-	batch = prepare(counterMetrics, metric.Counter, batch)
+	return nil
+}
 
-	return batch
+func prepareUpdate(gaugeMetrics map[string]float64, counterMetrics map[string]int64) []*metric.Metric {
+	metrics := make([]*metric.Metric, 0, len(gaugeMetrics)+len(counterMetrics))
+
+	metrics = prepare(gaugeMetrics, metric.Gauge, metrics)
+	metrics = prepare(counterMetrics, metric.Counter, metrics)
+
+	return metrics
 }
 
-func prepare[T int64 | float64](metrics map[string]T, kind metric.Kind, acc metric.MetricBatch) metric.MetricBatch {
+func prepare[T int64 | float64](metrics map[string]T, kind metric.Kind, acc []*metric.Metric) []*metric.Metric {
 	for name, value := range metrics {
 		m, err := metric.New(name, kind, value)
 		if err != nil {
@@ -78,48 +112,111 @@ func prepare[T int64 | float64](metrics map[string]T, kind metric.Kind, acc metr
 			continue
 		}
 
-		acc.Add(m)
+		acc = append(acc, m)
 	}
 
 	return acc
 }
 
-func (r *reporterImpl) reportMetrics(batch metric.MetricBatch) error {
-	data, err := batch.Serialize()
+// metrics are sent in chunks, every chunk is signed, encrypted and retried independently
+func (r *reporterImpl) reportMetrics(ctx context.Context, metrics []*metric.Metric) error {
+	chunks, err := splitIntoChunks(metrics, r.maxBatchMetrics, r.maxPayloadSize, encodeBatch)
 	if err != nil {
-		return fmt.Errorf("metric serializa err=%s", err)
+		return fmt.Errorf("split metrics into chunks err=%w", err)
+	}
+
+	var joinedErr error
+	failed := make(map[metricKey]struct{})
+	applied := 0
+
+	for i, c := range chunks {
+		result, err := r.doReport(ctx, c.payload)
+		if err != nil {
+			joinedErr = errors.Join(joinedErr, fmt.Errorf("do report chunk=%d/%d size=%d, err=%w", i+1, len(chunks), len(c.items), err))
+
+			for _, m := range c.items {
+				failed[metricKey{name: m.ID, kind: m.Type}] = struct{}{}
+			}
+
+			continue
+		}
+
+		applied++
+		logRejections(result)
 	}
 
-	if err := r.doReport(data); err != nil {
-		return fmt.Errorf("do report, err=%w", err)
+	return partialReportError(joinedErr, applied, failed)
+}
+
+// rejected metrics aren't retried, because they are invalid, so they are only logged
+func logRejections(result *metric.BatchResult) {
+	if result == nil || len(result.Rejected) == 0 {
+		return
 	}
 
-	return nil
+	zlog.Logger.Warnf("Server rejected metrics count=%d, accepted=%d", len(result.Rejected), result.Accepted)
+
+	for i, rejection := range result.Rejected {
+		if i == maxLoggedRejections {
+			zlog.Logger.Warnf("Other %d rejections aren't logged", len(result.Rejected)-i)
+			break
+		}
+
+		zlog.Logger.Warnf("Rejected metric id=%s, index=%d, reason=%s, message=%s", rejection.ID, rejection.Index, rejection.Reason, rejection.Message)
+	}
 }
 
-func (r *reporterImpl) doReport(data []byte) error {
+// serializes and compresses batch of metrics
+func encodeBatch(metrics []*metric.Metric) ([]byte, error) {
+	batch := metric.NewBatch()
+	for _, m := range metrics {
+		batch.Add(m)
+	}
+
+	data, err := batch.Serialize()
+	if err != nil {
+		return nil, fmt.Errorf("metric serializa err=%w", err)
+	}
+
 	compressedData, err := compressData(data)
 	if err != nil {
-		return fmt.Errorf("compress data err=%w", err)
+		return nil, fmt.Errorf("compress data err=%w", err)
 	}
 
+	return compressedData, nil
+}
+
+func (r *reporterImpl) doReport(ctx context.Context, compressedData []byte) (*metric.BatchResult, error) {
 	if r.encryptor != nil {
+		var err error
+
 		compressedData, err = r.encryptor.Encrypt(compressedData)
 		if err != nil {
-			return fmt.Errorf("encrypt err=%w", err)
+			return nil, fmt.Errorf("encrypt err=%w", err)
 		}
 	}
 
-	request, err := r.makeUpdateRequest(compressedData)
-	if err != nil {
-		return fmt.Errorf("make update request err=%w", err)
-	}
+	var result *metric.BatchResult
+
+	// retries have the same key, so the server doesn't apply the batch twice if the response is lost
+	key := idempotency.NewKey()
+
+	err := r.retry.Do(ctx, func(ctx context.Context) error {
+		request, err := r.makeUpdateRequest(ctx, compressedData, key)
+		if err != nil {
+			return retry.Permanent(fmt.Errorf("make update request err=%w", err))
+		}
 
-	return doRequest(request)
+		result, err = doRequest(request)
+
+		return err
+	})
+
+	return result, err
 }
 
-func (r *reporterImpl) makeUpdateRequest(data []byte) (*http.Request, error) {
-	req, err := http.NewRequest(http.MethodPost, r.updateURL, bytes.NewReader(data))
+func (r *reporterImpl) makeUpdateRequest(ctx context.Context, data []byte, key string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.updateURL, bytes.NewReader(data))
 	if err != nil {
 		return nil, err
 	}
@@ -127,6 +224,16 @@ func (r *reporterImpl) makeUpdateRequest(data []byte) (*http.Request, error) {
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Content-Encoding", "gzip")
 	req.Header.Set("X-Real-IP", r.ipAddr)
+	req.Header.Set(idempotency.Header, key)
+	req.Header.Set(instance.HostnameHeader, r.identity.Hostname)
+
+	if r.identity.ID() != "" {
+		req.Header.Set(instance.IDHeader, r.identity.ID())
+	}
+
+	if r.identity.MachineID != "" {
+		req.Header.Set(instance.MachineIDHeader, r.identity.MachineID)
+	}
 
 	if r.tokenKey != nil {
 		hash, err := r.hash(data)
@@ -150,37 +257,75 @@ func (r *reporterImpl) hash(data []byte) ([]byte, error) {
 	return hasher.Sum(nil), nil
 }
 
-var tryingIntervals []time.Duration = []time.Duration{
-	time.Millisecond * 1000,
-	time.Millisecond * 3000,
-	time.Millisecond * 5000,
+// network errors, 5xx, 409 and 429 statuses are retryable, 409 means that the previous attempt is in progress, the result is nil if the server doesn't send it
+func doRequest(req *http.Request) (*metric.BatchResult, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// body is drained for reusing of the connection
+	defer func() {
+		_, _ = io.Copy(io.Discard, resp.Body)
+	}()
+
+	result := parseBatchResult(resp)
+
+	err = fmt.Errorf("metrics update request was failed with statusCode=%d", resp.StatusCode)
+
+	if result != nil && len(result.Rejected) > 0 {
+		first := result.Rejected[0]
+		err = fmt.Errorf("%w, rejected=%d, first id=%s, reason=%s", err, len(result.Rejected), first.ID, first.Reason)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return result, nil
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusConflict:
+		return nil, retry.RetryAfter(err, parseRetryAfter(resp.Header.Get("Retry-After")))
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return nil, err
+	default:
+		return nil, retry.Permanent(err)
+	}
+}
+
+// older servers respond without body, so the result is optional
+func parseBatchResult(resp *http.Response) *metric.BatchResult {
+	if resp.Header.Get("Content-Type") != "application/json" {
+		return nil
+	}
+
+	result := &metric.BatchResult{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxBatchResultSize)).Decode(result); err != nil {
+		zlog.Logger.Warnf("Decode batch result, err=%s", err)
+		return nil
+	}
+
+	return result
 }
 
-func doRequest(req *http.Request) error {
-	var joinedError error
-	maxTryingsNum := len(tryingIntervals)
+// Retry-After contains delay in seconds or HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
 
-	for trying := 0; trying <= maxTryingsNum; trying++ {
-		if resp, err := http.DefaultClient.Do(req); err != nil {
-			if trying < maxTryingsNum {
-				joinedError = errors.Join(joinedError, err)
-				time.Sleep(tryingIntervals[trying])
-			}
-		} else {
-			defer resp.Body.Close()
-			if resp.StatusCode != http.StatusOK {
-				return fmt.Errorf("metrics update request was failed with statusCode=%d", resp.StatusCode)
-			}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Second * time.Duration(seconds)
+	}
 
-			return nil
-		}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
 	}
 
-	return fmt.Errorf("request trying limit exceeded, errs=%w", joinedError)
+	return 0
 }
 
+// valid metrics of the batch are stored by the server even if other ones are rejected
 func makeUpdateURL(host string) string {
-	return "http://" + host + batchUpdateEndpoint
+	return "http://" + host + batchUpdateEndpoint + "?partial=true"
 }
 
 func compressData(data []byte) ([]byte, error) {
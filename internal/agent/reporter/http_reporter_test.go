@@ -0,0 +1,144 @@
+package reporter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kuzhukin/metrics-collector/internal/agent/config"
+	"github.com/kuzhukin/metrics-collector/internal/agent/reporter/retry"
+	"github.com/kuzhukin/metrics-collector/internal/idempotency"
+	"github.com/kuzhukin/metrics-collector/internal/instance"
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPReporterRetries(t *testing.T) {
+	tests := []struct {
+		name          string
+		statuses      []int
+		expectedCalls int32
+		expectedError bool
+	}{
+		{
+			name:          "success",
+			statuses:      []int{http.StatusOK},
+			expectedCalls: 1,
+		},
+		{
+			name:          "server error is retried",
+			statuses:      []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK},
+			expectedCalls: 3,
+		},
+		{
+			name:          "too many requests is retried",
+			statuses:      []int{http.StatusTooManyRequests, http.StatusOK},
+			expectedCalls: 2,
+		},
+		{
+			name:          "client error isn't retried",
+			statuses:      []int{http.StatusBadRequest, http.StatusOK},
+			expectedCalls: 1,
+			expectedError: true,
+		},
+		{
+			name:          "attempts limit",
+			statuses:      []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+			expectedCalls: 3,
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calls := int32(0)
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				call := atomic.AddInt32(&calls, 1)
+				require.Equal(t, batchUpdateEndpoint, r.URL.Path)
+				require.Equal(t, "agent-1", r.Header.Get(instance.IDHeader))
+
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(test.statuses[call-1])
+			}))
+			defer server.Close()
+
+			reporter, err := newHTTPReporter(
+				config.Config{Hostport: strings.TrimPrefix(server.URL, "http://")},
+				instance.Identity{Hostname: "host-1", InstanceID: "agent-1"},
+				retry.New(3, time.Millisecond, time.Millisecond*10, retry.NewBreaker(0, 0)),
+			)
+			require.NoError(t, err)
+
+			err = reporter.Report(context.Background(), map[string]float64{"Alloc": 1}, map[string]int64{"PollCount": 1})
+			if test.expectedError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			require.Equal(t, test.expectedCalls, atomic.LoadInt32(&calls))
+		})
+	}
+}
+
+func TestHTTPReporterIdempotencyKey(t *testing.T) {
+	keys := make([]string, 0)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get(idempotency.Header))
+
+		// the first attempt is in progress on the server
+		if len(keys) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusConflict)
+		}
+	}))
+	defer server.Close()
+
+	reporter, err := newHTTPReporter(
+		config.Config{Hostport: strings.TrimPrefix(server.URL, "http://")},
+		instance.Identity{Hostname: "host-1"},
+		retry.New(3, time.Millisecond, time.Millisecond*10, retry.NewBreaker(0, 0)),
+	)
+	require.NoError(t, err)
+
+	require.NoError(t, reporter.Report(context.Background(), map[string]float64{"Alloc": 1}, nil))
+	require.NoError(t, reporter.Report(context.Background(), map[string]float64{"Alloc": 2}, nil))
+
+	require.Len(t, keys, 3)
+	require.NotEmpty(t, keys[0])
+	require.Equal(t, keys[0], keys[1])
+	require.NotEqual(t, keys[1], keys[2])
+}
+
+func TestHTTPReporterPartialAccept(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "true", r.URL.Query().Get("partial"))
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"accepted":1,"rejected":[{"index":1,"id":"PollCount","reason":"missing_value","message":"no value"}]}`))
+	}))
+	defer server.Close()
+
+	request, err := http.NewRequest(http.MethodPost, makeUpdateURL(strings.TrimPrefix(server.URL, "http://")), nil)
+	require.NoError(t, err)
+
+	result, err := doRequest(request)
+	require.NoError(t, err)
+	require.Equal(t, 1, result.Accepted)
+	require.Equal(t, []metric.Rejection{{Index: 1, ID: "PollCount", Reason: metric.RejectMissingValue, Message: "no value"}}, result.Rejected)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	require.Equal(t, time.Duration(0), parseRetryAfter(""))
+	require.Equal(t, time.Second*3, parseRetryAfter("3"))
+	require.Equal(t, time.Duration(0), parseRetryAfter("soon"))
+
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	require.InDelta(t, float64(time.Minute), float64(parseRetryAfter(date)), float64(time.Second*2))
+}
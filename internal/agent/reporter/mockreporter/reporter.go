@@ -2,16 +2,43 @@
 
 package mockreporter
 
-import mock "github.com/stretchr/testify/mock"
+import (
+	context "context"
+
+	mock "github.com/stretchr/testify/mock"
+)
 
 // Reporter is an autogenerated mock type for the Reporter type
 type Reporter struct {
 	mock.Mock
 }
 
-// Report provides a mock function with given fields: gaugeMetrics, counterMetrics
-func (_m *Reporter) Report(gaugeMetrics map[string]float64, counterMetrics map[string]int64) {
-	_m.Called(gaugeMetrics, counterMetrics)
+// Close provides a mock function with given fields:
+func (_m *Reporter) Close() error {
+	ret := _m.Called()
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Report provides a mock function with given fields: ctx, gaugeMetrics, counterMetrics
+func (_m *Reporter) Report(ctx context.Context, gaugeMetrics map[string]float64, counterMetrics map[string]int64) error {
+	ret := _m.Called(ctx, gaugeMetrics, counterMetrics)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, map[string]float64, map[string]int64) error); ok {
+		r0 = rf(ctx, gaugeMetrics, counterMetrics)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
 }
 
 // NewReporter creates a new instance of Reporter. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
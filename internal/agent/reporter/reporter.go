@@ -1,6 +1,14 @@
 package reporter
 
-import "github.com/kuzhukin/metrics-collector/internal/agent/config"
+import (
+	"context"
+	"time"
+
+	"github.com/kuzhukin/metrics-collector/internal/agent/config"
+	"github.com/kuzhukin/metrics-collector/internal/agent/reporter/retry"
+	"github.com/kuzhukin/metrics-collector/internal/instance"
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+)
 
 const batchUpdateEndpoint = "/updates/"
 
@@ -8,13 +16,58 @@ const batchUpdateEndpoint = "/updates/"
 //
 //go:generate mockery --name=Reporter --filename=reporter.go --outpkg=mockreporter --output=mockreporter
 type Reporter interface {
-	Report(gaugeMetrics map[string]float64, counterMetrics map[string]int64)
+	// returns PartialReportError if only some chunks of metrics are applied by the server
+	Report(ctx context.Context, gaugeMetrics map[string]float64, counterMetrics map[string]int64) error
+	// releases connections to server
+	Close() error
+}
+
+// PartialReportError - some chunks of the report are applied by the server and others are failed,
+// counters of applied chunks shouldn't be reported again, because the server adds them to stored ones
+type PartialReportError struct {
+	err    error
+	failed map[metricKey]struct{}
+}
+
+type metricKey struct {
+	name string
+	kind metric.Kind
+}
+
+func (e *PartialReportError) Error() string {
+	return e.err.Error()
+}
+
+func (e *PartialReportError) Unwrap() error {
+	return e.err
+}
+
+// Applied - returns true if the reported metric isn't in the failed chunks
+func (e *PartialReportError) Applied(name string, kind metric.Kind) bool {
+	_, failed := e.failed[metricKey{name: name, kind: kind}]
+	return !failed
+}
+
+// returns PartialReportError if some chunks are applied, otherwise the error is returned as is
+func partialReportError(err error, appliedChunks int, failed map[metricKey]struct{}) error {
+	if err == nil || appliedChunks == 0 {
+		return err
+	}
+
+	return &PartialReportError{err: err, failed: failed}
 }
 
-func New(config config.Config) (Reporter, error) {
+func New(config config.Config, identity instance.Identity) (Reporter, error) {
+	retryPolicy := retry.New(
+		config.RetryMaxAttempts,
+		time.Millisecond*time.Duration(config.RetryBaseDelay),
+		time.Millisecond*time.Duration(config.RetryMaxDelay),
+		retry.NewBreaker(config.BreakerThreshold, time.Second*time.Duration(config.BreakerOpenTimeout)),
+	)
+
 	if config.UseGRPC {
-		return newGRPCReporter(config)
+		return newGRPCReporter(config, identity, retryPolicy)
 	}
 
-	return newHTTPReporter(config)
+	return newHTTPReporter(config, identity, retryPolicy)
 }
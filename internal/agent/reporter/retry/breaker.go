@@ -0,0 +1,115 @@
+package retry
+
+import (
+	"sync"
+	"time"
+)
+
+type breakerState int
+
+const (
+	// requests are allowed, failures are counted
+	closed breakerState = iota
+	// requests are rejected until the open timeout expires
+	open
+	// the single probe request is allowed, its result closes or opens the breaker
+	halfOpen
+)
+
+// Breaker - circuit breaker which stops requests to the server after a series of failures
+type Breaker struct {
+	mu sync.Mutex
+
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+
+	// number of consecutive failures which opens the breaker
+	threshold int
+	// duration of the open state before the half-open probe
+	openTimeout time.Duration
+
+	now func() time.Time
+}
+
+// NewBreaker - creates circuit breaker, threshold <= 0 disables the breaker
+func NewBreaker(threshold int, openTimeout time.Duration) *Breaker {
+	return &Breaker{
+		threshold:   threshold,
+		openTimeout: openTimeout,
+		now:         time.Now,
+	}
+}
+
+// Allow - returns true if the request can be sent to the server
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.threshold <= 0 {
+		return true
+	}
+
+	switch b.state {
+	case open:
+		if b.now().Sub(b.openedAt) < b.openTimeout {
+			return false
+		}
+
+		b.state = halfOpen
+		b.probing = true
+
+		return true
+	case halfOpen:
+		if b.probing {
+			return false
+		}
+
+		b.probing = true
+
+		return true
+	default:
+		return true
+	}
+}
+
+// Success - registers successful request, the breaker is closed
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state = closed
+	b.failures = 0
+	b.probing = false
+}
+
+// Failure - registers failed request, the breaker is opened if threshold is reached or the probe is failed
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.failures++
+
+	if b.state == halfOpen || b.failures >= b.threshold {
+		b.state = open
+		b.openedAt = b.now()
+		b.probing = false
+	}
+}
+
+// Cancel - registers request which is interrupted by the client, the result of the server is unknown,
+// so the interrupted probe returns the breaker to the open state and the next request probes the server again
+func (b *Breaker) Cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == halfOpen && b.probing {
+		b.state = open
+		b.probing = false
+	}
+}
@@ -0,0 +1,153 @@
+// package retry - retry policy of reporters: exponential backoff with full jitter and circuit breaker
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// Policy - retries retryable errors with exponential backoff and full jitter
+type Policy struct {
+	// max number of attempts including the first one
+	maxAttempts int
+	// delay before the first retry
+	baseDelay time.Duration
+	// max delay between attempts
+	maxDelay time.Duration
+
+	breaker *Breaker
+
+	random func() float64
+	sleep  func(ctx context.Context, d time.Duration) error
+}
+
+// New - creates retry policy, breaker can be shared between policies
+func New(maxAttempts int, baseDelay time.Duration, maxDelay time.Duration, breaker *Breaker) *Policy {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	return &Policy{
+		maxAttempts: maxAttempts,
+		baseDelay:   baseDelay,
+		maxDelay:    maxDelay,
+		breaker:     breaker,
+		random:      rand.Float64,
+		sleep:       sleep,
+	}
+}
+
+// Do - calls fn until success, non-retryable error, attempts limit or context cancellation
+func (p *Policy) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	var joinedErr error
+
+	for attempt := 0; attempt < p.maxAttempts; attempt++ {
+		if !p.breaker.Allow() {
+			return errors.Join(joinedErr, ErrCircuitOpen)
+		}
+
+		err := fn(ctx)
+		if err == nil {
+			p.breaker.Success()
+			return nil
+		}
+
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			// the server is alive and has rejected the request
+			p.breaker.Success()
+			return errors.Join(joinedErr, permanent.err)
+		}
+
+		joinedErr = errors.Join(joinedErr, err)
+
+		if ctx.Err() != nil {
+			p.breaker.Cancel()
+			return fmt.Errorf("retry was interrupted, errs=%w", joinedErr)
+		}
+
+		p.breaker.Failure()
+
+		if attempt+1 == p.maxAttempts {
+			break
+		}
+
+		if err := p.sleep(ctx, p.delay(attempt, err)); err != nil {
+			return fmt.Errorf("retry was interrupted, errs=%w", errors.Join(joinedErr, err))
+		}
+	}
+
+	return fmt.Errorf("attempts limit exceeded, errs=%w", joinedErr)
+}
+
+// full jitter: random delay in [0, min(maxDelay, baseDelay * 2^attempt)), server's Retry-After has priority,
+// but it's limited by maxDelay, so a misconfigured server can't stall the agent
+func (p *Policy) delay(attempt int, err error) time.Duration {
+	var retryAfter *retryAfterError
+	if errors.As(err, &retryAfter) && retryAfter.after > 0 {
+		if retryAfter.after > p.maxDelay {
+			return p.maxDelay
+		}
+
+		return retryAfter.after
+	}
+
+	backoff := p.baseDelay << attempt
+	if backoff > p.maxDelay || backoff <= 0 {
+		backoff = p.maxDelay
+	}
+
+	return time.Duration(p.random() * float64(backoff))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent - marks error as non-retryable
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+type retryAfterError struct {
+	err   error
+	after time.Duration
+}
+
+func (e *retryAfterError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", e.err, e.after)
+}
+
+func (e *retryAfterError) Unwrap() error {
+	return e.err
+}
+
+// RetryAfter - marks error as retryable after the delay which is requested by the server
+func RetryAfter(err error, after time.Duration) error {
+	return &retryAfterError{err: err, after: after}
+}
@@ -0,0 +1,172 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var errTemporary = errors.New("temporary error")
+
+func newTestPolicy(maxAttempts int, breaker *Breaker) (*Policy, *[]time.Duration) {
+	delays := make([]time.Duration, 0)
+
+	policy := New(maxAttempts, time.Second, time.Second*5, breaker)
+	policy.random = func() float64 { return 1 }
+	policy.sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return ctx.Err()
+	}
+
+	return policy, &delays
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	policy, delays := newTestPolicy(5, NewBreaker(0, 0))
+
+	calls := 0
+	err := policy.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		return errTemporary
+	})
+
+	require.ErrorIs(t, err, errTemporary)
+	require.Equal(t, 5, calls)
+	require.Equal(t, []time.Duration{time.Second, time.Second * 2, time.Second * 4, time.Second * 5}, *delays)
+}
+
+func TestFullJitter(t *testing.T) {
+	policy, delays := newTestPolicy(2, NewBreaker(0, 0))
+	policy.random = func() float64 { return 0.25 }
+
+	_ = policy.Do(context.Background(), func(ctx context.Context) error { return errTemporary })
+
+	require.Equal(t, []time.Duration{time.Millisecond * 250}, *delays)
+}
+
+func TestRetryUntilSuccess(t *testing.T) {
+	policy, delays := newTestPolicy(5, NewBreaker(0, 0))
+
+	calls := 0
+	err := policy.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return errTemporary
+		}
+
+		return nil
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+	require.Len(t, *delays, 2)
+}
+
+func TestPermanentErrorIsNotRetried(t *testing.T) {
+	policy, delays := newTestPolicy(5, NewBreaker(0, 0))
+	permanentErr := errors.New("bad request")
+
+	calls := 0
+	err := policy.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		return Permanent(permanentErr)
+	})
+
+	require.ErrorIs(t, err, permanentErr)
+	require.Equal(t, 1, calls)
+	require.Empty(t, *delays)
+}
+
+func TestRetryAfter(t *testing.T) {
+	policy, delays := newTestPolicy(3, NewBreaker(0, 0))
+
+	afters := []time.Duration{time.Second * 3, time.Second * 42, time.Second}
+	_ = policy.Do(context.Background(), func(ctx context.Context) error {
+		after := afters[0]
+		afters = afters[1:]
+
+		return RetryAfter(errTemporary, after)
+	})
+
+	// the delay requested by the server is limited by max delay of the policy
+	require.Equal(t, []time.Duration{time.Second * 3, time.Second * 5}, *delays)
+}
+
+func TestContextCancellation(t *testing.T) {
+	policy := New(5, time.Hour, time.Hour, NewBreaker(0, 0))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	err := policy.Do(ctx, func(ctx context.Context) error { return errTemporary })
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	breaker := NewBreaker(2, time.Minute)
+	breaker.now = func() time.Time { return now }
+
+	policy, _ := newTestPolicy(5, breaker)
+
+	calls := 0
+	failing := func(ctx context.Context) error {
+		calls++
+		return errTemporary
+	}
+
+	// the breaker is opened after 2 failures
+	err := policy.Do(context.Background(), failing)
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.Equal(t, 2, calls)
+
+	// requests aren't sent while the breaker is open
+	err = policy.Do(context.Background(), failing)
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.Equal(t, 2, calls)
+
+	// the failed half-open probe opens the breaker again
+	now = now.Add(time.Minute)
+	err = policy.Do(context.Background(), failing)
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.Equal(t, 3, calls)
+
+	// the successful probe closes the breaker
+	now = now.Add(time.Minute)
+	err = policy.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 4, calls)
+	require.True(t, breaker.Allow())
+}
+
+func TestCancelledProbe(t *testing.T) {
+	now := time.Now()
+	breaker := NewBreaker(1, time.Minute)
+	breaker.now = func() time.Time { return now }
+
+	policy, _ := newTestPolicy(1, breaker)
+
+	err := policy.Do(context.Background(), func(ctx context.Context) error { return errTemporary })
+	require.ErrorIs(t, err, errTemporary)
+
+	// the half-open probe is interrupted by the client
+	now = now.Add(time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err = policy.Do(ctx, func(ctx context.Context) error {
+		cancel()
+		return ctx.Err()
+	})
+	require.ErrorIs(t, err, context.Canceled)
+
+	// the next request probes the server again
+	err = policy.Do(context.Background(), func(ctx context.Context) error { return nil })
+	require.NoError(t, err)
+	require.True(t, breaker.Allow())
+}
@@ -0,0 +1,244 @@
+// package runtimemetrics - collects go-runtime metrics with runtime/metrics package without stopping the world
+package runtimemetrics
+
+import (
+	"math"
+	"runtime/debug"
+	"runtime/metrics"
+	"strings"
+	"unicode"
+)
+
+const namePrefix = "go"
+
+// quantiles which are exported for histograms as <name>_p<quantile> gauges
+var quantiles = []struct {
+	suffix string
+	value  float64
+}{
+	{suffix: "_p50", value: 0.5},
+	{suffix: "_p90", value: 0.9},
+	{suffix: "_p99", value: 0.99},
+}
+
+// Collector - reads all supported runtime metrics
+type Collector struct {
+	samples []metrics.Sample
+	// metric ids by runtime metric names
+	ids map[string]string
+	// runtime metrics which are cumulative and are reported as counters
+	cumulative map[string]bool
+	// previous values of cumulative metrics for calculation of counter increments
+	prev map[string]int64
+	// export of legacy runtime.MemStats names
+	legacy bool
+}
+
+// New - creates collector of all supported runtime metrics,
+// legacy enables export of runtime.MemStats names (Alloc, HeapAlloc, ...)
+func New(legacy bool) *Collector {
+	descriptions := metrics.All()
+
+	c := &Collector{
+		samples:    make([]metrics.Sample, 0, len(descriptions)),
+		ids:        make(map[string]string, len(descriptions)),
+		cumulative: make(map[string]bool),
+		prev:       make(map[string]int64),
+		legacy:     legacy,
+	}
+
+	for _, d := range descriptions {
+		c.samples = append(c.samples, metrics.Sample{Name: d.Name})
+		c.ids[d.Name] = MakeID(d.Name)
+
+		if d.Cumulative && d.Kind == metrics.KindUint64 {
+			c.cumulative[d.Name] = true
+		}
+	}
+
+	return c
+}
+
+// MakeID - converts runtime metric name to metric id: /gc/heap/allocs:bytes -> go_gc_heap_allocs_bytes
+func MakeID(name string) string {
+	var b strings.Builder
+
+	b.WriteString(namePrefix)
+
+	underscore := false
+	for _, r := range name {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			if underscore {
+				b.WriteByte('_')
+				underscore = false
+			}
+
+			b.WriteRune(r)
+		} else {
+			underscore = true
+		}
+	}
+
+	return b.String()
+}
+
+// Collect - reads runtime metrics, cumulative integer metrics are returned as increments of counters
+// since the previous call
+func (c *Collector) Collect() (map[string]float64, map[string]int64, error) {
+	metrics.Read(c.samples)
+
+	gauges := make(map[string]float64, len(c.samples))
+	counters := make(map[string]int64, len(c.cumulative))
+	values := make(map[string]float64, len(c.samples))
+
+	for _, sample := range c.samples {
+		id := c.ids[sample.Name]
+
+		switch sample.Value.Kind() {
+		case metrics.KindUint64:
+			value := sample.Value.Uint64()
+			values[sample.Name] = float64(value)
+
+			if c.cumulative[sample.Name] {
+				counters[id] = c.increment(sample.Name, int64(value))
+			} else {
+				gauges[id] = float64(value)
+			}
+		case metrics.KindFloat64:
+			value := sample.Value.Float64()
+			values[sample.Name] = value
+			gauges[id] = value
+		case metrics.KindFloat64Histogram:
+			histogram := sample.Value.Float64Histogram()
+
+			for _, q := range quantiles {
+				gauges[id+q.suffix] = quantile(histogram, q.value)
+			}
+		}
+	}
+
+	if c.legacy {
+		addLegacyMetrics(values, gauges)
+	}
+
+	return gauges, counters, nil
+}
+
+func (c *Collector) increment(name string, value int64) int64 {
+	prev, ok := c.prev[name]
+	c.prev[name] = value
+
+	// the first value is a baseline like in cgroup collector, counters are reported since start of the collector
+	if !ok || value < prev {
+		return 0
+	}
+
+	return value - prev
+}
+
+// returns upper bound of the bucket containing the quantile, lower bound is used for the infinite bucket
+func quantile(h *metrics.Float64Histogram, q float64) float64 {
+	total := uint64(0)
+	for _, count := range h.Counts {
+		total += count
+	}
+
+	if total == 0 {
+		return 0
+	}
+
+	target := uint64(math.Ceil(q * float64(total)))
+	acc := uint64(0)
+
+	for i, count := range h.Counts {
+		acc += count
+		if acc < target || count == 0 {
+			continue
+		}
+
+		if upper := h.Buckets[i+1]; !math.IsInf(upper, 0) {
+			return upper
+		}
+
+		if lower := h.Buckets[i]; !math.IsInf(lower, 0) {
+			return lower
+		}
+
+		return 0
+	}
+
+	return 0
+}
+
+// legacy runtime.MemStats fields derived from runtime metrics as described in runtime/metrics documentation
+var legacyMetrics = map[string]func(v map[string]float64) float64{
+	"Alloc":       func(v map[string]float64) float64 { return v["/memory/classes/heap/objects:bytes"] },
+	"BuckHashSys": func(v map[string]float64) float64 { return v["/memory/classes/profiling/buckets:bytes"] },
+	"Frees": func(v map[string]float64) float64 {
+		return v["/gc/heap/frees:objects"] + v["/gc/heap/tiny/allocs:objects"]
+	},
+	"GCSys":     func(v map[string]float64) float64 { return v["/memory/classes/metadata/other:bytes"] },
+	"HeapAlloc": func(v map[string]float64) float64 { return v["/memory/classes/heap/objects:bytes"] },
+	"HeapIdle": func(v map[string]float64) float64 {
+		return v["/memory/classes/heap/released:bytes"] + v["/memory/classes/heap/free:bytes"]
+	},
+	"HeapInuse": func(v map[string]float64) float64 {
+		return v["/memory/classes/heap/objects:bytes"] + v["/memory/classes/heap/unused:bytes"]
+	},
+	"HeapObjects":  func(v map[string]float64) float64 { return v["/gc/heap/objects:objects"] },
+	"HeapReleased": func(v map[string]float64) float64 { return v["/memory/classes/heap/released:bytes"] },
+	"Lookups":      func(v map[string]float64) float64 { return 0 },
+	"MCacheInuse":  func(v map[string]float64) float64 { return v["/memory/classes/metadata/mcache/inuse:bytes"] },
+	"MSpanInuse":   func(v map[string]float64) float64 { return v["/memory/classes/metadata/mspan/inuse:bytes"] },
+	"Mallocs": func(v map[string]float64) float64 {
+		return v["/gc/heap/allocs:objects"] + v["/gc/heap/tiny/allocs:objects"]
+	},
+	"NextGC":        func(v map[string]float64) float64 { return v["/gc/heap/goal:bytes"] },
+	"NumForcedGC":   func(v map[string]float64) float64 { return v["/gc/cycles/forced:gc-cycles"] },
+	"NumGC":         func(v map[string]float64) float64 { return v["/gc/cycles/total:gc-cycles"] },
+	"OtherSys":      func(v map[string]float64) float64 { return v["/memory/classes/other:bytes"] },
+	"StackInuse":    func(v map[string]float64) float64 { return v["/memory/classes/heap/stacks:bytes"] },
+	"Sys":           func(v map[string]float64) float64 { return v["/memory/classes/total:bytes"] },
+	"TotalAlloc":    func(v map[string]float64) float64 { return v["/gc/heap/allocs:bytes"] },
+	"GCCPUFraction": gcCPUFraction,
+	"HeapSys": func(v map[string]float64) float64 {
+		return v["/memory/classes/heap/objects:bytes"] + v["/memory/classes/heap/unused:bytes"] +
+			v["/memory/classes/heap/free:bytes"] + v["/memory/classes/heap/released:bytes"]
+	},
+	"MCacheSys": func(v map[string]float64) float64 {
+		return v["/memory/classes/metadata/mcache/inuse:bytes"] + v["/memory/classes/metadata/mcache/free:bytes"]
+	},
+	"MSpanSys": func(v map[string]float64) float64 {
+		return v["/memory/classes/metadata/mspan/inuse:bytes"] + v["/memory/classes/metadata/mspan/free:bytes"]
+	},
+	"StackSys": func(v map[string]float64) float64 {
+		return v["/memory/classes/heap/stacks:bytes"] + v["/memory/classes/os-stacks:bytes"]
+	},
+}
+
+func gcCPUFraction(v map[string]float64) float64 {
+	total := v["/cpu/classes/total:cpu-seconds"]
+	if total == 0 {
+		return 0
+	}
+
+	return v["/cpu/classes/gc/total:cpu-seconds"] / total
+}
+
+func addLegacyMetrics(values map[string]float64, gauges map[string]float64) {
+	for name, fn := range legacyMetrics {
+		gauges[name] = fn(values)
+	}
+
+	// last gc time and total pause aren't available in runtime metrics,
+	// debug.ReadGCStats doesn't stop the world unlike runtime.ReadMemStats
+	stats := &debug.GCStats{}
+	debug.ReadGCStats(stats)
+
+	gauges["LastGC"] = 0
+	if stats.NumGC > 0 {
+		gauges["LastGC"] = float64(stats.LastGC.UnixNano())
+	}
+
+	gauges["PauseTotalNs"] = float64(stats.PauseTotal.Nanoseconds())
+}
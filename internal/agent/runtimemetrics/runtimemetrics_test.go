@@ -0,0 +1,58 @@
+package runtimemetrics
+
+import (
+	"math"
+	"runtime"
+	"runtime/metrics"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMakeID(t *testing.T) {
+	require.Equal(t, "go_gc_heap_allocs_bytes", MakeID("/gc/heap/allocs:bytes"))
+	require.Equal(t, "go_cpu_classes_gc_mark_assist_cpu_seconds", MakeID("/cpu/classes/gc/mark/assist:cpu-seconds"))
+	require.Equal(t, "go_sched_goroutines_goroutines", MakeID("/sched/goroutines:goroutines"))
+}
+
+func TestQuantile(t *testing.T) {
+	h := &metrics.Float64Histogram{
+		Counts:  []uint64{0, 50, 40, 10},
+		Buckets: []float64{math.Inf(-1), 1, 2, 3, math.Inf(1)},
+	}
+
+	require.Equal(t, float64(2), quantile(h, 0.5))
+	require.Equal(t, float64(3), quantile(h, 0.9))
+	require.Equal(t, float64(3), quantile(h, 0.99))
+	require.Equal(t, float64(0), quantile(&metrics.Float64Histogram{Counts: []uint64{0}, Buckets: []float64{0, 1}}, 0.5))
+}
+
+func TestCollect(t *testing.T) {
+	collector := New(true)
+
+	// the first collection is a baseline for counters
+	gauges, counters, err := collector.Collect()
+	require.NoError(t, err)
+	require.Equal(t, int64(0), counters["go_gc_cycles_total_gc_cycles"])
+
+	runtime.GC()
+
+	gauges, counters, err = collector.Collect()
+	require.NoError(t, err)
+
+	require.Contains(t, gauges, "go_sched_goroutines_goroutines")
+	require.Contains(t, gauges, "go_memory_classes_heap_objects_bytes")
+	require.Contains(t, gauges, "go_sched_latencies_seconds_p99")
+	require.Contains(t, counters, "go_gc_cycles_total_gc_cycles")
+
+	for _, name := range []string{"Alloc", "HeapAlloc", "Sys", "NumGC", "LastGC", "PauseTotalNs", "GCCPUFraction"} {
+		require.Contains(t, gauges, name)
+	}
+
+	require.Greater(t, gauges["NumGC"], float64(0))
+	require.GreaterOrEqual(t, counters["go_gc_cycles_total_gc_cycles"], int64(1))
+
+	gauges, _, err = New(false).Collect()
+	require.NoError(t, err)
+	require.NotContains(t, gauges, "Alloc")
+}
@@ -0,0 +1,46 @@
+// package idempotency - keys of batches which allow the server to apply retried batches only once
+package idempotency
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// HTTP header with the key of the batch
+const Header = "Idempotency-Key"
+
+// gRPC metadata key with the key of the batch
+const MetadataKey = "idempotency-key"
+
+const maxKeyLength = 128
+
+var ErrBadKey = errors.New("bad idempotency key")
+
+// NewKey - returns random key for the batch, retries of the batch should be sent with the same key
+func NewKey() string {
+	buf := make([]byte, 16)
+
+	// reading of random bytes doesn't fail on supported platforms
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Errorf("read random bytes, err=%w", err))
+	}
+
+	return hex.EncodeToString(buf)
+}
+
+// Validate - checks the key, empty key means that the batch isn't deduplicated
+func Validate(key string) error {
+	if len(key) > maxKeyLength {
+		return fmt.Errorf("key is longer than %d, err=%w", maxKeyLength, ErrBadKey)
+	}
+
+	for _, r := range key {
+		if r < '!' || r > '~' {
+			return fmt.Errorf("key=%q contains not printable character, err=%w", key, ErrBadKey)
+		}
+	}
+
+	return nil
+}
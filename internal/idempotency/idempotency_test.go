@@ -0,0 +1,20 @@
+package idempotency
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKey(t *testing.T) {
+	key := NewKey()
+	require.Len(t, key, 32)
+	require.NotEqual(t, key, NewKey())
+	require.NoError(t, Validate(key))
+
+	require.NoError(t, Validate(""))
+	require.ErrorIs(t, Validate("with space"), ErrBadKey)
+	require.ErrorIs(t, Validate("ключ"), ErrBadKey)
+	require.ErrorIs(t, Validate(strings.Repeat("a", 129)), ErrBadKey)
+}
@@ -0,0 +1,155 @@
+// package instance - identity of the agent's instance and namespacing of metrics by instance
+package instance
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// HTTP headers with instance identity
+const (
+	IDHeader        = "X-Instance-ID"
+	HostnameHeader  = "X-Instance-Hostname"
+	MachineIDHeader = "X-Machine-ID"
+)
+
+// QueryParam - query parameter of HTTP requests with instance, it overrides the identity header
+const QueryParam = "instance"
+
+// gRPC metadata keys with instance identity
+const (
+	IDMetadataKey        = "x-instance-id"
+	HostnameMetadataKey  = "x-instance-hostname"
+	MachineIDMetadataKey = "x-machine-id"
+)
+
+const separator = "/"
+
+var ErrBadInstanceID = errors.New("bad instance id")
+var ErrBadMetricID = errors.New("bad metric id")
+
+var machineIDPaths = []string{"/etc/machine-id", "/var/lib/dbus/machine-id"}
+
+// Identity - identity of the agent's instance
+type Identity struct {
+	// hostname of the agent's host
+	Hostname string
+	// configured instance id, metrics aren't namespaced if it's empty
+	InstanceID string
+	// id of the machine from /etc/machine-id
+	MachineID string
+}
+
+// Detect - determines identity of the current instance
+func Detect(instanceID string, useMachineID bool) (Identity, error) {
+	if err := Validate(instanceID); err != nil {
+		return Identity{}, err
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return Identity{}, fmt.Errorf("get hostname, err=%w", err)
+	}
+
+	identity := Identity{Hostname: hostname, InstanceID: instanceID}
+
+	if useMachineID {
+		machineID, err := readMachineID()
+		if err != nil {
+			return Identity{}, fmt.Errorf("read machine id, err=%w", err)
+		}
+
+		identity.MachineID = machineID
+	}
+
+	return identity, nil
+}
+
+// ID - returns id of the instance, which is used for namespacing of metrics.
+// It isn't defaulted to the hostname: consumers read metrics of agents without id by plain names (/value/gauge/Alloc),
+// so namespacing is enabled explicitly by the instance id, agents sharing the server should set it
+func (i Identity) ID() string {
+	return i.InstanceID
+}
+
+// Validate - checks that the instance id can be used as a namespace
+func Validate(id string) error {
+	if strings.Contains(id, separator) {
+		return fmt.Errorf("instance=%s contains %q, err=%w", id, separator, ErrBadInstanceID)
+	}
+
+	return nil
+}
+
+// ValidateMetricID - checks that the metric id of the request isn't namespaced,
+// otherwise the client could write into the namespace of another instance
+func ValidateMetricID(id string) error {
+	if strings.Contains(id, separator) {
+		return fmt.Errorf("metric=%s contains %q, err=%w", id, separator, ErrBadMetricID)
+	}
+
+	return nil
+}
+
+// Namespace - returns metric id in the instance's namespace
+func Namespace(instance string, id string) string {
+	if instance == "" {
+		return id
+	}
+
+	return instance + separator + id
+}
+
+// FromRequest - returns instance of the HTTP request from the query parameter or from the identity header
+func FromRequest(r *http.Request) string {
+	if inst := r.URL.Query().Get(QueryParam); inst != "" {
+		return inst
+	}
+
+	return r.Header.Get(IDHeader)
+}
+
+// SigningKey - returns key of the instance's request signatures, so the signed request can't be replayed
+// into the namespace of another instance. The key isn't changed for requests without instance.
+func SigningKey(key []byte, instance string) []byte {
+	if instance == "" {
+		return key
+	}
+
+	hasher := hmac.New(sha256.New, key)
+	hasher.Write([]byte(instance))
+
+	return hasher.Sum(nil)
+}
+
+// Strip - returns metric id without the instance's namespace and true if the id belongs to the instance
+func Strip(instance string, id string) (string, bool) {
+	if instance == "" {
+		return id, true
+	}
+
+	return strings.CutPrefix(id, instance+separator)
+}
+
+func readMachineID() (string, error) {
+	var joinedErr error
+
+	for _, path := range machineIDPaths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			joinedErr = errors.Join(joinedErr, err)
+			continue
+		}
+
+		if id := strings.TrimSpace(string(data)); id != "" {
+			return id, nil
+		}
+	}
+
+	return "", joinedErr
+}
@@ -0,0 +1,46 @@
+package instance
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamespace(t *testing.T) {
+	require.Equal(t, "Alloc", Namespace("", "Alloc"))
+	require.Equal(t, "host-1/Alloc", Namespace("host-1", "Alloc"))
+
+	id, ok := Strip("host-1", "host-1/Alloc")
+	require.True(t, ok)
+	require.Equal(t, "Alloc", id)
+
+	_, ok = Strip("host-2", "host-1/Alloc")
+	require.False(t, ok)
+
+	id, ok = Strip("", "host-1/Alloc")
+	require.True(t, ok)
+	require.Equal(t, "host-1/Alloc", id)
+}
+
+func TestIdentity(t *testing.T) {
+	// metrics are namespaced only by the configured instance id
+	require.Empty(t, Identity{Hostname: "host-1"}.ID())
+	require.Equal(t, "agent-1", Identity{Hostname: "host-1", InstanceID: "agent-1"}.ID())
+
+	_, err := Detect("bad/instance", false)
+	require.ErrorIs(t, err, ErrBadInstanceID)
+
+	identity, err := Detect("agent-1", false)
+	require.NoError(t, err)
+	require.NotEmpty(t, identity.Hostname)
+	require.Equal(t, "agent-1", identity.ID())
+}
+
+func TestSigningKey(t *testing.T) {
+	key := []byte("secret")
+
+	require.Equal(t, key, SigningKey(key, ""))
+	require.NotEqual(t, key, SigningKey(key, "agent-1"))
+	require.Equal(t, SigningKey(key, "agent-1"), SigningKey(key, "agent-1"))
+	require.NotEqual(t, SigningKey(key, "agent-1"), SigningKey(key, "agent-2"))
+}
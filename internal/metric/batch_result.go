@@ -0,0 +1,25 @@
+package metric
+
+// RejectReason - code of the reason why the metric of the batch isn't stored
+type RejectReason string
+
+const (
+	RejectUnknownKind  RejectReason = "unknown_kind"
+	RejectMissingValue RejectReason = "missing_value"
+	RejectBadName      RejectReason = "bad_name"
+)
+
+// Rejection - metric of the batch which isn't stored
+type Rejection struct {
+	// position of the metric in the batch
+	Index   int          `json:"index"`
+	ID      string       `json:"id"`
+	Reason  RejectReason `json:"reason"`
+	Message string       `json:"message"`
+}
+
+// BatchResult - model of batch response, it lists metrics which are rejected by the server
+type BatchResult struct {
+	Accepted int         `json:"accepted"`
+	Rejected []Rejection `json:"rejected"`
+}
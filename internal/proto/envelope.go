@@ -0,0 +1,87 @@
+package metric_proto
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	// hex encoded HMAC-SHA256 of the serialized request, analog of HashSHA256 header
+	SignatureMetadataKey = "hashsha256"
+	// agent's address for checking of the trusted subnet, analog of X-Real-IP header
+	RealIPMetadataKey = "x-real-ip"
+
+	encryptedPayloadField = "encrypted_payload"
+)
+
+var ErrNoEnvelope = errors.New("message doesn't support encryption")
+
+// Marshal - serializes the message deterministically, the result is used for signing
+func Marshal(m proto.Message) ([]byte, error) {
+	return proto.MarshalOptions{Deterministic: true}.Marshal(m)
+}
+
+// Sealable - checks that the message can be encrypted, requests without metrics aren't encrypted
+func Sealable(m proto.Message) bool {
+	_, err := envelopeField(m)
+
+	return err == nil
+}
+
+// Seal - returns new message of the same type which contains only encrypted serialized message
+func Seal(m proto.Message, data []byte, encrypt func([]byte) ([]byte, error)) (proto.Message, error) {
+	field, err := envelopeField(m)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := encrypt(data)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt err=%w", err)
+	}
+
+	sealed := m.ProtoReflect().New()
+	sealed.Set(field, protoreflect.ValueOfBytes(encrypted))
+
+	return sealed.Interface(), nil
+}
+
+// Open - replaces content of the sealed message by decrypted one, returns serialized decrypted message
+func Open(m proto.Message, decrypt func([]byte) ([]byte, error)) ([]byte, error) {
+	field, err := envelopeField(m)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := m.ProtoReflect().Get(field).Bytes()
+	if len(encrypted) == 0 {
+		return nil, fmt.Errorf("empty %s, err=%w", encryptedPayloadField, ErrNoEnvelope)
+	}
+
+	data, err := decrypt(encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt err=%w", err)
+	}
+
+	proto.Reset(m)
+
+	if err := proto.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("unmarshal decrypted message err=%w", err)
+	}
+
+	return data, nil
+}
+
+func envelopeField(m proto.Message) (protoreflect.FieldDescriptor, error) {
+	descriptor := m.ProtoReflect().Descriptor()
+
+	field := descriptor.Fields().ByName(encryptedPayloadField)
+	if field == nil || field.Kind() != protoreflect.BytesKind {
+		return nil, fmt.Errorf("message=%s, err=%w", descriptor.FullName(), ErrNoEnvelope)
+	}
+
+	return field, nil
+}
@@ -0,0 +1,229 @@
+// package backup - versioned archive of all metrics for moving data between storage backends
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+	"github.com/kuzhukin/metrics-collector/internal/server/exchange"
+)
+
+// Version - version of the archive format which is written, archives of newer versions aren't read
+const Version = 1
+
+// the archive is gzipped tar with the manifest followed by metrics in ndjson format
+const (
+	manifestName = "manifest.json"
+	metricsName  = "metrics.ndjson"
+
+	maxManifestSize = 64 * 1024
+)
+
+var ErrBadArchive = errors.New("bad backup archive")
+var ErrUnsupportedVersion = errors.New("unsupported backup version")
+
+// Manifest - metadata of the archive
+type Manifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	// storage backend of the server which made the backup
+	Backend  string `json:"backend"`
+	Gauges   int    `json:"gauges"`
+	Counters int    `json:"counters"`
+	// hex encoded sha256 of the metrics file
+	SHA256 string `json:"sha256"`
+}
+
+// Write - writes archive of the metrics, version, counts and checksum of the manifest are filled by it
+func Write(w io.Writer, manifest Manifest, metrics []*metric.Metric) error {
+	// the manifest precedes metrics, so the checksum is calculated before writing of the archive
+	data := &bytes.Buffer{}
+
+	encoder, err := exchange.NewEncoder(exchange.NDJSON, data)
+	if err != nil {
+		return err
+	}
+
+	manifest.Version = Version
+	manifest.Gauges, manifest.Counters = 0, 0
+
+	for _, m := range metrics {
+		if err := encoder.Encode(m); err != nil {
+			return fmt.Errorf("encode metric=%s, err=%w", m.ID, err)
+		}
+
+		if m.Type == metric.Counter {
+			manifest.Counters++
+		} else {
+			manifest.Gauges++
+		}
+	}
+
+	if err := encoder.Flush(); err != nil {
+		return fmt.Errorf("flush metrics, err=%w", err)
+	}
+
+	checksum := sha256.Sum256(data.Bytes())
+	manifest.SHA256 = hex.EncodeToString(checksum[:])
+
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("marshal manifest, err=%w", err)
+	}
+
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+
+	if err := writeFile(tw, manifestName, manifest.CreatedAt, manifestData); err != nil {
+		return err
+	}
+
+	if err := writeFile(tw, metricsName, manifest.CreatedAt, data.Bytes()); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("close tar, err=%w", err)
+	}
+
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("close gzip, err=%w", err)
+	}
+
+	return nil
+}
+
+func writeFile(tw *tar.Writer, name string, modTime time.Time, data []byte) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	}
+
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("write header of file=%s, err=%w", name, err)
+	}
+
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("write file=%s, err=%w", name, err)
+	}
+
+	return nil
+}
+
+// Read - reads the archive and checks its version, checksum and counts of metrics
+func Read(r io.Reader) (*Manifest, []*metric.Metric, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, badArchive("open gzip", err)
+	}
+	defer zr.Close()
+
+	tr := tar.NewReader(zr)
+
+	if err := nextFile(tr, manifestName); err != nil {
+		return nil, nil, err
+	}
+
+	manifest := &Manifest{}
+	if err := json.NewDecoder(io.LimitReader(tr, maxManifestSize)).Decode(manifest); err != nil {
+		return nil, nil, badArchive("decode manifest", err)
+	}
+
+	if manifest.Version < 1 || manifest.Version > Version {
+		return nil, nil, fmt.Errorf("version=%d, err=%w", manifest.Version, ErrUnsupportedVersion)
+	}
+
+	if err := nextFile(tr, metricsName); err != nil {
+		return nil, nil, err
+	}
+
+	metrics, err := readMetrics(tr, manifest)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if header, err := tr.Next(); !errors.Is(err, io.EOF) {
+		if err == nil {
+			err = fmt.Errorf("unexpected file=%s", header.Name)
+		}
+
+		return nil, nil, badArchive("read end of archive", err)
+	}
+
+	// the rest of gzip stream is read for checking of its checksum, so truncated archive isn't accepted
+	if _, err := io.Copy(io.Discard, zr); err != nil {
+		return nil, nil, badArchive("read end of archive", err)
+	}
+
+	return manifest, metrics, nil
+}
+
+func readMetrics(r io.Reader, manifest *Manifest) ([]*metric.Metric, error) {
+	hash := sha256.New()
+
+	decoder, err := exchange.NewDecoder(exchange.NDJSON, io.TeeReader(r, hash))
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := make([]*metric.Metric, 0, manifest.Gauges+manifest.Counters)
+	gauges, counters := 0, 0
+
+	for {
+		m, err := decoder.Decode()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, badArchive(fmt.Sprintf("decode metric row=%d", decoder.Row()), err)
+		}
+
+		if m.Type == metric.Counter {
+			counters++
+		} else {
+			gauges++
+		}
+
+		metrics = append(metrics, m)
+	}
+
+	if checksum := hex.EncodeToString(hash.Sum(nil)); checksum != manifest.SHA256 {
+		return nil, badArchive("check metrics", fmt.Errorf("checksum=%s instead of %s", checksum, manifest.SHA256))
+	}
+
+	if gauges != manifest.Gauges || counters != manifest.Counters {
+		return nil, badArchive("check metrics", fmt.Errorf("gauges=%d, counters=%d instead of %d, %d", gauges, counters, manifest.Gauges, manifest.Counters))
+	}
+
+	return metrics, nil
+}
+
+// moves the reader to the next file of the archive, it should have the name
+func nextFile(tr *tar.Reader, name string) error {
+	header, err := tr.Next()
+	if err != nil {
+		return badArchive("read header of file="+name, err)
+	}
+
+	if header.Name != name {
+		return badArchive("read header of file="+name, fmt.Errorf("unexpected file=%s", header.Name))
+	}
+
+	return nil
+}
+
+func badArchive(action string, err error) error {
+	return fmt.Errorf("%s, err=%w", action, errors.Join(ErrBadArchive, err))
+}
@@ -0,0 +1,92 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"testing"
+	"time"
+
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+	"github.com/stretchr/testify/require"
+)
+
+func testMetrics() []*metric.Metric {
+	gauge := 1.5
+	delta := int64(7)
+
+	return []*metric.Metric{
+		{ID: "Alloc", Type: metric.Gauge, Value: &gauge},
+		{ID: "PollCount", Type: metric.Counter, Delta: &delta},
+	}
+}
+
+// writes archive with the files in the order of arguments
+func writeArchive(t *testing.T, files ...[2]string) []byte {
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(zw)
+
+	for _, file := range files {
+		require.NoError(t, writeFile(tw, file[0], time.Now(), []byte(file[1])))
+	}
+
+	require.NoError(t, tw.Close())
+	require.NoError(t, zw.Close())
+
+	return buf.Bytes()
+}
+
+func TestWriteRead(t *testing.T) {
+	buf := &bytes.Buffer{}
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	require.NoError(t, Write(buf, Manifest{CreatedAt: createdAt, Backend: "memory"}, testMetrics()))
+
+	manifest, metrics, err := Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, testMetrics(), metrics)
+	require.Equal(t, Version, manifest.Version)
+	require.Equal(t, createdAt, manifest.CreatedAt)
+	require.Equal(t, "memory", manifest.Backend)
+	require.Equal(t, 1, manifest.Gauges)
+	require.Equal(t, 1, manifest.Counters)
+	require.Len(t, manifest.SHA256, 64)
+}
+
+func TestReadBadArchive(t *testing.T) {
+	metrics := `{"id":"Alloc","type":"gauge","value":1.5}` + "\n"
+	checksum := "a1c4a3b1c3c2c5b0a0e5ea01b80c6b1b4f0a9d1c25a4b14a1a1a1a1a1a1a1a1a"
+
+	_, _, err := Read(bytes.NewReader([]byte("not gzip")))
+	require.ErrorIs(t, err, ErrBadArchive)
+
+	_, _, err = Read(bytes.NewReader(writeArchive(t, [2]string{metricsName, metrics})))
+	require.ErrorIs(t, err, ErrBadArchive)
+
+	_, _, err = Read(bytes.NewReader(writeArchive(t,
+		[2]string{manifestName, `{"version":2}`},
+		[2]string{metricsName, metrics},
+	)))
+	require.ErrorIs(t, err, ErrUnsupportedVersion)
+
+	_, _, err = Read(bytes.NewReader(writeArchive(t,
+		[2]string{manifestName, `{"version":1,"gauges":1,"sha256":"` + checksum + `"}`},
+		[2]string{metricsName, metrics},
+	)))
+	require.ErrorIs(t, err, ErrBadArchive)
+	require.Contains(t, err.Error(), "checksum")
+
+	_, _, err = Read(bytes.NewReader(writeArchive(t,
+		[2]string{manifestName, `{"version":1}`},
+		[2]string{metricsName, `{"id":"Alloc","type":"gauge"}`},
+	)))
+	require.ErrorIs(t, err, ErrBadArchive)
+
+	// truncated archive
+	buf := &bytes.Buffer{}
+	require.NoError(t, Write(buf, Manifest{}, testMetrics()))
+
+	_, _, err = Read(bytes.NewReader(buf.Bytes()[:buf.Len()/2]))
+	require.Error(t, err)
+}
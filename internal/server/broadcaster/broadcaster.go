@@ -0,0 +1,166 @@
+// package broadcaster - fans out accepted metric updates to subscribers
+package broadcaster
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+)
+
+const subscriberBufferDefault = 256
+
+var ErrSlowConsumer = errors.New("subscriber is evicted because its buffer is full")
+var ErrClosed = errors.New("broadcaster is closed")
+
+// Event - accepted update or deletion of the metric, counters of updates contain delta of the update
+type Event struct {
+	Metric *metric.Metric
+	Time   time.Time
+	// the metric is deleted, values of the metric aren't set
+	Deleted bool
+}
+
+// Broadcaster - delivers published events to all matching subscribers,
+// publishing is never blocked by subscribers, subscribers with full buffers are evicted
+type Broadcaster struct {
+	sync.RWMutex
+
+	subscribers map[*Subscription]struct{}
+	closed      bool
+}
+
+func New() *Broadcaster {
+	return &Broadcaster{
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscription - receives events which match its filter
+type Subscription struct {
+	broadcaster *Broadcaster
+	filter      func(m *metric.Metric) bool
+	events      chan Event
+	evicted     atomic.Bool
+
+	// closed after eviction or unsubscribing
+	done chan struct{}
+	err  error
+	once sync.Once
+}
+
+// Subscribe - creates subscription with the buffer of the size, default size is used if it isn't positive,
+// nil filter matches all metrics
+func (b *Broadcaster) Subscribe(filter func(m *metric.Metric) bool, buffer int) (*Subscription, error) {
+	if buffer <= 0 {
+		buffer = subscriberBufferDefault
+	}
+
+	s := &Subscription{
+		broadcaster: b,
+		filter:      filter,
+		events:      make(chan Event, buffer),
+		done:        make(chan struct{}),
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	if b.closed {
+		return nil, ErrClosed
+	}
+
+	b.subscribers[s] = struct{}{}
+
+	return s, nil
+}
+
+// Publish - sends events about updates of the metrics to subscribers
+func (b *Broadcaster) Publish(metrics ...*metric.Metric) {
+	b.publish(false, metrics)
+}
+
+// PublishDeleted - sends events about deletion of the metrics to subscribers
+func (b *Broadcaster) PublishDeleted(metrics ...*metric.Metric) {
+	b.publish(true, metrics)
+}
+
+func (b *Broadcaster) publish(deleted bool, metrics []*metric.Metric) {
+	now := time.Now()
+
+	b.RLock()
+	defer b.RUnlock()
+
+	for s := range b.subscribers {
+		for _, m := range metrics {
+			if s.evicted.Load() {
+				break
+			}
+
+			if s.filter != nil && !s.filter(m) {
+				continue
+			}
+
+			select {
+			case s.events <- Event{Metric: m, Time: now, Deleted: deleted}:
+			default:
+				// the subscriber doesn't get events after the gap, removing needs write lock, so it's done asynchronously
+				if s.evicted.CompareAndSwap(false, true) {
+					go s.close(ErrSlowConsumer)
+				}
+			}
+		}
+	}
+}
+
+// Close - closes all subscriptions, they are finished with ErrClosed
+func (b *Broadcaster) Close() {
+	b.Lock()
+	b.closed = true
+	subscribers := b.subscribers
+	b.subscribers = make(map[*Subscription]struct{})
+	b.Unlock()
+
+	for s := range subscribers {
+		s.finish(ErrClosed)
+	}
+}
+
+// Events - returns channel of events, it isn't closed, Done should be used for waiting of the finish
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Done - returns channel which is closed when the subscription is finished
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err - returns reason of the finish, it's nil if the subscription was closed by the subscriber
+func (s *Subscription) Err() error {
+	<-s.done
+
+	return s.err
+}
+
+// Close - unsubscribes from the broadcaster
+func (s *Subscription) Close() {
+	s.close(nil)
+}
+
+func (s *Subscription) close(err error) {
+	s.broadcaster.Lock()
+	delete(s.broadcaster.subscribers, s)
+	s.broadcaster.Unlock()
+
+	s.finish(err)
+}
+
+func (s *Subscription) finish(err error) {
+	s.once.Do(func() {
+		s.err = err
+		close(s.done)
+	})
+}
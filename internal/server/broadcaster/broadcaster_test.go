@@ -0,0 +1,88 @@
+package broadcaster
+
+import (
+	"testing"
+
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishFilter(t *testing.T) {
+	b := New()
+
+	gauges, err := b.Subscribe(func(m *metric.Metric) bool { return m.Type == metric.Gauge }, 10)
+	require.NoError(t, err)
+
+	all, err := b.Subscribe(nil, 10)
+	require.NoError(t, err)
+
+	b.Publish(newGauge("Alloc"), &metric.Metric{ID: "PollCount", Type: metric.Counter})
+
+	require.Len(t, gauges.Events(), 1)
+	require.Equal(t, "Alloc", (<-gauges.Events()).Metric.ID)
+	require.Len(t, all.Events(), 2)
+
+	all.Close()
+	require.NoError(t, all.Err())
+
+	b.Publish(newGauge("HeapAlloc"))
+	require.Len(t, all.Events(), 2)
+	require.Len(t, gauges.Events(), 1)
+}
+
+func TestSlowConsumerEviction(t *testing.T) {
+	b := New()
+
+	slow, err := b.Subscribe(nil, 2)
+	require.NoError(t, err)
+
+	fast, err := b.Subscribe(nil, 10)
+	require.NoError(t, err)
+
+	b.Publish(newGauge("1"), newGauge("2"), newGauge("3"), newGauge("4"))
+
+	require.ErrorIs(t, slow.Err(), ErrSlowConsumer)
+	require.Len(t, slow.Events(), 2)
+	require.Len(t, fast.Events(), 4)
+
+	select {
+	case <-fast.Done():
+		require.Fail(t, "fast subscriber is evicted")
+	default:
+	}
+}
+
+func TestClose(t *testing.T) {
+	b := New()
+
+	s, err := b.Subscribe(nil, 1)
+	require.NoError(t, err)
+
+	b.Close()
+	require.ErrorIs(t, s.Err(), ErrClosed)
+
+	_, err = b.Subscribe(nil, 1)
+	require.ErrorIs(t, err, ErrClosed)
+}
+
+func newGauge(id string) *metric.Metric {
+	value := 1.0
+
+	return &metric.Metric{ID: id, Type: metric.Gauge, Value: &value}
+}
+
+func TestPublishDeleted(t *testing.T) {
+	b := New()
+
+	s, err := b.Subscribe(nil, 10)
+	require.NoError(t, err)
+
+	b.Publish(newGauge("Alloc"))
+	b.PublishDeleted(&metric.Metric{ID: "Alloc", Type: metric.Gauge})
+
+	require.False(t, (<-s.Events()).Deleted)
+
+	deleted := <-s.Events()
+	require.True(t, deleted.Deleted)
+	require.Equal(t, "Alloc", deleted.Metric.ID)
+}
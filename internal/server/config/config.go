@@ -15,6 +15,7 @@ const (
 	storeIntervalDefault   = 300
 	fileStoragePathDefault = "/tmp/metrics-db.json"
 	restoreDefault         = true
+	grpcHostportDefault    = "localhost:3200"
 )
 
 // Config of HTTP server
@@ -37,6 +38,17 @@ type Config struct {
 	UseGRPC bool `env:"USE_GRPC" json:"use_grpc"`
 	// grpc hostport
 	GRPCHostPort string `env:"GRPC_ADDRESS" json:"grpc_address"`
+	// register grpc server reflection service
+	GRPCReflection bool `env:"GRPC_REFLECTION" json:"grpc_reflection"`
+	// serve grpc on the HTTP listener, requests are split by content type
+	SinglePort bool `env:"SINGLE_PORT" json:"single_port"`
+	// paths to the certificate and key of the server, listeners use TLS if they are set
+	TLSCert string `env:"TLS_CERT" json:"tls_cert"`
+	TLSKey  string `env:"TLS_KEY" json:"tls_key"`
+	// bearer token for deleting and resetting of metrics, they are forbidden if it's empty
+	AdminToken string `env:"ADMIN_TOKEN" json:"admin_token"`
+	// outgoing notifications about updates of metrics
+	Webhooks WebhooksConfig `json:"webhooks"`
 }
 
 // StorageConfig - metrics storage config
@@ -51,6 +63,60 @@ type StorageConfig struct {
 	Restore bool `env:"RESTORE" json:"restore"`
 }
 
+// WebhooksConfig - webhook subscriptions and persistent queue of their deliveries
+type WebhooksConfig struct {
+	// directory of the queue, deliveries are kept only in memory if it's empty,
+	// the directory is locked, so it can't be shared by servers
+	QueuePath string `env:"WEBHOOK_QUEUE_PATH" json:"queue_path"`
+	// subscriptions are configured only in the config file
+	Subscriptions []WebhookSubscription `json:"subscriptions"`
+}
+
+// WebhookSubscription - target of notifications about metrics which match the pattern and the kind
+type WebhookSubscription struct {
+	// unique name of the subscription
+	Name string `json:"name"`
+	// glob pattern of stored ids, ids of instances' metrics contain the instance prefix, empty pattern matches all
+	Match string `json:"match"`
+	// metrics of all kinds are matched if it's empty
+	Kind string `json:"kind"`
+	URL  string `json:"url"`
+	// key of HMAC-SHA256 signature of payloads
+	Secret string `json:"secret"`
+}
+
+const maskedSecret = "***"
+
+// String - returns config for logging, secrets are masked
+func (c Config) String() string {
+	// the type without methods, so formatting doesn't call String recursively
+	type plainConfig Config
+
+	masked := plainConfig(c)
+	masked.SingnatureKey = mask(c.SingnatureKey)
+	masked.AdminToken = mask(c.AdminToken)
+
+	return fmt.Sprintf("%+v", masked)
+}
+
+// String - returns subscription for logging, the secret is masked
+func (s WebhookSubscription) String() string {
+	type plainSubscription WebhookSubscription
+
+	masked := plainSubscription(s)
+	masked.Secret = mask(s.Secret)
+
+	return fmt.Sprintf("%+v", masked)
+}
+
+func mask(secret string) string {
+	if secret == "" {
+		return ""
+	}
+
+	return maskedSecret
+}
+
 // MakeConfig - reads configuration from application parameters and environment variables
 func MakeConfig() (Config, error) {
 	config := Config{}
@@ -66,6 +132,12 @@ func MakeConfig() (Config, error) {
 	flag.StringVar(&config.TrustedSubnet, "t", "", "trusted subnet CIDR")
 	flag.BoolVar(&config.UseGRPC, "g", false, "use GRPC")
 	flag.StringVar(&config.GRPCHostPort, "grpc-address", "", "grpc address")
+	flag.BoolVar(&config.GRPCReflection, "grpc-reflection", false, "enable grpc server reflection")
+	flag.BoolVar(&config.SinglePort, "single-port", false, "serve grpc and HTTP on the same port")
+	flag.StringVar(&config.TLSCert, "tls-cert", "", "path to TLS certificate")
+	flag.StringVar(&config.TLSKey, "tls-key", "", "path to TLS key")
+	flag.StringVar(&config.AdminToken, "admin-token", "", "bearer token of admin endpoints")
+	flag.StringVar(&config.Webhooks.QueuePath, "webhook-queue", "", "directory of the persistent queue of webhooks")
 
 	flag.Parse()
 
@@ -73,7 +145,13 @@ func MakeConfig() (Config, error) {
 		return config, fmt.Errorf("parse env err=%w", err)
 	}
 
-	return updateConfigFromFile(config), nil
+	config = updateConfigFromFile(config)
+
+	if config.GRPCHostPort == "" {
+		config.GRPCHostPort = grpcHostportDefault
+	}
+
+	return config, nil
 }
 
 func updateConfigFromFile(config Config) Config {
@@ -120,7 +198,7 @@ func updateConfigFromFile(config Config) Config {
 				}
 
 				if config.TrustedSubnet == "" {
-					config.TrustedSubnet = jsonConfig.CryptoKey
+					config.TrustedSubnet = jsonConfig.TrustedSubnet
 				}
 				if !config.UseGRPC {
 					config.UseGRPC = jsonConfig.UseGRPC
@@ -128,6 +206,27 @@ func updateConfigFromFile(config Config) Config {
 				if config.GRPCHostPort == "" {
 					config.GRPCHostPort = jsonConfig.GRPCHostPort
 				}
+				if !config.GRPCReflection {
+					config.GRPCReflection = jsonConfig.GRPCReflection
+				}
+				if !config.SinglePort {
+					config.SinglePort = jsonConfig.SinglePort
+				}
+				if config.TLSCert == "" {
+					config.TLSCert = jsonConfig.TLSCert
+				}
+				if config.TLSKey == "" {
+					config.TLSKey = jsonConfig.TLSKey
+				}
+				if config.AdminToken == "" {
+					config.AdminToken = jsonConfig.AdminToken
+				}
+				if config.Webhooks.QueuePath == "" {
+					config.Webhooks.QueuePath = jsonConfig.Webhooks.QueuePath
+				}
+				if len(config.Webhooks.Subscriptions) == 0 {
+					config.Webhooks.Subscriptions = jsonConfig.Webhooks.Subscriptions
+				}
 			}
 		}
 	}
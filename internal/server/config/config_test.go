@@ -0,0 +1,27 @@
+package config
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigStringMasksSecrets(t *testing.T) {
+	config := Config{
+		Hostport:      "localhost:8080",
+		SingnatureKey: "signature-key",
+		AdminToken:    "admin-token",
+		Webhooks: WebhooksConfig{
+			Subscriptions: []WebhookSubscription{{Name: "all", URL: "http://localhost/hook", Secret: "webhook-secret"}},
+		},
+	}
+
+	logged := fmt.Sprintf("%+v", config)
+	require.Contains(t, logged, "localhost:8080")
+	require.Contains(t, logged, "http://localhost/hook")
+	require.Contains(t, logged, maskedSecret)
+	require.NotContains(t, logged, "signature-key")
+	require.NotContains(t, logged, "admin-token")
+	require.NotContains(t, logged, "webhook-secret")
+}
@@ -0,0 +1,156 @@
+// package dashboard - HTML dashboard of metrics rendered from embedded templates and assets
+package dashboard
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+	"github.com/kuzhukin/metrics-collector/internal/server/codec"
+	"github.com/kuzhukin/metrics-collector/internal/server/history"
+)
+
+// period of reloading of the page data by the browser
+const refreshInterval = time.Second * 10
+
+// size of sparkline's view box
+const (
+	sparklineWidth  = 100
+	sparklineHeight = 20
+)
+
+//go:embed templates/*.html static/*
+var assets embed.FS
+
+var pageTemplate = template.Must(template.New("index.html").Funcs(template.FuncMap{
+	"timeISO": func(t time.Time) string { return t.Format(time.RFC3339) },
+	"timeOf":  func(t time.Time) string { return t.Format("15:04:05") },
+}).ParseFS(assets, "templates/index.html"))
+
+// Page - data of the dashboard
+type Page struct {
+	Groups []Group
+	// time of the page rendering
+	Generated time.Time
+	// seconds between reloading of data
+	RefreshInterval int
+}
+
+// Group - metrics of the same kind sorted by id
+type Group struct {
+	Kind metric.Kind
+	Rows []Row
+}
+
+type Row struct {
+	ID    string
+	Value string
+	// time of the last update after the server start, it's zero if the metric wasn't updated
+	Updated time.Time
+	// it's nil if there are less than two points in the history
+	Sparkline *Sparkline
+}
+
+// Sparkline - polyline of recent values, counters show deltas of updates
+type Sparkline struct {
+	Width  int
+	Height int
+	Points string
+	// description of the range of values for the tooltip
+	Title string
+}
+
+// HistoryFunc - returns recent points of the metric, it's nil if the history isn't recorded
+type HistoryFunc func(kind metric.Kind, id string) []history.Point
+
+// NewPage - groups metrics by kind, gauges go first
+func NewPage(metrics []*metric.Metric, historyOf HistoryFunc) Page {
+	byKind := make(map[metric.Kind][]Row)
+
+	for _, m := range metrics {
+		row := Row{ID: m.ID, Value: codec.DecodeValue(m)}
+
+		if historyOf != nil {
+			points := historyOf(m.Type, m.ID)
+
+			if len(points) > 0 {
+				row.Updated = points[len(points)-1].Time
+			}
+
+			row.Sparkline = newSparkline(points)
+		}
+
+		byKind[m.Type] = append(byKind[m.Type], row)
+	}
+
+	page := Page{Generated: time.Now(), RefreshInterval: int(refreshInterval / time.Second)}
+
+	for _, kind := range []metric.Kind{metric.Gauge, metric.Counter} {
+		rows := byKind[kind]
+		sort.Slice(rows, func(i, j int) bool { return rows[i].ID < rows[j].ID })
+
+		page.Groups = append(page.Groups, Group{Kind: kind, Rows: rows})
+	}
+
+	return page
+}
+
+// Render - writes HTML of the page, values are escaped by the template
+func Render(w io.Writer, page Page) error {
+	if err := pageTemplate.Execute(w, page); err != nil {
+		return fmt.Errorf("execute template err=%w", err)
+	}
+
+	return nil
+}
+
+func newSparkline(points []history.Point) *Sparkline {
+	if len(points) < 2 {
+		return nil
+	}
+
+	low, high := points[0].Value, points[0].Value
+	for _, p := range points {
+		if p.Value < low {
+			low = p.Value
+		}
+
+		if p.Value > high {
+			high = p.Value
+		}
+	}
+
+	coords := make([]string, 0, len(points))
+	step := float64(sparklineWidth) / float64(len(points)-1)
+
+	for i, p := range points {
+		// flat series are drawn in the middle
+		y := float64(sparklineHeight) / 2
+		if high > low {
+			y = float64(sparklineHeight) * (high - p.Value) / (high - low)
+		}
+
+		coords = append(coords, formatCoord(float64(i)*step)+","+formatCoord(y))
+	}
+
+	return &Sparkline{
+		Width:  sparklineWidth,
+		Height: sparklineHeight,
+		Points: strings.Join(coords, " "),
+		Title:  fmt.Sprintf("%d updates, min %s, max %s", len(points), formatValue(low), formatValue(high)),
+	}
+}
+
+func formatCoord(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'G', -1, 64)
+}
@@ -0,0 +1,67 @@
+package dashboard
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+	"github.com/kuzhukin/metrics-collector/internal/server/history"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPage(t *testing.T) {
+	value := 1.5
+	delta := int64(3)
+	updated := time.Now()
+
+	historyOf := func(kind metric.Kind, id string) []history.Point {
+		if id != "PollCount" {
+			return nil
+		}
+
+		return []history.Point{{Time: updated, Value: 2}, {Time: updated, Value: 2}, {Time: updated, Value: 2}}
+	}
+
+	page := NewPage([]*metric.Metric{
+		{ID: "PollCount", Type: metric.Counter, Delta: &delta},
+		{ID: "HeapAlloc", Type: metric.Gauge, Value: &value},
+		{ID: "Alloc", Type: metric.Gauge, Value: &value},
+	}, historyOf)
+
+	require.Len(t, page.Groups, 2)
+	require.Equal(t, metric.Gauge, page.Groups[0].Kind)
+	require.Equal(t, "Alloc", page.Groups[0].Rows[0].ID)
+	require.Equal(t, "HeapAlloc", page.Groups[0].Rows[1].ID)
+	require.True(t, page.Groups[0].Rows[0].Updated.IsZero())
+	require.Nil(t, page.Groups[0].Rows[0].Sparkline)
+
+	counter := page.Groups[1].Rows[0]
+	require.Equal(t, "3", counter.Value)
+	require.Equal(t, updated, counter.Updated)
+	require.Equal(t, "0.00,10.00 50.00,10.00 100.00,10.00", counter.Sparkline.Points)
+}
+
+func TestStaticHandler(t *testing.T) {
+	handler := StaticHandler("/static/")
+
+	for name, contentType := range map[string]string{
+		"/static/dashboard.css": "text/css; charset=utf-8",
+		"/static/dashboard.js":  "text/javascript; charset=utf-8",
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, name, nil))
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, contentType, w.Header().Get("Content-Type"))
+		require.NotEmpty(t, w.Body.String())
+	}
+
+	for _, name := range []string{"/static/", "/static/missing.css", "/static/../templates/index.html"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, name, nil))
+
+		require.Equal(t, http.StatusNotFound, w.Code, name)
+	}
+}
@@ -0,0 +1,50 @@
+package dashboard
+
+import (
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// content types don't depend on mime tables of the system
+var contentTypes = map[string]string{
+	".css": "text/css; charset=utf-8",
+	".js":  "text/javascript; charset=utf-8",
+}
+
+// StaticHandler - serves embedded assets under the prefix,
+// content is written without Content-Length, so it can be compressed by middleware
+func StaticHandler(prefix string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		name, ok := strings.CutPrefix(r.URL.Path, prefix)
+		if !ok || name == "" || strings.Contains(name, "/") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		data, err := fs.ReadFile(assets, path.Join("static", name))
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		contentType, ok := contentTypes[path.Ext(name)]
+		if !ok {
+			contentType = "application/octet-stream"
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		w.WriteHeader(http.StatusOK)
+
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	})
+}
@@ -0,0 +1,98 @@
+"use strict";
+
+// filtering, sorting and reloading of metrics are done in the browser,
+// the state of controls is kept in the location hash, so it survives page reloads
+(function () {
+	const filter = document.getElementById("filter");
+	const sort = document.getElementById("sort");
+	const refresh = document.getElementById("refresh");
+	const interval = Number(document.body.dataset.refresh || 10) * 1000;
+
+	function readState() {
+		const params = new URLSearchParams(location.hash.slice(1));
+		filter.value = params.get("filter") || "";
+		sort.value = params.get("sort") || "name";
+		refresh.checked = params.get("refresh") !== "off";
+	}
+
+	function writeState() {
+		const params = new URLSearchParams();
+		if (filter.value) {
+			params.set("filter", filter.value);
+		}
+		if (sort.value !== "name") {
+			params.set("sort", sort.value);
+		}
+		if (!refresh.checked) {
+			params.set("refresh", "off");
+		}
+		history.replaceState(null, "", "#" + params.toString());
+	}
+
+	function compare(a, b) {
+		switch (sort.value) {
+		case "value":
+			return Number(b.dataset.value) - Number(a.dataset.value);
+		case "updated":
+			return Number(b.dataset.updated || 0) - Number(a.dataset.updated || 0);
+		default:
+			return a.dataset.name.localeCompare(b.dataset.name);
+		}
+	}
+
+	function apply() {
+		const needle = filter.value.trim().toLowerCase();
+
+		document.querySelectorAll("section.group").forEach(function (group) {
+			const body = group.querySelector("tbody");
+			if (!body) {
+				return;
+			}
+
+			const rows = Array.from(body.rows);
+			let visible = 0;
+
+			rows.sort(compare).forEach(function (row) {
+				const matched = row.dataset.name.toLowerCase().includes(needle);
+				row.classList.toggle("hidden", !matched);
+				visible += matched ? 1 : 0;
+				body.appendChild(row);
+			});
+
+			group.querySelector(".count").textContent = needle ? visible + " / " + rows.length : rows.length;
+		});
+	}
+
+	// replaces metrics by the fresh page of the same url, which keeps the instance parameter
+	function reload() {
+		if (!refresh.checked || document.hidden) {
+			return;
+		}
+
+		fetch(location.pathname + location.search, { headers: { Accept: "text/html" } })
+			.then(function (resp) {
+				if (!resp.ok) {
+					throw new Error("status " + resp.status);
+				}
+				return resp.text();
+			})
+			.then(function (html) {
+				const fresh = new DOMParser().parseFromString(html, "text/html");
+				document.getElementById("groups").replaceWith(fresh.getElementById("groups"));
+				document.getElementById("generated").replaceWith(fresh.getElementById("generated"));
+				apply();
+			})
+			.catch(function (err) {
+				console.warn("reload metrics:", err);
+			});
+	}
+
+	readState();
+	apply();
+
+	filter.addEventListener("input", function () { writeState(); apply(); });
+	sort.addEventListener("change", function () { writeState(); apply(); });
+	refresh.addEventListener("change", writeState);
+
+	setInterval(reload, interval);
+})();
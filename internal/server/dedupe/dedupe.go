@@ -0,0 +1,223 @@
+// package dedupe - bounded cache of recently applied idempotency keys
+package dedupe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kuzhukin/metrics-collector/internal/zlog"
+)
+
+// ErrInProgress - the request with the same key isn't finished yet, it should be retried later
+var ErrInProgress = errors.New("request with the same idempotency key is in progress")
+
+// ErrApplied - the key is saved by another request, so changes of the request are discarded
+var ErrApplied = errors.New("request with the same idempotency key is applied already")
+
+// Store - persistent storage of applied keys, so keys aren't forgotten on restart of the server
+// and are shared between servers using the same store.
+// The store doesn't save keys itself: the storage applying the request saves the claim of the context
+// in the same transaction as changes of the request or fails with ErrApplied if the key is saved already.
+type Store interface {
+	// returns true if the key is saved and isn't expired at the time
+	HasKey(ctx context.Context, key string, at time.Time) (bool, error)
+	// removes keys which are expired at the time
+	DeleteExpiredKeys(ctx context.Context, at time.Time) error
+}
+
+// Claim - idempotency key which should be saved together with changes of the request
+type Claim struct {
+	Key string
+	// the saved key which is expired at the time can be claimed again
+	At       time.Time
+	ExpireAt time.Time
+}
+
+type claimKey struct{}
+
+// WithClaim - returns context with the claim of the key
+func WithClaim(ctx context.Context, claim Claim) context.Context {
+	return context.WithValue(ctx, claimKey{}, claim)
+}
+
+// ClaimFromContext - returns the claim of the key if the request is run by the cache with the store
+func ClaimFromContext(ctx context.Context) (Claim, bool) {
+	claim, ok := ctx.Value(claimKey{}).(Claim)
+	return claim, ok
+}
+
+type entry struct {
+	key      string
+	expireAt time.Time
+}
+
+// Cache - keeps keys of applied requests during TTL, the oldest keys are evicted if the size is exceeded
+type Cache struct {
+	sync.Mutex
+
+	size  int
+	ttl   time.Duration
+	store Store
+
+	applied map[string]time.Time
+	// keys in order of applying, it's also order of expiration
+	queue      []entry
+	inProgress map[string]struct{}
+	// expired keys are removed from the store once per TTL, the first time after start
+	lastPurge time.Time
+
+	now func() time.Time
+}
+
+// New - creates cache, the store is optional
+func New(size int, ttl time.Duration, store Store) *Cache {
+	return &Cache{
+		size:       size,
+		ttl:        ttl,
+		store:      store,
+		applied:    make(map[string]time.Time),
+		queue:      make([]entry, 0),
+		inProgress: make(map[string]struct{}),
+		now:        time.Now,
+	}
+}
+
+// Do - runs fn only if the key wasn't applied, returns true if fn is skipped,
+// fn is always run for empty key, the key isn't remembered if fn fails, so the request can be retried.
+// If the cache has the store, fn gets context with the claim of the key and
+// the request is skipped if fn fails with ErrApplied, because another server has applied it.
+func (c *Cache) Do(ctx context.Context, key string, fn func(ctx context.Context) error) (bool, error) {
+	if key == "" {
+		return false, fn(ctx)
+	}
+
+	applied, err := c.begin(ctx, key)
+	if err != nil || applied {
+		return applied, err
+	}
+
+	if c.store != nil {
+		now := c.now()
+		ctx = WithClaim(ctx, Claim{Key: key, At: now, ExpireAt: now.Add(c.ttl)})
+	}
+
+	if err := fn(ctx); err != nil {
+		if c.store != nil && errors.Is(err, ErrApplied) {
+			c.commit(ctx, key)
+			return true, nil
+		}
+
+		c.abort(key)
+		return false, err
+	}
+
+	c.commit(ctx, key)
+
+	return false, nil
+}
+
+// reserves the key until commit or abort, returns true if the key was applied already
+func (c *Cache) begin(ctx context.Context, key string) (bool, error) {
+	c.Lock()
+
+	now := c.now()
+	c.evict(now)
+
+	if _, ok := c.inProgress[key]; ok {
+		c.Unlock()
+		return false, ErrInProgress
+	}
+
+	if _, ok := c.applied[key]; ok {
+		c.Unlock()
+		return true, nil
+	}
+
+	c.inProgress[key] = struct{}{}
+	c.Unlock()
+
+	if c.store == nil {
+		return false, nil
+	}
+
+	// the key could be applied before restart of the server
+	saved, err := c.store.HasKey(ctx, key, now)
+	if err != nil {
+		c.abort(key)
+		return false, fmt.Errorf("check key in store, err=%w", err)
+	}
+
+	if saved {
+		c.Lock()
+		delete(c.inProgress, key)
+		c.add(key, now.Add(c.ttl))
+		c.Unlock()
+	}
+
+	return saved, nil
+}
+
+func (c *Cache) abort(key string) {
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.inProgress, key)
+}
+
+// the key is saved to the store with changes of the request, so only expired keys are removed there,
+// the request is applied already, so errors of the removal are only logged
+func (c *Cache) commit(ctx context.Context, key string) {
+	c.Lock()
+
+	now := c.now()
+	expireAt := now.Add(c.ttl)
+
+	delete(c.inProgress, key)
+	c.add(key, expireAt)
+
+	purge := c.store != nil && now.Sub(c.lastPurge) >= c.ttl
+	if purge {
+		c.lastPurge = now
+	}
+
+	c.Unlock()
+
+	if !purge {
+		return
+	}
+
+	if err := c.store.DeleteExpiredKeys(ctx, now); err != nil {
+		zlog.Logger.Errorf("Delete expired idempotency keys, err=%s", err)
+	}
+}
+
+// should be called under lock
+func (c *Cache) add(key string, expireAt time.Time) {
+	c.applied[key] = expireAt
+	c.queue = append(c.queue, entry{key: key, expireAt: expireAt})
+
+	for len(c.queue) > c.size {
+		c.removeFirst()
+	}
+}
+
+// removes expired keys, should be called under lock
+func (c *Cache) evict(now time.Time) {
+	for len(c.queue) > 0 && !c.queue[0].expireAt.After(now) {
+		c.removeFirst()
+	}
+}
+
+func (c *Cache) removeFirst() {
+	first := c.queue[0]
+	c.queue[0] = entry{}
+	c.queue = c.queue[1:]
+
+	// the key could be added again after loading from the store, then the newer entry is kept
+	if expireAt, ok := c.applied[first.key]; ok && expireAt.Equal(first.expireAt) {
+		delete(c.applied, first.key)
+	}
+}
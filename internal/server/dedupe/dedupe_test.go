@@ -0,0 +1,183 @@
+package dedupe
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type memoryStore struct {
+	keys    map[string]time.Time
+	purgeAt time.Time
+}
+
+func (s *memoryStore) HasKey(_ context.Context, key string, at time.Time) (bool, error) {
+	expireAt, ok := s.keys[key]
+	return ok && expireAt.After(at), nil
+}
+
+// saves the claim of the context like the storage applying the request
+func (s *memoryStore) claim(ctx context.Context) error {
+	claim, ok := ClaimFromContext(ctx)
+	if !ok {
+		return errors.New("no claim")
+	}
+
+	if expireAt, ok := s.keys[claim.Key]; ok && expireAt.After(claim.At) {
+		return ErrApplied
+	}
+
+	s.keys[claim.Key] = claim.ExpireAt
+	return nil
+}
+
+func (s *memoryStore) DeleteExpiredKeys(_ context.Context, at time.Time) error {
+	s.purgeAt = at
+	return nil
+}
+
+func TestDo(t *testing.T) {
+	ctx := context.Background()
+	cache := New(2, time.Minute, nil)
+
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	calls := 0
+	fn := func(context.Context) error {
+		calls++
+		return nil
+	}
+
+	replayed, err := cache.Do(ctx, "a", fn)
+	require.NoError(t, err)
+	require.False(t, replayed)
+
+	replayed, err = cache.Do(ctx, "a", fn)
+	require.NoError(t, err)
+	require.True(t, replayed)
+	require.Equal(t, 1, calls)
+
+	// requests without key are always applied
+	_, _ = cache.Do(ctx, "", fn)
+	_, _ = cache.Do(ctx, "", fn)
+	require.Equal(t, 3, calls)
+
+	// failed request can be retried
+	failure := errors.New("failure")
+	_, err = cache.Do(ctx, "b", func(context.Context) error { return failure })
+	require.ErrorIs(t, err, failure)
+
+	replayed, err = cache.Do(ctx, "b", fn)
+	require.NoError(t, err)
+	require.False(t, replayed)
+
+	// the oldest key is evicted
+	_, _ = cache.Do(ctx, "c", fn)
+
+	replayed, _ = cache.Do(ctx, "a", fn)
+	require.False(t, replayed)
+
+	// keys are expired after TTL
+	now = now.Add(time.Minute)
+
+	replayed, _ = cache.Do(ctx, "c", fn)
+	require.False(t, replayed)
+}
+
+func TestDoInProgress(t *testing.T) {
+	ctx := context.Background()
+	cache := New(10, time.Minute, nil)
+
+	_, err := cache.Do(ctx, "a", func(context.Context) error {
+		_, err := cache.Do(ctx, "a", func(context.Context) error { return nil })
+		require.ErrorIs(t, err, ErrInProgress)
+
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestDoWithStore(t *testing.T) {
+	ctx := context.Background()
+	store := &memoryStore{keys: make(map[string]time.Time)}
+
+	now := time.Now()
+
+	cache := New(10, time.Minute, store)
+	cache.now = func() time.Time { return now }
+
+	_, err := cache.Do(ctx, "a", store.claim)
+	require.NoError(t, err)
+	require.Equal(t, now.Add(time.Minute), store.keys["a"])
+
+	// keys are loaded from the store after restart
+	restarted := New(10, time.Minute, store)
+	restarted.now = func() time.Time { return now }
+
+	replayed, err := restarted.Do(ctx, "a", func(context.Context) error {
+		require.Fail(t, "applied twice")
+		return nil
+	})
+	require.NoError(t, err)
+	require.True(t, replayed)
+
+	// expired keys are removed from the store once per TTL
+	now = now.Add(time.Minute)
+
+	_, err = cache.Do(ctx, "b", store.claim)
+	require.NoError(t, err)
+	require.Equal(t, now, store.purgeAt)
+}
+
+func TestDoWithSharedStore(t *testing.T) {
+	ctx := context.Background()
+	store := &memoryStore{keys: make(map[string]time.Time)}
+
+	// caches of two servers using the same database
+	first := New(10, time.Minute, store)
+	second := New(10, time.Minute, store)
+
+	applied := 0
+	apply := func(ctx context.Context) error {
+		if err := store.claim(ctx); err != nil {
+			return err
+		}
+
+		applied++
+		return nil
+	}
+
+	// both servers get the retried batch before any of them has applied it
+	replayed, err := first.Do(ctx, "a", func(ctx context.Context) error {
+		replayed, err := second.Do(ctx, "a", apply)
+		require.NoError(t, err)
+		require.False(t, replayed)
+
+		return apply(ctx)
+	})
+	require.NoError(t, err)
+	require.True(t, replayed)
+	require.Equal(t, 1, applied)
+
+	// the key is remembered by the server which has lost the claim
+	replayed, err = first.Do(ctx, "a", func(context.Context) error {
+		require.Fail(t, "applied twice")
+		return nil
+	})
+	require.NoError(t, err)
+	require.True(t, replayed)
+
+	// errors of the store aren't hidden, so the batch can be retried
+	failure := errors.New("failure")
+	_, err = second.Do(ctx, "b", func(context.Context) error { return failure })
+	require.ErrorIs(t, err, failure)
+
+	replayed, err = first.Do(ctx, "b", apply)
+	require.NoError(t, err)
+	require.False(t, replayed)
+	require.Equal(t, 2, applied)
+}
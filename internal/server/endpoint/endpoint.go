@@ -4,11 +4,21 @@ package endpoint
 	kind:
 	 - gauge: float metric
 	 - counter: integer metric with accumulating
+
+	instance:
+	 - metrics are stored in the namespace of the instance from X-Instance-ID header
+	 - GET endpoints accept ?instance={instance} query parameter for filtering by instance
+
+	admin token:
+	 - endpoints which delete or reset metrics require "Authorization: Bearer {token}" header
 */
 
 const (
-	// GET: returning all metrics in html
+	// GET: dashboard of all metrics in html
 	RootEndpoint = "/"
+	// GET: embedded assets of the dashboard
+	StaticEndpoint = StaticPrefix + "*"
+	StaticPrefix   = "/static/"
 
 	// POST: write metric on server in json format
 	// example body: {"id": "metric", "type": "gauge",   "value": 10} - for gauge metric
@@ -18,14 +28,56 @@ const (
 	UpdateEndpoint = "/update/{kind}/{name}/{value}"
 
 	// POST: write batch metric on server in json format
+	// parameters: partial={true|false}, valid metrics are stored in partial mode even if other ones are rejected
+	// example response: {"accepted": 1, "rejected": [{"index": 1, "id": "metric", "reason": "missing_value", "message": "..."}]}
+	// reasons: unknown_kind, missing_value, bad_name
+	// batch with Idempotency-Key header is applied once, its replays are acknowledged with Idempotent-Replayed header
 	BatchUpdateEndpointJSON = "/updates/"
 
 	// POST: request metric in json format
 	// example body: {"id": "metric", "type": "gauge"}
 	ValueEndpointJSON = "/value/"
 	// GET: returning metric value
+	// DELETE: delete metric, requires admin token
 	ValueEndpoint = "/value/{kind}/{name}"
 
 	// GET: check database connection
 	PingEndpoint = "/ping"
+
+	// GET: query metrics in json format
+	// parameters: match={glob}, regex={regexp}, kind={kind}, sort={name|kind}, limit={limit}, cursor={next_cursor}
+	// example response: {"metrics": [{"id": "HeapAlloc", "type": "gauge", "value": 10}], "next_cursor": "..."}
+	// DELETE: delete metrics which match filters, requires admin token and at least one of match, regex or kind
+	// example response: {"deleted": 10}
+	MetricsQueryEndpoint = "/api/v1/metrics"
+
+	// GET: stream all metrics in csv (header "id,type,value") or ndjson format
+	// parameters: format={csv|ndjson}, csv is default
+	ExportEndpoint = "/api/v1/export"
+	// POST: import metrics in csv or ndjson format, counters are added like in updates
+	// parameters: format={csv|ndjson}, dry_run={true|false}
+	// example response: {"dry_run": false, "rows": 2, "invalid": 1, "applied": 0, "errors": [{"row": 2, "error": "..."}]}
+	ImportEndpoint = "/api/v1/import"
+
+	// GET: download gzipped tar archive with all metrics of the storage, requires admin token
+	BackupEndpoint = "/api/v1/backup"
+	// POST: restore metrics from the archive, requires admin token
+	// parameters: mode={merge|replace}, merge is default, stored counters are overwritten in both modes
+	RestoreEndpoint = "/api/v1/restore"
+
+	// GET: server-sent events about accepted updates and deletions of metrics which match filters
+	// parameters: match={glob}, regex={regexp}, kind={kind}
+	// example data of update event: {"id": "PollCount", "type": "counter", "delta": 1, "time": "2024-01-01T00:00:00Z"}
+	// events: update, delete, heartbeat and resync, the stream is finished after resync and the client should reload metrics
+	StreamEndpoint = "/api/v1/stream"
+
+	// GET: list webhook subscriptions with statistics of their deliveries, requires admin token
+	// example response: {"subscriptions": [{"name": "heap", "match": "Heap*", "url": "...", "pending": 0, "delivered": 10, "failed": 0, "dropped": 0}]}
+	WebhooksEndpoint = "/api/v1/webhooks"
+	// POST: send test payload to the subscription, requires admin token
+	// example response: {"ok": true, "status": 200, "duration_ms": 12}
+	WebhookTestEndpoint = "/api/v1/webhooks/{name}/test"
+
+	// POST: set counter to zero, requires admin token
+	ResetCounterEndpoint = "/reset/counter/{name}"
 )
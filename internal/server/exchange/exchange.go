@@ -0,0 +1,274 @@
+// package exchange - encoding and decoding of metrics snapshots in CSV and NDJSON formats
+package exchange
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+	"github.com/kuzhukin/metrics-collector/internal/server/codec"
+)
+
+type Format string
+
+const (
+	// header "id,type,value" and a row per metric, value is delta for counters
+	CSV Format = "csv"
+	// JSON object of the metric per line, like in the update API
+	NDJSON Format = "ndjson"
+)
+
+// max length of the NDJSON line
+const maxLineSize = 1024 * 1024
+
+var ErrUnknownFormat = errors.New("unknown format")
+
+// ErrBadRow - the row isn't a valid metric, decoding can be continued after it
+var ErrBadRow = errors.New("bad row")
+
+var csvHeader = []string{"id", "type", "value"}
+
+// ParseFormat - returns format by the name, csv is default
+func ParseFormat(name string) (Format, error) {
+	switch Format(strings.ToLower(name)) {
+	case CSV, "":
+		return CSV, nil
+	case NDJSON:
+		return NDJSON, nil
+	default:
+		return "", fmt.Errorf("format=%s, err=%w", name, ErrUnknownFormat)
+	}
+}
+
+// ContentType - returns MIME type of the format
+func (f Format) ContentType() string {
+	if f == NDJSON {
+		return "application/x-ndjson"
+	}
+
+	return "text/csv; charset=utf-8"
+}
+
+// Encoder - writes metrics one by one, Flush should be called after the last metric
+type Encoder interface {
+	Encode(m *metric.Metric) error
+	Flush() error
+}
+
+// NewEncoder - creates encoder of the format, output is buffered
+func NewEncoder(format Format, w io.Writer) (Encoder, error) {
+	switch format {
+	case CSV:
+		return &csvEncoder{w: csv.NewWriter(w)}, nil
+	case NDJSON:
+		return &ndjsonEncoder{w: bufio.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("format=%s, err=%w", format, ErrUnknownFormat)
+	}
+}
+
+type csvEncoder struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+func (e *csvEncoder) Encode(m *metric.Metric) error {
+	if !e.headerWritten {
+		if err := e.w.Write(csvHeader); err != nil {
+			return fmt.Errorf("write csv header err=%w", err)
+		}
+
+		e.headerWritten = true
+	}
+
+	if err := e.w.Write([]string{m.ID, string(m.Type), codec.DecodeValue(m)}); err != nil {
+		return fmt.Errorf("write csv row err=%w", err)
+	}
+
+	return nil
+}
+
+func (e *csvEncoder) Flush() error {
+	// the header is written for empty snapshot, so it can be imported back
+	if !e.headerWritten {
+		if err := e.w.Write(csvHeader); err != nil {
+			return fmt.Errorf("write csv header err=%w", err)
+		}
+
+		e.headerWritten = true
+	}
+
+	e.w.Flush()
+
+	return e.w.Error()
+}
+
+type ndjsonEncoder struct {
+	w *bufio.Writer
+}
+
+func (e *ndjsonEncoder) Encode(m *metric.Metric) error {
+	data, err := m.Serialize()
+	if err != nil {
+		return fmt.Errorf("serialize metric err=%w", err)
+	}
+
+	if _, err := e.w.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write line err=%w", err)
+	}
+
+	return nil
+}
+
+func (e *ndjsonEncoder) Flush() error {
+	return e.w.Flush()
+}
+
+// Decoder - reads metrics one by one, it returns io.EOF after the last metric,
+// errors wrapping ErrBadRow are returned for invalid rows and decoding can be continued after them
+type Decoder interface {
+	Decode() (*metric.Metric, error)
+	// number of the last decoded row, it's line number of the input
+	Row() int
+}
+
+func NewDecoder(format Format, r io.Reader) (Decoder, error) {
+	switch format {
+	case CSV:
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+		reader.ReuseRecord = true
+
+		return &csvDecoder{r: reader}, nil
+	case NDJSON:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+
+		return &ndjsonDecoder{s: scanner}, nil
+	default:
+		return nil, fmt.Errorf("format=%s, err=%w", format, ErrUnknownFormat)
+	}
+}
+
+type csvDecoder struct {
+	r   *csv.Reader
+	row int
+	// the header is optional, it's checked only in the first record
+	started bool
+}
+
+func (d *csvDecoder) Decode() (*metric.Metric, error) {
+	for {
+		record, err := d.r.Read()
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				d.row = parseErr.Line
+				return nil, fmt.Errorf("%s, err=%w", parseErr.Err, ErrBadRow)
+			}
+
+			return nil, err
+		}
+
+		d.row, _ = d.r.FieldPos(0)
+
+		first := !d.started
+		d.started = true
+
+		if first && len(record) == len(csvHeader) && strings.EqualFold(record[0], csvHeader[0]) {
+			continue
+		}
+
+		if len(record) != len(csvHeader) {
+			return nil, fmt.Errorf("%d fields instead of %d, err=%w", len(record), len(csvHeader), ErrBadRow)
+		}
+
+		return parseRow(record[0], metric.Kind(record[1]), record[2])
+	}
+}
+
+func (d *csvDecoder) Row() int {
+	return d.row
+}
+
+type ndjsonDecoder struct {
+	s   *bufio.Scanner
+	row int
+}
+
+func (d *ndjsonDecoder) Decode() (*metric.Metric, error) {
+	for d.s.Scan() {
+		d.row++
+
+		line := strings.TrimSpace(d.s.Text())
+		if line == "" {
+			continue
+		}
+
+		m := &metric.Metric{}
+		if err := json.Unmarshal([]byte(line), m); err != nil {
+			return nil, fmt.Errorf("%s, err=%w", err, ErrBadRow)
+		}
+
+		return validate(m)
+	}
+
+	if err := d.s.Err(); err != nil {
+		return nil, fmt.Errorf("read line err=%w", err)
+	}
+
+	return nil, io.EOF
+}
+
+func (d *ndjsonDecoder) Row() int {
+	return d.row
+}
+
+func parseRow(id string, kind metric.Kind, value string) (*metric.Metric, error) {
+	if kind != metric.Gauge && kind != metric.Counter {
+		return nil, fmt.Errorf("unknown type=%s, err=%w", kind, ErrBadRow)
+	}
+
+	delta, gauge, err := codec.Encode(kind, value)
+	if err != nil {
+		return nil, fmt.Errorf("bad value=%s, err=%w", value, ErrBadRow)
+	}
+
+	return validate(&metric.Metric{ID: id, Type: kind, Delta: delta, Value: gauge})
+}
+
+// checks the metric, only value of the metric kind is kept
+func validate(m *metric.Metric) (*metric.Metric, error) {
+	if m.ID == "" {
+		return nil, fmt.Errorf("empty id, err=%w", ErrBadRow)
+	}
+
+	switch m.Type {
+	case metric.Gauge:
+		if m.Value == nil {
+			return nil, fmt.Errorf("gauge id=%s without value, err=%w", m.ID, ErrBadRow)
+		}
+
+		if math.IsNaN(*m.Value) || math.IsInf(*m.Value, 0) {
+			return nil, fmt.Errorf("gauge id=%s has not finite value, err=%w", m.ID, ErrBadRow)
+		}
+
+		m.Delta = nil
+	case metric.Counter:
+		if m.Delta == nil {
+			return nil, fmt.Errorf("counter id=%s without delta, err=%w", m.ID, ErrBadRow)
+		}
+
+		m.Value = nil
+	default:
+		return nil, fmt.Errorf("unknown type=%s, err=%w", m.Type, ErrBadRow)
+	}
+
+	return m, nil
+}
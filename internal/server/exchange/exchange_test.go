@@ -0,0 +1,116 @@
+package exchange
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeAll(t *testing.T, format Format, input string) ([]*metric.Metric, map[int]error) {
+	decoder, err := NewDecoder(format, strings.NewReader(input))
+	require.NoError(t, err)
+
+	metrics := make([]*metric.Metric, 0)
+	rowErrors := make(map[int]error)
+
+	for {
+		m, err := decoder.Decode()
+		if errors.Is(err, io.EOF) {
+			return metrics, rowErrors
+		}
+
+		if err != nil {
+			require.ErrorIs(t, err, ErrBadRow)
+			rowErrors[decoder.Row()] = err
+
+			continue
+		}
+
+		metrics = append(metrics, m)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	gauge := 1.5
+	delta := int64(7)
+
+	metrics := []*metric.Metric{
+		{ID: "Alloc", Type: metric.Gauge, Value: &gauge},
+		{ID: "name, with \"quotes\"", Type: metric.Counter, Delta: &delta},
+	}
+
+	for _, format := range []Format{CSV, NDJSON} {
+		buf := &bytes.Buffer{}
+
+		encoder, err := NewEncoder(format, buf)
+		require.NoError(t, err)
+
+		for _, m := range metrics {
+			require.NoError(t, encoder.Encode(m))
+		}
+
+		require.NoError(t, encoder.Flush())
+
+		decoded, rowErrors := decodeAll(t, format, buf.String())
+		require.Empty(t, rowErrors)
+		require.Equal(t, metrics, decoded, format)
+	}
+}
+
+func TestEmptyCSVHasHeader(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	encoder, err := NewEncoder(CSV, buf)
+	require.NoError(t, err)
+	require.NoError(t, encoder.Flush())
+	require.Equal(t, "id,type,value\n", buf.String())
+}
+
+func TestDecodeBadRows(t *testing.T) {
+	metrics, rowErrors := decodeAll(t, CSV, "Alloc,gauge,1\n"+
+		"Alloc,histogram,1\n"+
+		"PollCount,counter,1.5\n"+
+		"Alloc,gauge\n"+
+		",gauge,1\n"+
+		"Alloc,gauge,NaN\n"+
+		"PollCount,counter,2\n")
+
+	require.Len(t, metrics, 2)
+	require.Equal(t, "PollCount", metrics[1].ID)
+	require.Equal(t, int64(2), *metrics[1].Delta)
+
+	rows := make([]int, 0, len(rowErrors))
+	for row := range rowErrors {
+		rows = append(rows, row)
+	}
+
+	require.ElementsMatch(t, []int{2, 3, 4, 5, 6}, rows)
+
+	metrics, rowErrors = decodeAll(t, NDJSON, `{"id":"Alloc","type":"gauge","value":1,"delta":5}`+"\n\n"+
+		`{"id":"Alloc","type":"gauge"}`+"\n"+
+		`not json`+"\n")
+
+	require.Len(t, metrics, 1)
+	require.Nil(t, metrics[0].Delta)
+	require.Len(t, rowErrors, 2)
+	require.Contains(t, rowErrors, 3)
+	require.Contains(t, rowErrors, 4)
+}
+
+func TestParseFormat(t *testing.T) {
+	format, err := ParseFormat("")
+	require.NoError(t, err)
+	require.Equal(t, CSV, format)
+
+	format, err = ParseFormat("NDJSON")
+	require.NoError(t, err)
+	require.Equal(t, NDJSON, format)
+
+	_, err = ParseFormat("xml")
+	require.ErrorIs(t, err, ErrUnknownFormat)
+}
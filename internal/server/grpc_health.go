@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	pb "github.com/kuzhukin/metrics-collector/internal/proto"
+	pbv2 "github.com/kuzhukin/metrics-collector/internal/proto/v2"
+	"github.com/kuzhukin/metrics-collector/internal/server/storage/dbstorage"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	// interval of checking of the database connection
+	healthCheckInterval = time.Second * 5
+	// max duration of the single check, the unresponsive database is seen as not serving
+	healthCheckTimeout = time.Second * 3
+)
+
+// healthChecker - sets serving status of the grpc health service by health of the storage,
+// the server is always serving if the database storage isn't used
+type healthChecker struct {
+	health *health.Server
+	db     *dbstorage.DBStorage
+
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newHealthChecker(db *dbstorage.DBStorage) *healthChecker {
+	checker := &healthChecker{
+		health: health.NewServer(),
+		db:     db,
+		done:   make(chan struct{}),
+	}
+
+	status := healthpb.HealthCheckResponse_SERVING
+	if db != nil {
+		status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+
+	checker.setStatus(status)
+
+	return checker
+}
+
+// start - runs checking of the database until shutdown
+func (c *healthChecker) start() {
+	if c.db == nil {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(healthCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			c.check()
+
+			select {
+			case <-ticker.C:
+			case <-c.done:
+				return
+			}
+		}
+	}()
+}
+
+func (c *healthChecker) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-c.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	if c.db.CheckConnection(ctx) {
+		status = healthpb.HealthCheckResponse_SERVING
+	}
+
+	c.setStatus(status)
+}
+
+// the overall status and status of the metrics services are the same
+func (c *healthChecker) setStatus(status healthpb.HealthCheckResponse_ServingStatus) {
+	c.health.SetServingStatus("", status)
+	c.health.SetServingStatus(pb.MetricsService_ServiceDesc.ServiceName, status)
+	c.health.SetServingStatus(pbv2.MetricsService_ServiceDesc.ServiceName, status)
+}
+
+// shutdown - sets NOT_SERVING status for all services, later checks don't change it
+func (c *healthChecker) shutdown() {
+	c.stopOnce.Do(func() {
+		close(c.done)
+		c.health.Shutdown()
+	})
+}
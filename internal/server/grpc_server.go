@@ -2,57 +2,803 @@ package server
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"net/netip"
+	"path"
+	"strings"
+	"time"
 
+	"github.com/kuzhukin/metrics-collector/internal/crypto"
+	"github.com/kuzhukin/metrics-collector/internal/idempotency"
+	"github.com/kuzhukin/metrics-collector/internal/instance"
 	"github.com/kuzhukin/metrics-collector/internal/metric"
 	pb "github.com/kuzhukin/metrics-collector/internal/proto"
+	pbv2 "github.com/kuzhukin/metrics-collector/internal/proto/v2"
+	"github.com/kuzhukin/metrics-collector/internal/server/broadcaster"
 	"github.com/kuzhukin/metrics-collector/internal/server/config"
+	"github.com/kuzhukin/metrics-collector/internal/server/dedupe"
 	"github.com/kuzhukin/metrics-collector/internal/server/storage"
+	"github.com/kuzhukin/metrics-collector/internal/server/storage/dbstorage"
+	"github.com/kuzhukin/metrics-collector/internal/zlog"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 var _ pb.MetricsServiceServer = &GRPCMetricServer{}
 
+var errBadSignature = errors.New("bad data signature")
+
+// min interval of clients keepalive pings, it must not exceed the agent's default keepalive interval (30s),
+// otherwise the server closes connections of idle agents with "too many pings" error
+var keepaliveMinTime = time.Second * 10
+
+const (
+	listPageSizeDefault = 100
+	listPageSizeMax     = 1000
+
+	// count of received and not applied metrics of the single stream
+	streamBufferSize = 1024
+	// max count of metrics applied to the storage at once
+	streamBatchSize = 128
+	// max delay of applying of received metrics
+	streamFlushInterval = time.Millisecond * 100
+
+	// count of events which aren't sent to the watcher, the watcher is evicted if it's exceeded
+	watchBufferSize = 1024
+)
+
 type GRPCMetricServer struct {
 	pb.UnimplementedMetricsServiceServer
 	storage storage.Storage
-	server  *grpc.Server
+	// database storage for the connection checking, it's nil if another storage is used
+	db *dbstorage.DBStorage
+	// source of events for watchers
+	broadcaster *broadcaster.Broadcaster
+	// keys of applied batches, batches aren't deduplicated if it's nil
+	dedupe *dedupe.Cache
+	// serving status for the grpc health service
+	health *healthChecker
+	server *grpc.Server
 }
 
-func NewGrpcServer(storage storage.Storage, config *config.Config) (*GRPCMetricServer, error) {
-	listen, err := net.Listen("tcp", config.Hostport)
-	if err != nil {
-		return nil, fmt.Errorf("grpc listen err=%w", err)
+// NewGrpcServer - creates grpc server with the same security checks as HTTP router has
+func NewGrpcServer(
+	storage storage.Storage,
+	db *dbstorage.DBStorage,
+	broadcaster *broadcaster.Broadcaster,
+	dedupe *dedupe.Cache,
+	config *config.Config,
+) (*GRPCMetricServer, error) {
+	var interceptors []grpc.UnaryServerInterceptor
+	var streamInterceptors []grpc.StreamServerInterceptor
+
+	if config.TrustedSubnet != "" {
+		trustedPrefix, err := netip.ParsePrefix(config.TrustedSubnet)
+		if err != nil {
+			return nil, fmt.Errorf("parse trusted subnet=%s, err=%w", config.TrustedSubnet, err)
+		}
+
+		unary, stream := newTrustedSubnetInterceptors(trustedPrefix)
+
+		interceptors = append(interceptors, unary)
+		streamInterceptors = append(streamInterceptors, stream)
+	}
+
+	if config.CryptoKey != "" || config.SingnatureKey != "" {
+		payloadInterceptor, err := newPayloadInterceptor(config.CryptoKey, config.SingnatureKey)
+		if err != nil {
+			return nil, fmt.Errorf("new payload interceptor, err=%w", err)
+		}
+
+		interceptors = append(interceptors, payloadInterceptor)
+	}
+
+	if config.CryptoKey != "" {
+		streamInterceptors = append(streamInterceptors, encryptedStreamInterceptor)
+	}
+
+	options := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(interceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+		// agents ping connections between reports when there are no active calls
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             keepaliveMinTime,
+			PermitWithoutStream: true,
+		}),
+	}
+
+	// TLS of the single-port mode is terminated by HTTP server
+	if config.TLSCert != "" && !config.SinglePort {
+		creds, err := credentials.NewServerTLSFromFile(config.TLSCert, config.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("load TLS certificate=%s, err=%w", config.TLSCert, err)
+		}
+
+		options = append(options, grpc.Creds(creds))
 	}
 
-	s := grpc.NewServer()
+	s := grpc.NewServer(options...)
 
-	grpcMetricServer := &GRPCMetricServer{storage: storage, server: s}
+	grpcMetricServer := &GRPCMetricServer{
+		storage:     storage,
+		db:          db,
+		broadcaster: broadcaster,
+		dedupe:      dedupe,
+		health:      newHealthChecker(db),
+		server:      s,
+	}
 
 	pb.RegisterMetricsServiceServer(s, grpcMetricServer)
+	pbv2.RegisterMetricsServiceServer(s, &grpcMetricServerV2{storage: storage, dedupe: dedupe})
+	healthpb.RegisterHealthServer(s, grpcMetricServer.health.health)
 
-	if err := s.Serve(listen); err != nil {
-		return nil, fmt.Errorf("serve err=%w", err)
+	if config.GRPCReflection {
+		reflection.Register(s)
 	}
 
 	return grpcMetricServer, nil
 }
 
+// Serve - accepts connections on the listener until the server is stopped
+func (s *GRPCMetricServer) Serve(listener net.Listener) error {
+	s.health.start()
+
+	return s.server.Serve(listener)
+}
+
+// HTTPHandler - returns handler for serving by HTTP/2 server instead of Serve
+func (s *GRPCMetricServer) HTTPHandler() http.Handler {
+	s.health.start()
+
+	return s.server
+}
+
+// Shutdown - sets NOT_SERVING status and finishes watchers, other active calls aren't interrupted.
+// It's used instead of GracefulStop if the server is served by HTTP server, which finishes active calls itself
+func (s *GRPCMetricServer) Shutdown() {
+	// probes see the server as not serving while active calls are finishing
+	s.health.shutdown()
+	// watchers are finished before the server, otherwise they keep streams open
+	s.broadcaster.Close()
+}
+
+// GracefulStop - waits for finishing of active calls until the context is done, then stops the server forcibly
+func (s *GRPCMetricServer) GracefulStop(ctx context.Context) {
+	s.Shutdown()
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		s.server.GracefulStop()
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.server.Stop()
+		<-done
+	}
+}
+
+// Stop - closes all connections and interrupts active calls
 func (s *GRPCMetricServer) Stop() {
+	s.Shutdown()
 	s.server.Stop()
 }
 
 func (s *GRPCMetricServer) BatchUpdate(ctx context.Context, req *pb.BatchUpdateRequest) (*pb.BatchUpdateResponse, error) {
+	inst, err := grpcInstance(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	metrics := make([]*metric.Metric, 0, len(req.Metric))
 
 	for _, m := range req.Metric {
-		metrics = append(metrics, &metric.Metric{ID: m.Id, Delta: &m.Delta, Value: &m.Value, Type: metric.Kind(m.Type)})
+		converted, err := fromPbMetric(inst, m)
+		if err != nil {
+			return nil, err
+		}
+
+		metrics = append(metrics, converted)
 	}
 
-	if err := s.storage.BatchUpdate(ctx, metrics); err != nil {
-		return nil, fmt.Errorf("batch update err %s", err)
+	if err := batchUpdateOnce(ctx, s.storage, s.dedupe, inst, metrics); err != nil {
+		return nil, err
 	}
 
 	return &pb.BatchUpdateResponse{}, nil
 }
+
+func (s *GRPCMetricServer) Update(ctx context.Context, req *pb.UpdateRequest) (*pb.UpdateResponse, error) {
+	inst, err := grpcInstance(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := fromPbMetric(inst, req.Metric)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.storage.Update(ctx, m); err != nil {
+		return nil, storageError(fmt.Errorf("update err=%w", err))
+	}
+
+	// counters are summed in the storage, so the actual state is returned
+	updated, err := s.storage.Get(ctx, m.Type, m.ID)
+	if err != nil {
+		return nil, storageError(fmt.Errorf("get updated metric err=%w", err))
+	}
+
+	return &pb.UpdateResponse{Metric: toPbMetric(req.Metric.Id, updated)}, nil
+}
+
+func (s *GRPCMetricServer) Get(ctx context.Context, req *pb.GetRequest) (*pb.GetResponse, error) {
+	inst, err := grpcInstance(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := s.storage.Get(ctx, metric.Kind(req.Type), instance.Namespace(inst, req.Id))
+	if err != nil {
+		return nil, storageError(fmt.Errorf("get err=%w", err))
+	}
+
+	return &pb.GetResponse{Metric: toPbMetric(req.Id, m)}, nil
+}
+
+func (s *GRPCMetricServer) List(ctx context.Context, req *pb.ListRequest) (*pb.ListResponse, error) {
+	inst, err := grpcInstance(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Type != "" && !isKnownKind(metric.Kind(req.Type)) {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown metric type=%s", req.Type)
+	}
+
+	if req.PageSize < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "negative page size=%d", req.PageSize)
+	}
+
+	after, err := decodePageToken(req.PageToken)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "bad page token, err=%s", err)
+	}
+
+	pageSize := int(req.PageSize)
+	if pageSize == 0 {
+		pageSize = listPageSizeDefault
+	} else if pageSize > listPageSizeMax {
+		pageSize = listPageSizeMax
+	}
+
+	query := storage.Query{
+		Prefix: instance.Namespace(inst, req.IdPrefix),
+		Kind:   metric.Kind(req.Type),
+		Sort:   storage.SortByKind,
+		// the extra metric shows that the next page exists
+		Limit: pageSize + 1,
+	}
+
+	if after != nil {
+		query.After = &metric.Metric{ID: instance.Namespace(inst, after.Id), Type: metric.Kind(after.Type)}
+	}
+
+	metrics, err := s.storage.Query(ctx, query)
+	if err != nil {
+		return nil, storageError(fmt.Errorf("query err=%w", err))
+	}
+
+	page := make([]*pb.Metric, 0, len(metrics))
+
+	for _, m := range metrics {
+		id, _ := instance.Strip(inst, m.ID)
+		page = append(page, toPbMetric(id, m))
+	}
+
+	resp := &pb.ListResponse{Metrics: page}
+
+	if len(page) > pageSize {
+		resp.Metrics = page[:pageSize]
+		resp.NextPageToken = encodePageToken(page[pageSize-1])
+	}
+
+	return resp, nil
+}
+
+// StreamUpdates - receives metrics to the bounded buffer and applies them to the storage in micro-batches,
+// the receiving is blocked by grpc flow control while the buffer is full
+func (s *GRPCMetricServer) StreamUpdates(stream pb.MetricsService_StreamUpdatesServer) error {
+	ctx := stream.Context()
+
+	inst, err := grpcInstance(ctx)
+	if err != nil {
+		return err
+	}
+
+	buffer := make(chan *pb.Metric, streamBufferSize)
+	recvErr := make(chan error, 1)
+
+	go func() {
+		defer close(buffer)
+
+		for {
+			m, err := stream.Recv()
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					recvErr <- err
+				}
+
+				return
+			}
+
+			select {
+			case buffer <- m:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	summary := &pb.Summary{}
+	batch := make([]*metric.Metric, 0, streamBatchSize)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		if err := s.storage.BatchUpdate(ctx, batch); err != nil {
+			return storageError(fmt.Errorf("stream batch update err=%w", err))
+		}
+
+		summary.Applied += int64(len(batch))
+		batch = make([]*metric.Metric, 0, streamBatchSize)
+
+		return nil
+	}
+
+	ticker := time.NewTicker(streamFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case m, ok := <-buffer:
+			if !ok {
+				if err := flush(); err != nil {
+					return err
+				}
+
+				select {
+				case err := <-recvErr:
+					return err
+				default:
+					return stream.SendAndClose(summary)
+				}
+			}
+
+			summary.Received++
+
+			converted, err := fromPbMetric(inst, m)
+			if err != nil {
+				zlog.Logger.Warnf("skip stream metric=%v, err=%s", m, err)
+				summary.Rejected++
+
+				continue
+			}
+
+			batch = append(batch, converted)
+
+			if len(batch) >= streamBatchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		case <-ticker.C:
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Watch - sends accepted updates which match the filter until the watcher disconnects or is evicted
+func (s *GRPCMetricServer) Watch(req *pb.WatchRequest, stream pb.MetricsService_WatchServer) error {
+	inst, err := grpcInstance(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	filter, err := makeWatchFilter(inst, req)
+	if err != nil {
+		return err
+	}
+
+	subscription, err := s.broadcaster.Subscribe(filter, watchBufferSize)
+	if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	defer subscription.Close()
+
+	for {
+		select {
+		case event := <-subscription.Events():
+			// watchers receive only updates, because metric events don't have deletion flag
+			if event.Deleted {
+				continue
+			}
+
+			id, _ := instance.Strip(inst, event.Metric.ID)
+
+			if err := stream.Send(&pb.MetricEvent{Metric: toPbMetric(id, event.Metric), Time: timestamppb.New(event.Time)}); err != nil {
+				return err
+			}
+		case <-subscription.Done():
+			if errors.Is(subscription.Err(), broadcaster.ErrSlowConsumer) {
+				return status.Error(codes.ResourceExhausted, subscription.Err().Error())
+			}
+
+			return status.Error(codes.Unavailable, "server is stopping")
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// metrics of other instances aren't watched if the watcher has instance
+func makeWatchFilter(inst string, req *pb.WatchRequest) (func(m *metric.Metric) bool, error) {
+	for _, pattern := range req.NamePatterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "bad name pattern=%s", pattern)
+		}
+	}
+
+	kinds := make(map[metric.Kind]bool, len(req.Kinds))
+
+	for _, kind := range req.Kinds {
+		if !isKnownKind(metric.Kind(kind)) {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown metric type=%s", kind)
+		}
+
+		kinds[metric.Kind(kind)] = true
+	}
+
+	return func(m *metric.Metric) bool {
+		if len(kinds) != 0 && !kinds[m.Type] {
+			return false
+		}
+
+		id, ok := instance.Strip(inst, m.ID)
+		if !ok {
+			return false
+		}
+
+		if len(req.NamePatterns) == 0 {
+			return true
+		}
+
+		for _, pattern := range req.NamePatterns {
+			if matched, _ := path.Match(pattern, id); matched {
+				return true
+			}
+		}
+
+		return false
+	}, nil
+}
+
+func (s *GRPCMetricServer) Ping(ctx context.Context, _ *pb.PingRequest) (*pb.PingResponse, error) {
+	if s.db == nil {
+		return nil, status.Error(codes.FailedPrecondition, "database storage isn't used")
+	}
+
+	if !s.db.CheckConnection(ctx) {
+		return nil, status.Error(codes.Unavailable, "database isn't available")
+	}
+
+	return &pb.PingResponse{}, nil
+}
+
+// converts metric from request, only value of the metric kind is stored
+func fromPbMetric(inst string, m *pb.Metric) (*metric.Metric, error) {
+	if m == nil || m.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "metric id is empty")
+	}
+
+	if err := instance.ValidateMetricID(m.Id); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	converted := &metric.Metric{ID: instance.Namespace(inst, m.Id), Type: metric.Kind(m.Type)}
+
+	switch converted.Type {
+	case metric.Gauge:
+		value := m.Value
+		converted.Value = &value
+	case metric.Counter:
+		delta := m.Delta
+		converted.Delta = &delta
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "metric id=%s has unknown type=%s", m.Id, m.Type)
+	}
+
+	return converted, nil
+}
+
+// id is passed without instance namespace
+func toPbMetric(id string, m *metric.Metric) *pb.Metric {
+	converted := &pb.Metric{Id: id, Type: string(m.Type)}
+
+	if m.Delta != nil {
+		converted.Delta = *m.Delta
+	}
+
+	if m.Value != nil {
+		converted.Value = *m.Value
+	}
+
+	return converted
+}
+
+func isKnownKind(kind metric.Kind) bool {
+	return kind == metric.Gauge || kind == metric.Counter
+}
+
+// maps storage errors to grpc status codes
+func storageError(err error) error {
+	switch {
+	case errors.Is(err, storage.ErrUnknownMetric):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, storage.ErrUnknownKind):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	default:
+		zlog.Logger.Errorf("storage err=%s", err)
+		return status.Error(codes.Internal, "internal error")
+	}
+}
+
+// the page token is the key of the last metric of the page, metrics are sorted by type and id
+func encodePageToken(last *pb.Metric) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(last.Type + "/" + last.Id))
+}
+
+func decodePageToken(token string) (*pb.Metric, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+
+	kind, id, ok := strings.Cut(string(data), "/")
+	if !ok {
+		return nil, errors.New("token doesn't contain metric key")
+	}
+
+	return &pb.Metric{Type: kind, Id: id}, nil
+}
+
+// applies the batch once per idempotency key from metadata, replayed batch is acknowledged without applying
+func batchUpdateOnce(ctx context.Context, s storage.Storage, cache *dedupe.Cache, inst string, metrics []*metric.Metric) error {
+	var key string
+	if values := metadata.ValueFromIncomingContext(ctx, idempotency.MetadataKey); len(values) > 0 {
+		key = values[0]
+	}
+
+	if err := idempotency.Validate(key); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	apply := func(ctx context.Context) error {
+		return s.BatchUpdate(ctx, metrics)
+	}
+
+	if cache == nil || key == "" {
+		if err := apply(ctx); err != nil {
+			return storageError(fmt.Errorf("batch update err=%w", err))
+		}
+
+		return nil
+	}
+
+	// keys of different instances don't collide
+	replayed, err := cache.Do(ctx, instance.Namespace(inst, key), apply)
+	if errors.Is(err, dedupe.ErrInProgress) {
+		return status.Error(codes.Aborted, err.Error())
+	}
+
+	if err != nil {
+		return storageError(fmt.Errorf("batch update err=%w", err))
+	}
+
+	if replayed {
+		zlog.Logger.Infof("Batch with idempotency key=%s, instance=%s is applied already", key, inst)
+	}
+
+	return nil
+}
+
+// returns instance of the request from the identity metadata
+func grpcInstance(ctx context.Context) (string, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", nil
+	}
+
+	values := md.Get(instance.IDMetadataKey)
+	if len(values) == 0 {
+		return "", nil
+	}
+
+	if err := instance.Validate(values[0]); err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return values[0], nil
+}
+
+// rejects requests from agents which real ip isn't in the trusted subnet
+func newTrustedSubnetInterceptors(trustedSubnet netip.Prefix) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	unary := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if isHealthMethod(info.FullMethod) {
+			return handler(ctx, req)
+		}
+
+		if err := checkRealIP(ctx, trustedSubnet); err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+
+	stream := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if isHealthMethod(info.FullMethod) {
+			return handler(srv, ss)
+		}
+
+		if err := checkRealIP(ss.Context(), trustedSubnet); err != nil {
+			return err
+		}
+
+		return handler(srv, ss)
+	}
+
+	return unary, stream
+}
+
+// health probes are sent by orchestration, not by agents, so they don't have agent's real ip and
+// are intentionally allowed from any address. The health service exposes only serving status
+func isHealthMethod(method string) bool {
+	return strings.HasPrefix(method, "/"+healthpb.Health_ServiceDesc.ServiceName+"/")
+}
+
+func checkRealIP(ctx context.Context, trustedSubnet netip.Prefix) error {
+	var realIP string
+	if values := metadata.ValueFromIncomingContext(ctx, pb.RealIPMetadataKey); len(values) > 0 {
+		realIP = values[0]
+	}
+
+	ip, err := netip.ParseAddr(realIP)
+	if err != nil {
+		zlog.Logger.Errorf("parse ip addr err=%s", err)
+		return status.Errorf(codes.PermissionDenied, "bad real ip=%s", realIP)
+	}
+
+	if !trustedSubnet.Contains(ip) {
+		zlog.Logger.Errorf("no such IP=%s in trusted subnet=%s", ip, trustedSubnet)
+		return status.Errorf(codes.PermissionDenied, "ip=%s isn't in trusted subnet", ip)
+	}
+
+	return nil
+}
+
+// messages of the metrics stream aren't encrypted, so the stream is rejected if the server requires encryption
+func encryptedStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if info.FullMethod == pb.MetricsService_StreamUpdates_FullMethodName {
+		return status.Errorf(codes.FailedPrecondition, "method=%s doesn't support encryption", info.FullMethod)
+	}
+
+	return handler(srv, ss)
+}
+
+// decrypts sealed requests and checks signature of the decrypted request,
+// unsigned requests are accepted like in HTTP sign check handler
+func newPayloadInterceptor(cryptoKey string, signatureKey string) (grpc.UnaryServerInterceptor, error) {
+	var decryptor *crypto.Decryptor
+
+	if cryptoKey != "" {
+		var err error
+		decryptor, err = crypto.NewDecryptor(cryptoKey)
+		if err != nil {
+			return nil, fmt.Errorf("new decryptor, keyPath=%v, err=%w", cryptoKey, err)
+		}
+	}
+
+	var key []byte
+	if signatureKey != "" {
+		key = []byte(signatureKey)
+	}
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		msg, ok := req.(proto.Message)
+		if !ok {
+			return nil, status.Errorf(codes.Internal, "request of method=%s isn't proto message", info.FullMethod)
+		}
+
+		var data []byte
+		var err error
+
+		if decryptor != nil && pb.Sealable(msg) {
+			data, err = pb.Open(msg, decryptor.Decrypt)
+			if err != nil {
+				zlog.Logger.Warnf("open request method=%s, err=%s", info.FullMethod, err)
+				return nil, status.Error(codes.InvalidArgument, "can't decrypt request")
+			}
+		}
+
+		if err := checkSignature(ctx, msg, data, key); err != nil {
+			zlog.Logger.Warnf("Bad data signature method=%s, err=%s", info.FullMethod, err)
+			return nil, status.Error(codes.InvalidArgument, "bad request signature")
+		}
+
+		return handler(ctx, req)
+	}, nil
+}
+
+func checkSignature(ctx context.Context, msg proto.Message, data []byte, key []byte) error {
+	values := metadata.ValueFromIncomingContext(ctx, pb.SignatureMetadataKey)
+	if key == nil || len(values) == 0 {
+		return nil
+	}
+
+	expectedHash, err := hex.DecodeString(values[0])
+	if err != nil {
+		return fmt.Errorf("decode signature from hex, err=%w", err)
+	}
+
+	if data == nil {
+		data, err = pb.Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("marshal request err=%w", err)
+		}
+	}
+
+	// signatures of requests with instance are checked with the instance's key
+	if values := metadata.ValueFromIncomingContext(ctx, instance.IDMetadataKey); len(values) != 0 {
+		key = instance.SigningKey(key, values[0])
+	}
+
+	hasher := hmac.New(sha256.New, key)
+	hasher.Write(data)
+
+	if !hmac.Equal(hasher.Sum(nil), expectedHash) {
+		return errBadSignature
+	}
+
+	return nil
+}
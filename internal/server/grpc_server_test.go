@@ -0,0 +1,553 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kuzhukin/metrics-collector/internal/crypto"
+	"github.com/kuzhukin/metrics-collector/internal/idempotency"
+	"github.com/kuzhukin/metrics-collector/internal/instance"
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+	pb "github.com/kuzhukin/metrics-collector/internal/proto"
+	"github.com/kuzhukin/metrics-collector/internal/server/broadcaster"
+	"github.com/kuzhukin/metrics-collector/internal/server/config"
+	"github.com/kuzhukin/metrics-collector/internal/server/dedupe"
+	"github.com/kuzhukin/metrics-collector/internal/server/storage/memorystorage"
+	"github.com/kuzhukin/metrics-collector/internal/server/storage/notifystorage"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestGRPCServerSecurity(t *testing.T) {
+	publicKeyPath, privateKeyPath := writeRSAKeys(t)
+
+	encryptor, err := crypto.NewEncryptor(publicKeyPath)
+	require.NoError(t, err)
+
+	signKey := "secret"
+
+	request := &pb.BatchUpdateRequest{Metric: []*pb.Metric{{Id: "Alloc", Type: "gauge", Value: 1.5}}}
+	data, err := pb.Marshal(request)
+	require.NoError(t, err)
+
+	sealed, err := pb.Seal(request, data, encryptor.Encrypt)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		realIP       string
+		signKey      string
+		request      *pb.BatchUpdateRequest
+		expectedCode codes.Code
+	}{
+		{
+			name:         "signed and encrypted",
+			realIP:       "192.168.1.10",
+			signKey:      signKey,
+			request:      sealed.(*pb.BatchUpdateRequest),
+			expectedCode: codes.OK,
+		},
+		{
+			name:         "unsigned",
+			realIP:       "192.168.1.10",
+			request:      sealed.(*pb.BatchUpdateRequest),
+			expectedCode: codes.OK,
+		},
+		{
+			name:         "bad signature",
+			realIP:       "192.168.1.10",
+			signKey:      "other",
+			request:      sealed.(*pb.BatchUpdateRequest),
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "not encrypted",
+			realIP:       "192.168.1.10",
+			signKey:      signKey,
+			request:      request,
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "untrusted ip",
+			realIP:       "10.0.0.1",
+			signKey:      signKey,
+			request:      sealed.(*pb.BatchUpdateRequest),
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			name:         "without ip",
+			signKey:      signKey,
+			request:      sealed.(*pb.BatchUpdateRequest),
+			expectedCode: codes.PermissionDenied,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storage := memorystorage.New()
+
+			client := startTestGRPCServer(t, storage, &config.Config{
+				SingnatureKey: signKey,
+				CryptoKey:     privateKeyPath,
+				TrustedSubnet: "192.168.1.0/24",
+			})
+
+			ctx := context.Background()
+			if test.realIP != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, pb.RealIPMetadataKey, test.realIP)
+			}
+
+			if test.signKey != "" {
+				hasher := hmac.New(sha256.New, []byte(test.signKey))
+				hasher.Write(data)
+
+				ctx = metadata.AppendToOutgoingContext(ctx, pb.SignatureMetadataKey, hex.EncodeToString(hasher.Sum(nil)))
+			}
+
+			_, err := client.BatchUpdate(ctx, test.request)
+			require.Equal(t, test.expectedCode, status.Code(err), err)
+
+			if test.expectedCode != codes.OK {
+				return
+			}
+
+			stored, err := storage.Get(ctx, metric.Gauge, "Alloc")
+			require.NoError(t, err)
+			require.Equal(t, 1.5, *stored.Value)
+		})
+	}
+}
+
+func TestGRPCServerInstanceSignature(t *testing.T) {
+	storage := memorystorage.New()
+	client := startTestGRPCServer(t, storage, &config.Config{SingnatureKey: "secret"})
+
+	request := &pb.BatchUpdateRequest{Metric: []*pb.Metric{{Id: "Alloc", Type: "gauge", Value: 1.5}}}
+	data, err := pb.Marshal(request)
+	require.NoError(t, err)
+
+	sign := func(key []byte) string {
+		hasher := hmac.New(sha256.New, key)
+		hasher.Write(data)
+
+		return hex.EncodeToString(hasher.Sum(nil))
+	}
+
+	// the request signed without instance can't be replayed into the instance's namespace
+	ctx := metadata.AppendToOutgoingContext(context.Background(),
+		instance.IDMetadataKey, "agent-1",
+		pb.SignatureMetadataKey, sign([]byte("secret")),
+	)
+	_, err = client.BatchUpdate(ctx, request)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(),
+		instance.IDMetadataKey, "agent-1",
+		pb.SignatureMetadataKey, sign(instance.SigningKey([]byte("secret"), "agent-1")),
+	)
+	_, err = client.BatchUpdate(ctx, request)
+	require.NoError(t, err)
+
+	stored, err := storage.Get(ctx, metric.Gauge, "agent-1/Alloc")
+	require.NoError(t, err)
+	require.Equal(t, 1.5, *stored.Value)
+}
+
+func TestGRPCServerAPI(t *testing.T) {
+	client := startTestGRPCServer(t, memorystorage.New(), &config.Config{})
+	ctx := context.Background()
+
+	updated, err := client.Update(ctx, &pb.UpdateRequest{Metric: &pb.Metric{Id: "PollCount", Type: "counter", Delta: 2}})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), updated.Metric.Delta)
+
+	updated, err = client.Update(ctx, &pb.UpdateRequest{Metric: &pb.Metric{Id: "PollCount", Type: "counter", Delta: 3}})
+	require.NoError(t, err)
+	require.Equal(t, int64(5), updated.Metric.Delta)
+
+	_, err = client.BatchUpdate(ctx, &pb.BatchUpdateRequest{Metric: []*pb.Metric{
+		{Id: "Alloc", Type: "gauge", Value: 1.5},
+		{Id: "HeapAlloc", Type: "gauge", Value: 2.5},
+		{Id: "HeapIdle", Type: "gauge", Value: 3.5},
+	}})
+	require.NoError(t, err)
+
+	_, err = client.Update(ctx, &pb.UpdateRequest{Metric: &pb.Metric{Id: "Alloc", Type: "histogram"}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.BatchUpdate(ctx, &pb.BatchUpdateRequest{Metric: []*pb.Metric{{Type: "gauge"}}})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	got, err := client.Get(ctx, &pb.GetRequest{Type: "gauge", Id: "Alloc"})
+	require.NoError(t, err)
+	require.Equal(t, 1.5, got.Metric.Value)
+
+	_, err = client.Get(ctx, &pb.GetRequest{Type: "gauge", Id: "Unknown"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.Get(ctx, &pb.GetRequest{Type: "histogram", Id: "Alloc"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	list, err := client.List(ctx, &pb.ListRequest{Type: "gauge", IdPrefix: "Heap"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"HeapAlloc", "HeapIdle"}, metricIDs(list.Metrics))
+	require.Empty(t, list.NextPageToken)
+
+	list, err = client.List(ctx, &pb.ListRequest{PageSize: 3})
+	require.NoError(t, err)
+	require.Equal(t, []string{"PollCount", "Alloc", "HeapAlloc"}, metricIDs(list.Metrics))
+	require.NotEmpty(t, list.NextPageToken)
+
+	list, err = client.List(ctx, &pb.ListRequest{PageSize: 3, PageToken: list.NextPageToken})
+	require.NoError(t, err)
+	require.Equal(t, []string{"HeapIdle"}, metricIDs(list.Metrics))
+	require.Empty(t, list.NextPageToken)
+
+	_, err = client.List(ctx, &pb.ListRequest{PageToken: "???"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.Ping(ctx, &pb.PingRequest{})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestGRPCServerBatchIdempotency(t *testing.T) {
+	storage := memorystorage.New()
+	client := startTestGRPCServer(t, storage, &config.Config{})
+
+	batch := &pb.BatchUpdateRequest{Metric: []*pb.Metric{{Id: "PollCount", Type: "counter", Delta: 2}}}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), idempotency.MetadataKey, "key-1")
+
+	for i := 0; i < 2; i++ {
+		_, err := client.BatchUpdate(ctx, batch)
+		require.NoError(t, err)
+	}
+
+	_, err := client.BatchUpdate(context.Background(), batch)
+	require.NoError(t, err)
+
+	badCtx := metadata.AppendToOutgoingContext(context.Background(), idempotency.MetadataKey, "bad key")
+	_, err = client.BatchUpdate(badCtx, batch)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	counter, ok := storage.CounterMetrics.Get("PollCount")
+	require.True(t, ok)
+	require.Equal(t, int64(4), counter)
+}
+
+func metricIDs(metrics []*pb.Metric) []string {
+	ids := make([]string, 0, len(metrics))
+	for _, m := range metrics {
+		ids = append(ids, m.Id)
+	}
+
+	return ids
+}
+
+func startTestGRPCServer(t *testing.T, storage *memorystorage.MemoryStorage, config *config.Config) pb.MetricsServiceClient {
+	_, conn := startTestGRPCServerConn(t, storage, config)
+
+	return pb.NewMetricsServiceClient(conn)
+}
+
+func startTestGRPCServerConn(
+	t *testing.T,
+	storage *memorystorage.MemoryStorage,
+	config *config.Config,
+) (*GRPCMetricServer, *grpc.ClientConn) {
+	metricsBroadcaster := broadcaster.New()
+
+	dedupeCache := dedupe.New(100, time.Minute, nil)
+
+	grpcMetricServer, err := NewGrpcServer(notifystorage.New(storage, metricsBroadcaster), nil, metricsBroadcaster, dedupeCache, config)
+	require.NoError(t, err)
+
+	listener := bufconn.Listen(1024 * 1024)
+
+	go func() {
+		_ = grpcMetricServer.Serve(listener)
+	}()
+
+	t.Cleanup(grpcMetricServer.Stop)
+
+	conn, err := grpc.Dial(
+		"bufnet",
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+	)
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return grpcMetricServer, conn
+}
+
+func writeRSAKeys(t *testing.T) (string, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	publicKeyPath := filepath.Join(dir, "public.pem")
+	privateKeyPath := filepath.Join(dir, "private.pem")
+
+	publicKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey)})
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	require.NoError(t, os.WriteFile(publicKeyPath, publicKey, 0600))
+	require.NoError(t, os.WriteFile(privateKeyPath, privateKey, 0600))
+
+	return publicKeyPath, privateKeyPath
+}
+
+func TestGRPCServerStreamUpdates(t *testing.T) {
+	storage := memorystorage.New()
+	client := startTestGRPCServer(t, storage, &config.Config{})
+	ctx := context.Background()
+
+	stream, err := client.StreamUpdates(ctx)
+	require.NoError(t, err)
+
+	count := streamBatchSize*2 + 10
+	for i := 0; i < count; i++ {
+		require.NoError(t, stream.Send(&pb.Metric{Id: "PollCount", Type: "counter", Delta: 1}))
+	}
+
+	require.NoError(t, stream.Send(&pb.Metric{Id: "Alloc", Type: "histogram"}))
+
+	summary, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+	require.Equal(t, int64(count+1), summary.Received)
+	require.Equal(t, int64(count), summary.Applied)
+	require.Equal(t, int64(1), summary.Rejected)
+
+	stored, err := storage.Get(ctx, metric.Counter, "PollCount")
+	require.NoError(t, err)
+	require.Equal(t, int64(count), *stored.Delta)
+}
+
+func TestGRPCServerStreamUpdatesWithEncryption(t *testing.T) {
+	_, privateKeyPath := writeRSAKeys(t)
+
+	client := startTestGRPCServer(t, memorystorage.New(), &config.Config{CryptoKey: privateKeyPath})
+
+	stream, err := client.StreamUpdates(context.Background())
+	require.NoError(t, err)
+
+	_, err = stream.CloseAndRecv()
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+// watch streams don't carry metrics from agents, so they aren't rejected when the server requires encryption
+func TestGRPCServerWatchWithEncryption(t *testing.T) {
+	_, privateKeyPath := writeRSAKeys(t)
+
+	client := startTestGRPCServer(t, memorystorage.New(), &config.Config{CryptoKey: privateKeyPath})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+
+	watch, err := client.Watch(ctx, &pb.WatchRequest{})
+	require.NoError(t, err)
+
+	_, err = watch.Recv()
+	require.Equal(t, codes.DeadlineExceeded, status.Code(err))
+}
+
+func TestGRPCServerWatch(t *testing.T) {
+	client := startTestGRPCServer(t, memorystorage.New(), &config.Config{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	watch, err := client.Watch(ctx, &pb.WatchRequest{NamePatterns: []string{"Heap*"}, Kinds: []string{"gauge"}})
+	require.NoError(t, err)
+
+	// the subscription is created asynchronously, so updates are sent until the first event is received
+	events := make(chan *pb.MetricEvent)
+	go func() {
+		for {
+			event, err := watch.Recv()
+			if err != nil {
+				close(events)
+				return
+			}
+
+			events <- event
+		}
+	}()
+
+	update := func() {
+		_, err := client.BatchUpdate(context.Background(), &pb.BatchUpdateRequest{Metric: []*pb.Metric{
+			{Id: "Alloc", Type: "gauge", Value: 1},
+			{Id: "HeapCount", Type: "counter", Delta: 1},
+			{Id: "HeapAlloc", Type: "gauge", Value: 2},
+		}})
+		require.NoError(t, err)
+	}
+
+	var event *pb.MetricEvent
+
+	require.Eventually(t, func() bool {
+		update()
+
+		select {
+		case event = <-events:
+			return true
+		case <-time.After(time.Millisecond * 10):
+			return false
+		}
+	}, time.Second*5, time.Millisecond)
+
+	require.Equal(t, "HeapAlloc", event.Metric.Id)
+	require.Equal(t, 2.0, event.Metric.Value)
+	require.NotNil(t, event.Time)
+}
+
+func TestGRPCServerWatchBadFilter(t *testing.T) {
+	client := startTestGRPCServer(t, memorystorage.New(), &config.Config{})
+
+	for _, req := range []*pb.WatchRequest{{NamePatterns: []string{"["}}, {Kinds: []string{"histogram"}}} {
+		watch, err := client.Watch(context.Background(), req)
+		require.NoError(t, err)
+
+		_, err = watch.Recv()
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+}
+
+// sends keepalive pings with the interval and returns true if the server closes the connection with GOAWAY
+func pingIdleConnection(t *testing.T, address string, count int, interval time.Duration) bool {
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(http2.ClientPreface))
+	require.NoError(t, err)
+
+	framer := http2.NewFramer(conn, conn)
+	require.NoError(t, framer.WriteSettings())
+
+	goAway := make(chan http2.ErrCode, 1)
+
+	go func() {
+		for {
+			frame, err := framer.ReadFrame()
+			if err != nil {
+				return
+			}
+
+			if f, ok := frame.(*http2.GoAwayFrame); ok {
+				goAway <- f.ErrCode
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < count; i++ {
+		time.Sleep(interval)
+
+		if err := framer.WritePing(false, [8]byte{byte(i)}); err != nil {
+			break
+		}
+	}
+
+	select {
+	case code := <-goAway:
+		require.Equal(t, http2.ErrCodeEnhanceYourCalm, code)
+		return true
+	case <-time.After(time.Millisecond * 500):
+		return false
+	}
+}
+
+func TestGRPCServerKeepaliveEnforcement(t *testing.T) {
+	minTime := keepaliveMinTime
+	keepaliveMinTime = time.Millisecond * 100
+	defer func() { keepaliveMinTime = minTime }()
+
+	grpcMetricServer, err := NewGrpcServer(memorystorage.New(), nil, broadcaster.New(), nil, &config.Config{})
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	go func() {
+		_ = grpcMetricServer.Serve(listener)
+	}()
+	defer grpcMetricServer.Stop()
+
+	// pings of idle connections are permitted if they aren't too frequent
+	require.False(t, pingIdleConnection(t, listener.Addr().String(), 4, keepaliveMinTime*2))
+	require.True(t, pingIdleConnection(t, listener.Addr().String(), 4, 0))
+}
+
+func TestGRPCServerHealth(t *testing.T) {
+	// health probes don't have real ip of agents
+	grpcMetricServer, conn := startTestGRPCServerConn(t, memorystorage.New(), &config.Config{TrustedSubnet: "192.168.1.0/24"})
+	client := healthpb.NewHealthClient(conn)
+
+	for _, service := range []string{"", pb.MetricsService_ServiceDesc.ServiceName} {
+		resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		require.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+	}
+
+	grpcMetricServer.health.shutdown()
+
+	resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status)
+
+	_, err = pb.NewMetricsServiceClient(conn).Ping(context.Background(), &pb.PingRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestGRPCServerReflection(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		_, conn := startTestGRPCServerConn(t, memorystorage.New(), &config.Config{GRPCReflection: enabled})
+
+		stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+		require.NoError(t, err)
+
+		require.NoError(t, stream.Send(&reflectionpb.ServerReflectionRequest{
+			MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+		}))
+
+		resp, err := stream.Recv()
+		if !enabled {
+			require.Equal(t, codes.Unimplemented, status.Code(err))
+			continue
+		}
+
+		require.NoError(t, err)
+
+		services := make([]string, 0)
+		for _, service := range resp.GetListServicesResponse().Service {
+			services = append(services, service.Name)
+		}
+
+		require.Contains(t, services, pb.MetricsService_ServiceDesc.ServiceName)
+		require.Contains(t, services, healthpb.Health_ServiceDesc.ServiceName)
+	}
+}
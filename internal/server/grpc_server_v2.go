@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/kuzhukin/metrics-collector/internal/instance"
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+	pbv2 "github.com/kuzhukin/metrics-collector/internal/proto/v2"
+	"github.com/kuzhukin/metrics-collector/internal/server/dedupe"
+	"github.com/kuzhukin/metrics-collector/internal/server/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ pbv2.MetricsServiceServer = &grpcMetricServerV2{}
+
+const (
+	maxLabels            = 32
+	maxDescriptionLength = 1024
+
+	labelsBegin     = "{"
+	labelsEnd       = "}"
+	labelsSeparator = ","
+	labelValueSep   = "="
+)
+
+var labelNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// grpcMetricServerV2 - metric.v2 API over the same storage as v1 API,
+// labels are folded into stored ids, so v1 clients see labeled metrics as id{name=value,...}
+type grpcMetricServerV2 struct {
+	pbv2.UnimplementedMetricsServiceServer
+	storage storage.Storage
+	// keys of applied batches, batches aren't deduplicated if it's nil
+	dedupe *dedupe.Cache
+}
+
+func (s *grpcMetricServerV2) BatchUpdate(ctx context.Context, req *pbv2.BatchUpdateRequest) (*pbv2.BatchUpdateResponse, error) {
+	inst, err := grpcInstance(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := make([]*metric.Metric, 0, len(req.Metrics))
+
+	for i, m := range req.Metrics {
+		converted, err := fromPbV2Metric(inst, m)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "metric #%d: %s", i, status.Convert(err).Message())
+		}
+
+		metrics = append(metrics, converted)
+	}
+
+	if err := batchUpdateOnce(ctx, s.storage, s.dedupe, inst, metrics); err != nil {
+		return nil, err
+	}
+
+	return &pbv2.BatchUpdateResponse{}, nil
+}
+
+func (s *grpcMetricServerV2) Update(ctx context.Context, req *pbv2.UpdateRequest) (*pbv2.UpdateResponse, error) {
+	inst, err := grpcInstance(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := fromPbV2Metric(inst, req.Metric)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.storage.Update(ctx, m); err != nil {
+		return nil, storageError(fmt.Errorf("update err=%w", err))
+	}
+
+	// counters are summed in the storage, so the actual state is returned
+	updated, err := s.storage.Get(ctx, m.Type, m.ID)
+	if err != nil {
+		return nil, storageError(fmt.Errorf("get updated metric err=%w", err))
+	}
+
+	return &pbv2.UpdateResponse{Metric: toPbV2Metric(req.Metric.Id, req.Metric.Labels, updated)}, nil
+}
+
+func (s *grpcMetricServerV2) Get(ctx context.Context, req *pbv2.GetRequest) (*pbv2.GetResponse, error) {
+	inst, err := grpcInstance(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	kind, ok := fromPbV2Kind(req.Kind)
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown kind=%s", req.Kind)
+	}
+
+	if err := validateV2Identity(req.Id, req.Labels); err != nil {
+		return nil, err
+	}
+
+	m, err := s.storage.Get(ctx, kind, instance.Namespace(inst, seriesID(req.Id, req.Labels)))
+	if err != nil {
+		return nil, storageError(fmt.Errorf("get err=%w", err))
+	}
+
+	return &pbv2.GetResponse{Metric: toPbV2Metric(req.Id, req.Labels, m)}, nil
+}
+
+// validates metric from request and converts it, the value must match the kind
+func fromPbV2Metric(inst string, m *pbv2.Metric) (*metric.Metric, error) {
+	if m == nil {
+		return nil, status.Error(codes.InvalidArgument, "metric is empty")
+	}
+
+	if err := validateV2Identity(m.Id, m.Labels); err != nil {
+		return nil, err
+	}
+
+	if len(m.Description) > maxDescriptionLength {
+		return nil, status.Errorf(codes.InvalidArgument, "metric id=%s has description longer than %d", m.Id, maxDescriptionLength)
+	}
+
+	if m.Time != nil {
+		if err := m.Time.CheckValid(); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "metric id=%s has bad time: %s", m.Id, err)
+		}
+	}
+
+	converted := &metric.Metric{ID: instance.Namespace(inst, seriesID(m.Id, m.Labels))}
+
+	switch value := m.Value.(type) {
+	case *pbv2.Metric_Gauge:
+		if m.Kind != pbv2.Kind_KIND_GAUGE {
+			return nil, status.Errorf(codes.InvalidArgument, "metric id=%s of kind=%s has gauge value", m.Id, m.Kind)
+		}
+
+		if math.IsNaN(value.Gauge) || math.IsInf(value.Gauge, 0) {
+			return nil, status.Errorf(codes.InvalidArgument, "metric id=%s has not finite value", m.Id)
+		}
+
+		gauge := value.Gauge
+		converted.Type = metric.Gauge
+		converted.Value = &gauge
+	case *pbv2.Metric_Delta:
+		if m.Kind != pbv2.Kind_KIND_COUNTER {
+			return nil, status.Errorf(codes.InvalidArgument, "metric id=%s of kind=%s has counter delta", m.Id, m.Kind)
+		}
+
+		delta := value.Delta
+		converted.Type = metric.Counter
+		converted.Delta = &delta
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "metric id=%s has no value", m.Id)
+	}
+
+	return converted, nil
+}
+
+// id and labels are passed without instance namespace
+func toPbV2Metric(id string, labels map[string]string, m *metric.Metric) *pbv2.Metric {
+	converted := &pbv2.Metric{Id: id, Labels: labels}
+
+	switch m.Type {
+	case metric.Gauge:
+		converted.Kind = pbv2.Kind_KIND_GAUGE
+		converted.Value = &pbv2.Metric_Gauge{Gauge: *m.Value}
+	case metric.Counter:
+		converted.Kind = pbv2.Kind_KIND_COUNTER
+		converted.Value = &pbv2.Metric_Delta{Delta: *m.Delta}
+	}
+
+	return converted
+}
+
+func fromPbV2Kind(kind pbv2.Kind) (metric.Kind, bool) {
+	switch kind {
+	case pbv2.Kind_KIND_GAUGE:
+		return metric.Gauge, true
+	case pbv2.Kind_KIND_COUNTER:
+		return metric.Counter, true
+	default:
+		return "", false
+	}
+}
+
+// characters of the series id format aren't allowed in ids and labels, so the series id is unambiguous
+func validateV2Identity(id string, labels map[string]string) error {
+	if id == "" {
+		return status.Error(codes.InvalidArgument, "metric id is empty")
+	}
+
+	if strings.ContainsAny(id, labelsBegin+labelsEnd) {
+		return status.Errorf(codes.InvalidArgument, "metric id=%s contains %q or %q", id, labelsBegin, labelsEnd)
+	}
+
+	if err := instance.ValidateMetricID(id); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if len(labels) > maxLabels {
+		return status.Errorf(codes.InvalidArgument, "metric id=%s has more than %d labels", id, maxLabels)
+	}
+
+	for name, value := range labels {
+		if !labelNameRegexp.MatchString(name) {
+			return status.Errorf(codes.InvalidArgument, "metric id=%s has bad label name=%q", id, name)
+		}
+
+		if value == "" || strings.ContainsAny(value, labelsBegin+labelsEnd+labelsSeparator) {
+			return status.Errorf(codes.InvalidArgument, "metric id=%s has bad value of label=%s", id, name)
+		}
+	}
+
+	return nil
+}
+
+// returns stored id of the metric with labels, it's the same as id for metrics without labels
+func seriesID(id string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return id
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+labelValueSep+labels[name])
+	}
+
+	return id + labelsBegin + strings.Join(pairs, labelsSeparator) + labelsEnd
+}
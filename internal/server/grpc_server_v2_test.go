@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	pb "github.com/kuzhukin/metrics-collector/internal/proto"
+	pbv2 "github.com/kuzhukin/metrics-collector/internal/proto/v2"
+	"github.com/kuzhukin/metrics-collector/internal/server/config"
+	"github.com/kuzhukin/metrics-collector/internal/server/storage/memorystorage"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestGRPCServerV2(t *testing.T) {
+	storage := memorystorage.New()
+	_, conn := startTestGRPCServerConn(t, storage, &config.Config{})
+
+	client := pbv2.NewMetricsServiceClient(conn)
+	clientV1 := pb.NewMetricsServiceClient(conn)
+	ctx := context.Background()
+
+	labels := map[string]string{"method": "GET", "code": "200"}
+
+	updated, err := client.Update(ctx, &pbv2.UpdateRequest{Metric: &pbv2.Metric{
+		Id:          "requests",
+		Kind:        pbv2.Kind_KIND_COUNTER,
+		Value:       &pbv2.Metric_Delta{Delta: 2},
+		Labels:      labels,
+		Time:        timestamppb.Now(),
+		Description: "count of handled requests",
+	}})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), updated.Metric.GetDelta())
+	require.Equal(t, labels, updated.Metric.Labels)
+
+	_, err = client.BatchUpdate(ctx, &pbv2.BatchUpdateRequest{Metrics: []*pbv2.Metric{
+		{Id: "requests", Kind: pbv2.Kind_KIND_COUNTER, Value: &pbv2.Metric_Delta{Delta: 3}, Labels: labels},
+		{Id: "Alloc", Kind: pbv2.Kind_KIND_GAUGE, Value: &pbv2.Metric_Gauge{Gauge: 1.5}},
+	}})
+	require.NoError(t, err)
+
+	got, err := client.Get(ctx, &pbv2.GetRequest{Kind: pbv2.Kind_KIND_COUNTER, Id: "requests", Labels: labels})
+	require.NoError(t, err)
+	require.Equal(t, int64(5), got.Metric.GetDelta())
+
+	_, err = client.Get(ctx, &pbv2.GetRequest{Kind: pbv2.Kind_KIND_COUNTER, Id: "requests"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// labeled metrics are visible for v1 clients by series id
+	gotV1, err := clientV1.Get(ctx, &pb.GetRequest{Type: "counter", Id: "requests{code=200,method=GET}"})
+	require.NoError(t, err)
+	require.Equal(t, int64(5), gotV1.Metric.Delta)
+
+	gotV2, err := client.Get(ctx, &pbv2.GetRequest{Kind: pbv2.Kind_KIND_GAUGE, Id: "Alloc"})
+	require.NoError(t, err)
+	require.Equal(t, 1.5, gotV2.Metric.GetGauge())
+	require.Nil(t, gotV2.Metric.Labels)
+
+	invalid := []*pbv2.Metric{
+		nil,
+		{Kind: pbv2.Kind_KIND_GAUGE, Value: &pbv2.Metric_Gauge{Gauge: 1}},
+		{Id: "Alloc", Kind: pbv2.Kind_KIND_GAUGE},
+		{Id: "Alloc", Kind: pbv2.Kind_KIND_UNSPECIFIED, Value: &pbv2.Metric_Gauge{Gauge: 1}},
+		{Id: "Alloc", Kind: pbv2.Kind_KIND_COUNTER, Value: &pbv2.Metric_Gauge{Gauge: 1}},
+		{Id: "Alloc", Kind: pbv2.Kind_KIND_GAUGE, Value: &pbv2.Metric_Delta{Delta: 1}},
+		{Id: "Alloc", Kind: pbv2.Kind_KIND_GAUGE, Value: &pbv2.Metric_Gauge{Gauge: math.NaN()}},
+		{Id: "Alloc{}", Kind: pbv2.Kind_KIND_GAUGE, Value: &pbv2.Metric_Gauge{Gauge: 1}},
+		{Id: "Alloc", Kind: pbv2.Kind_KIND_GAUGE, Value: &pbv2.Metric_Gauge{Gauge: 1}, Labels: map[string]string{"1a": "b"}},
+		{Id: "Alloc", Kind: pbv2.Kind_KIND_GAUGE, Value: &pbv2.Metric_Gauge{Gauge: 1}, Labels: map[string]string{"a": "b,c"}},
+		{Id: "Alloc", Kind: pbv2.Kind_KIND_GAUGE, Value: &pbv2.Metric_Gauge{Gauge: 1}, Time: &timestamppb.Timestamp{Nanos: -1}},
+	}
+
+	for _, m := range invalid {
+		_, err = client.Update(ctx, &pbv2.UpdateRequest{Metric: m})
+		require.Equal(t, codes.InvalidArgument, status.Code(err), "metric=%v", m)
+
+		_, err = client.BatchUpdate(ctx, &pbv2.BatchUpdateRequest{Metrics: []*pbv2.Metric{m}})
+		require.Equal(t, codes.InvalidArgument, status.Code(err), "metric=%v", m)
+	}
+
+	_, err = client.Get(ctx, &pbv2.GetRequest{Id: "Alloc"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestFromPbMetricKeepsSingleValue(t *testing.T) {
+	// v1 metrics always contain both fields, only the field of the type is converted
+	gauge, err := fromPbMetric("", &pb.Metric{Id: "Alloc", Type: "gauge", Value: 1.5, Delta: 10})
+	require.NoError(t, err)
+	require.Nil(t, gauge.Delta)
+	require.Equal(t, 1.5, *gauge.Value)
+
+	counter, err := fromPbMetric("", &pb.Metric{Id: "PollCount", Type: "counter", Value: 1.5, Delta: 10})
+	require.NoError(t, err)
+	require.Nil(t, counter.Value)
+	require.Equal(t, int64(10), *counter.Delta)
+}
+
+func TestFromPbMetricRejectsNamespacedID(t *testing.T) {
+	_, err := fromPbMetric("", &pb.Metric{Id: "hostA/Alloc", Type: "gauge", Value: 1.5})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = fromPbV2Metric("", &pbv2.Metric{Id: "hostA/Alloc", Kind: pbv2.Kind_KIND_GAUGE})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/kuzhukin/metrics-collector/internal/server/backup"
+	"github.com/kuzhukin/metrics-collector/internal/server/endpoint"
+	"github.com/kuzhukin/metrics-collector/internal/server/storage"
+	"github.com/kuzhukin/metrics-collector/internal/zlog"
+)
+
+var _ http.Handler = &BackupHandler{}
+var _ http.Handler = &RestoreHandler{}
+
+// max size of the restored archive
+const restoreMaxBodySize = 256 * 1024 * 1024
+
+// HTTP handler for downloading archive with all metrics of the storage
+// GET /api/v1/backup
+type BackupHandler struct {
+	storage storage.Storage
+	// name of the storage backend which is written to the manifest
+	backend string
+}
+
+func NewBackupHandler(storage storage.Storage, backend string) *BackupHandler {
+	return &BackupHandler{
+		storage: storage,
+		backend: backend,
+	}
+}
+
+func (u *BackupHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		zlog.Logger.Infof("Endpoint %s supports only GET method", endpoint.BackupEndpoint)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	metrics, err := u.storage.List(r.Context())
+	if err != nil {
+		zlog.Logger.Errorf("storage list err=%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	createdAt := time.Now().UTC()
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="metrics-`+createdAt.Format("20060102T150405Z")+`.tar.gz"`)
+	w.WriteHeader(http.StatusOK)
+
+	if err := backup.Write(w, backup.Manifest{CreatedAt: createdAt, Backend: u.backend}, metrics); err != nil {
+		zlog.Logger.Warnf("Write backup, err=%s", err)
+		return
+	}
+
+	zlog.Logger.Infof("Backup is made metrics=%d", len(metrics))
+}
+
+// HTTP handler for restoring metrics from the archive made by the backup handler
+// POST /api/v1/restore?mode={merge|replace}
+type RestoreHandler struct {
+	storage storage.Storage
+}
+
+func NewRestoreHandler(storage storage.Storage) *RestoreHandler {
+	return &RestoreHandler{
+		storage: storage,
+	}
+}
+
+// RestoreResponse - manifest of the restored archive and count of restored metrics
+type RestoreResponse struct {
+	Mode     storage.RestoreMode `json:"mode"`
+	Restored int                 `json:"restored"`
+	Backup   *backup.Manifest    `json:"backup"`
+}
+
+// ServeHTTP - the archive is read and checked completely before changing of the storage
+func (u *RestoreHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		zlog.Logger.Infof("Endpoint %s supports only POST method", endpoint.RestoreEndpoint)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	mode := storage.RestoreMode(r.URL.Query().Get("mode"))
+	if mode == "" {
+		mode = storage.RestoreMerge
+	}
+
+	if mode != storage.RestoreMerge && mode != storage.RestoreReplace {
+		http.Error(w, "bad mode="+string(mode), http.StatusBadRequest)
+		return
+	}
+
+	manifest, metrics, err := backup.Read(http.MaxBytesReader(w, r.Body, restoreMaxBodySize))
+	if err != nil {
+		zlog.Logger.Warnf("Read backup, err=%s", err)
+
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, "body is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	if err := u.storage.Restore(r.Context(), metrics, mode); err != nil {
+		zlog.Logger.Errorf("storage restore mode=%s, err=%s", mode, err)
+		w.WriteHeader(storageErrorStatus(err))
+
+		return
+	}
+
+	zlog.Logger.Infof("Backup created_at=%s, backend=%s is restored mode=%s, metrics=%d", manifest.CreatedAt, manifest.Backend, mode, len(metrics))
+
+	data, err := json.Marshal(RestoreResponse{Mode: mode, Restored: len(metrics), Backup: manifest})
+	if err != nil {
+		zlog.Logger.Errorf("Marshal restore response, err=%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := w.Write(data); err != nil {
+		zlog.Logger.Errorf("Write data to response, err=%s", err)
+	}
+}
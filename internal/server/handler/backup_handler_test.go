@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kuzhukin/metrics-collector/internal/server/storage"
+	"github.com/kuzhukin/metrics-collector/internal/server/storage/memorystorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupRestore(t *testing.T) {
+	source := memorystorage.New()
+	source.GaugeMetrics.Write("Alloc", 1.5)
+	source.CounterMetrics.Sum("PollCount", 10)
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/backup", nil)
+	w := httptest.NewRecorder()
+	NewBackupHandler(source, "memory").ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/gzip", w.Header().Get("Content-Type"))
+
+	archive := w.Body.Bytes()
+
+	target := memorystorage.New()
+	target.GaugeMetrics.Write("HeapIdle", 2)
+	target.CounterMetrics.Sum("PollCount", 5)
+
+	restore := func(target string, body []byte, s storage.Storage) (int, RestoreResponse) {
+		r := httptest.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		NewRestoreHandler(s).ServeHTTP(w, r)
+
+		resp := RestoreResponse{}
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		}
+
+		return w.Code, resp
+	}
+
+	code, resp := restore("/api/v1/restore", archive, target)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, storage.RestoreMerge, resp.Mode)
+	require.Equal(t, 2, resp.Restored)
+	require.Equal(t, "memory", resp.Backup.Backend)
+	require.Equal(t, map[string]float64{"Alloc": 1.5, "HeapIdle": 2}, target.GaugeMetrics.GetAll())
+	require.Equal(t, map[string]int64{"PollCount": 10}, target.CounterMetrics.GetAll())
+
+	code, _ = restore("/api/v1/restore?mode=replace", archive, target)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, map[string]float64{"Alloc": 1.5}, target.GaugeMetrics.GetAll())
+
+	code, _ = restore("/api/v1/restore?mode=append", archive, target)
+	require.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = restore("/api/v1/restore?mode=replace", archive[:len(archive)-10], target)
+	require.Equal(t, http.StatusBadRequest, code)
+	require.Equal(t, map[string]float64{"Alloc": 1.5}, target.GaugeMetrics.GetAll())
+}
@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/kuzhukin/metrics-collector/internal/instance"
+	"github.com/kuzhukin/metrics-collector/internal/server/endpoint"
+	"github.com/kuzhukin/metrics-collector/internal/server/parser"
+	"github.com/kuzhukin/metrics-collector/internal/server/storage"
+	"github.com/kuzhukin/metrics-collector/internal/zlog"
+)
+
+var _ http.Handler = &DeleteHandler{}
+var _ http.Handler = &DeleteMatchingHandler{}
+
+// HTTP handler for deleting single metric
+// DELETE /value/{kind}/{name}
+type DeleteHandler struct {
+	storage storage.Storage
+	parser  parser.RequestParser
+}
+
+func NewDeleteHandler(storage storage.Storage, parser parser.RequestParser) *DeleteHandler {
+	return &DeleteHandler{
+		storage: storage,
+		parser:  parser,
+	}
+}
+
+func (u *DeleteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		zlog.Logger.Infof("Endpoint %s supports only DELETE method", endpoint.ValueEndpoint)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	metric, err := u.parser.Parse(r)
+	if err != nil {
+		zlog.Logger.Warnf("Parse request path=%s, err=%s", r.URL.Path, err)
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	inst, err := requestInstance(r)
+	if err != nil {
+		zlog.Logger.Warnf("Bad instance path=%s, err=%s", r.URL.Path, err)
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	if err := u.storage.Delete(r.Context(), metric.Type, instance.Namespace(inst, metric.ID)); err != nil {
+		zlog.Logger.Warnf("storage delete kind=%s, name=%s, instance=%s err=%s", metric.Type, metric.ID, inst, err)
+		w.WriteHeader(storageErrorStatus(err))
+
+		return
+	}
+
+	zlog.Logger.Infof("Metric kind=%s, name=%s, instance=%s is deleted", metric.Type, metric.ID, inst)
+	w.WriteHeader(http.StatusOK)
+}
+
+// HTTP handler for deleting metrics by pattern, at least one filter is required
+// DELETE /api/v1/metrics?match={glob}&regex={regexp}&kind={kind}
+type DeleteMatchingHandler struct {
+	storage storage.Storage
+}
+
+func NewDeleteMatchingHandler(storage storage.Storage) *DeleteMatchingHandler {
+	return &DeleteMatchingHandler{
+		storage: storage,
+	}
+}
+
+// DeleteMatchingResponse - count of deleted metrics
+type DeleteMatchingResponse struct {
+	Deleted int `json:"deleted"`
+}
+
+func (u *DeleteMatchingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		zlog.Logger.Infof("Endpoint %s supports only DELETE method", endpoint.MetricsQueryEndpoint)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	inst, err := requestInstance(r)
+	if err != nil {
+		zlog.Logger.Warnf("Bad instance path=%s, err=%s", r.URL.Path, err)
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	query, err := parseQuery(r, inst)
+	if err != nil {
+		zlog.Logger.Warnf("Parse query=%s, err=%s", r.URL.RawQuery, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	// all metrics are deleted only by explicit match=*
+	if query.Match == "" && query.Regexp == "" && query.Kind == "" {
+		http.Error(w, "match, regex or kind is required", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := u.storage.DeleteMatching(r.Context(), query)
+	if err != nil {
+		zlog.Logger.Errorf("storage delete query=%+v, err=%s", query, err)
+		w.WriteHeader(storageErrorStatus(err))
+
+		return
+	}
+
+	zlog.Logger.Infof("Metrics query=%s, instance=%s, deleted=%d", r.URL.RawQuery, inst, deleted)
+
+	data, err := json.Marshal(DeleteMatchingResponse{Deleted: deleted})
+	if err != nil {
+		zlog.Logger.Errorf("Marshal delete response, err=%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := w.Write(data); err != nil {
+		zlog.Logger.Errorf("Write data to response, err=%s", err)
+	}
+}
+
+// maps storage errors to HTTP statuses
+func storageErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrUnknownMetric):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrUnknownKind), errors.Is(err, storage.ErrBadQuery), errors.Is(err, storage.ErrBadMetric):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/kuzhukin/metrics-collector/internal/instance"
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+	"github.com/kuzhukin/metrics-collector/internal/server/endpoint"
+	"github.com/kuzhukin/metrics-collector/internal/server/exchange"
+	"github.com/kuzhukin/metrics-collector/internal/server/storage"
+	"github.com/kuzhukin/metrics-collector/internal/zlog"
+)
+
+var _ http.Handler = &ExportHandler{}
+
+// count of metrics which are read from the storage at once
+const exportPageSize = 1000
+
+// HTTP handler for exporting all metrics
+// GET /api/v1/export?format={csv|ndjson}
+type ExportHandler struct {
+	storage storage.Storage
+}
+
+func NewExportHandler(storage storage.Storage) *ExportHandler {
+	return &ExportHandler{
+		storage: storage,
+	}
+}
+
+// ServeHTTP - streams metrics page by page, so the whole storage isn't kept in memory
+func (u *ExportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		zlog.Logger.Infof("Endpoint %s supports only GET method", endpoint.ExportEndpoint)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	format, err := exchange.ParseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	inst, err := requestInstance(r)
+	if err != nil {
+		zlog.Logger.Warnf("Bad instance path=%s, err=%s", r.URL.Path, err)
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	query := storage.Query{Prefix: instance.Namespace(inst, ""), Limit: exportPageSize}
+
+	// the first page is read before writing of the header, so storage errors are returned with the status
+	page, err := u.storage.Query(r.Context(), query)
+	if err != nil {
+		zlog.Logger.Errorf("storage query=%+v, err=%s", query, err)
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	encoder, err := exchange.NewEncoder(format, w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", `attachment; filename="metrics.`+string(format)+`"`)
+	w.WriteHeader(http.StatusOK)
+
+	for len(page) > 0 {
+		// the cursor is the stored id, so it's taken before stripping of the namespace
+		last := page[len(page)-1]
+		query.After = &metric.Metric{ID: last.ID, Type: last.Type}
+
+		for _, m := range filterByInstance(inst, page) {
+			if err := encoder.Encode(m); err != nil {
+				zlog.Logger.Warnf("Encode metric=%s, err=%s", m.ID, err)
+				return
+			}
+		}
+
+		if len(page) < exportPageSize {
+			break
+		}
+
+		page, err = u.storage.Query(r.Context(), query)
+		if err != nil {
+			// the status is already sent, so the connection is aborted for showing the incomplete export
+			zlog.Logger.Errorf("storage query=%+v, err=%s", query, err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+
+	if err := encoder.Flush(); err != nil {
+		zlog.Logger.Warnf("Flush export, err=%s", err)
+	}
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kuzhukin/metrics-collector/internal/instance"
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+	"github.com/kuzhukin/metrics-collector/internal/server/storage/memorystorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportHandler(t *testing.T) {
+	s := memorystorage.New()
+	s.GaugeMetrics.Write("Alloc", 1.5)
+	s.CounterMetrics.Sum("PollCount", 3)
+	s.GaugeMetrics.Write("host-1/Alloc", 2)
+
+	handler := NewExportHandler(s)
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/export", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	require.Equal(t, "id,type,value\nAlloc,gauge,1.5\nPollCount,counter,3\nhost-1/Alloc,gauge,2\n", w.Body.String())
+
+	r = httptest.NewRequest(http.MethodGet, "/api/v1/export?format=ndjson", nil)
+	r.Header.Set(instance.IDHeader, "host-1")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	require.JSONEq(t, `{"id":"Alloc","type":"gauge","value":2}`, strings.TrimSpace(w.Body.String()))
+
+	r = httptest.NewRequest(http.MethodGet, "/api/v1/export?format=xml", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestExportHandlerPages(t *testing.T) {
+	s := memorystorage.New()
+
+	count := exportPageSize*2 + 1
+	for i := 0; i < count; i++ {
+		s.CounterMetrics.Sum(fmt.Sprintf("counter-%05d", i), int64(i))
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/export?format=ndjson", nil)
+	w := httptest.NewRecorder()
+	NewExportHandler(s).ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusOK, w.Code)
+
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	require.Len(t, lines, count)
+
+	for i, line := range lines {
+		m := &metric.Metric{}
+		require.NoError(t, json.Unmarshal([]byte(line), m))
+		require.Equal(t, fmt.Sprintf("counter-%05d", i), m.ID)
+	}
+}
+
+func TestImportHandler(t *testing.T) {
+	s := memorystorage.New()
+	s.CounterMetrics.Sum("PollCount", 1)
+
+	handler := NewImportHandler(s)
+
+	post := func(target string, body string) (int, ImportReport) {
+		r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		r.Header.Set(instance.IDHeader, "host-1")
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		report := ImportReport{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+
+		return w.Code, report
+	}
+
+	valid := "id,type,value\nAlloc,gauge,1.5\nPollCount,counter,2\n"
+
+	code, report := post("/api/v1/import?dry_run=true", valid)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, ImportReport{DryRun: true, Rows: 2}, report)
+	require.Empty(t, s.GaugeMetrics.GetAll())
+
+	code, report = post("/api/v1/import", "Alloc,gauge,1\nAlloc,gauge,x\nPollCount,unknown,1\n")
+	require.Equal(t, http.StatusBadRequest, code)
+	require.Equal(t, 3, report.Rows)
+	require.Equal(t, 2, report.Invalid)
+	require.Equal(t, 0, report.Applied)
+	require.Equal(t, []int{2, 3}, []int{report.Errors[0].Row, report.Errors[1].Row})
+	require.Empty(t, s.GaugeMetrics.GetAll())
+
+	code, report = post("/api/v1/import", valid)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, ImportReport{Rows: 2, Applied: 2}, report)
+
+	m, err := s.Get(context.Background(), metric.Gauge, instance.Namespace("host-1", "Alloc"))
+	require.NoError(t, err)
+	require.Equal(t, 1.5, *m.Value)
+
+	m, err = s.Get(context.Background(), metric.Counter, instance.Namespace("host-1", "PollCount"))
+	require.NoError(t, err)
+	require.Equal(t, int64(2), *m.Delta)
+
+	code, report = post("/api/v1/import?format=ndjson", `{"id":"PollCount","type":"counter","delta":3}`)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, 1, report.Applied)
+
+	m, err = s.Get(context.Background(), metric.Counter, instance.Namespace("host-1", "PollCount"))
+	require.NoError(t, err)
+	require.Equal(t, int64(5), *m.Delta)
+}
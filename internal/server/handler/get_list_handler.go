@@ -1,25 +1,33 @@
 package handler
 
 import (
+	"bytes"
 	"net/http"
 
-	"github.com/kuzhukin/metrics-collector/internal/server/codec"
+	"github.com/kuzhukin/metrics-collector/internal/instance"
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+	"github.com/kuzhukin/metrics-collector/internal/server/dashboard"
 	"github.com/kuzhukin/metrics-collector/internal/server/endpoint"
+	"github.com/kuzhukin/metrics-collector/internal/server/history"
 	"github.com/kuzhukin/metrics-collector/internal/server/storage"
 	"github.com/kuzhukin/metrics-collector/internal/zlog"
 )
 
 var _ http.Handler = &GetListHandler{}
 
-// HTTP handler for getting all metrics in HTML format
+// HTTP handler for getting all metrics in HTML dashboard
 // GET /
+// GET /?instance={instance} - only metrics of the instance
 type GetListHandler struct {
 	storage storage.Storage
+	// recent updates for the dashboard, it's optional
+	history *history.Recorder
 }
 
-func NewGetListHandler(storage storage.Storage) *GetListHandler {
+func NewGetListHandler(storage storage.Storage, history *history.Recorder) *GetListHandler {
 	return &GetListHandler{
 		storage: storage,
+		history: history,
 	}
 }
 
@@ -33,17 +41,57 @@ func (u *GetListHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "text/html")
 
+	inst, err := requestInstance(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
 	metrics, err := u.storage.List(r.Context())
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	metrics = filterByInstance(inst, metrics)
+
+	var historyOf dashboard.HistoryFunc
+	if u.history != nil {
+		// the history is recorded by ids in the instance's namespace
+		historyOf = func(kind metric.Kind, id string) []history.Point {
+			return u.history.Points(kind, instance.Namespace(inst, id))
+		}
+	}
+
+	var page bytes.Buffer
+	if err := dashboard.Render(&page, dashboard.NewPage(metrics, historyOf)); err != nil {
+		zlog.Logger.Errorf("Render dashboard, err=%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
 
-	decoded := codec.DecodeMetricsList(metrics)
-	_, err = w.Write([]byte(decoded))
+	_, err = w.Write(page.Bytes())
 	if err != nil {
 		zlog.Logger.Errorf("Write data to response, err=%s", err)
 	}
 }
+
+func filterByInstance(inst string, metrics []*metric.Metric) []*metric.Metric {
+	if inst == "" {
+		return metrics
+	}
+
+	filtered := make([]*metric.Metric, 0, len(metrics))
+
+	for _, m := range metrics {
+		if id, ok := instance.Strip(inst, m.ID); ok {
+			m.ID = id
+			filtered = append(filtered, m)
+		}
+	}
+
+	return filtered
+}
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/kuzhukin/metrics-collector/internal/metric"
+	"github.com/kuzhukin/metrics-collector/internal/server/history"
 	"github.com/kuzhukin/metrics-collector/internal/server/storage/mockstorage"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -13,7 +14,7 @@ import (
 
 func TestGetList(t *testing.T) {
 	mockStorage := mockstorage.NewStorage(t)
-	handler := NewGetListHandler(mockStorage)
+	handler := NewGetListHandler(mockStorage, nil)
 
 	r := httptest.NewRequest(http.MethodGet, fakeURLPath, nil)
 	w := httptest.NewRecorder()
@@ -26,3 +27,51 @@ func TestGetList(t *testing.T) {
 	require.Equal(t, http.StatusOK, w.Code)
 	require.Equal(t, "text/html", w.Header().Get("Content-Type"))
 }
+
+func TestGetListFilterByInstance(t *testing.T) {
+	mockStorage := mockstorage.NewStorage(t)
+	handler := NewGetListHandler(mockStorage, nil)
+
+	r := httptest.NewRequest(http.MethodGet, fakeURLPath+"?instance=host-1", nil)
+	w := httptest.NewRecorder()
+
+	value1 := 1.1
+	value2 := 2.2
+	mockStorage.On("List", mock.Anything).Return([]*metric.Metric{
+		{ID: "host-1/Alloc", Type: metric.Gauge, Value: &value1},
+		{ID: "host-2/Alloc", Type: metric.Gauge, Value: &value2},
+	}, nil)
+
+	handler.ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `<td class="name">Alloc</td>`)
+	require.Contains(t, w.Body.String(), `<td class="value">1.1</td>`)
+	require.NotContains(t, w.Body.String(), "2.2")
+}
+
+func TestGetListEscapesIDs(t *testing.T) {
+	mockStorage := mockstorage.NewStorage(t)
+	recorder := history.New(10, 10)
+	handler := NewGetListHandler(mockStorage, recorder)
+
+	r := httptest.NewRequest(http.MethodGet, fakeURLPath+"?instance=host-1", nil)
+	w := httptest.NewRecorder()
+
+	id := `<script>alert("x")</script>`
+	value1 := 1.1
+	value2 := 2.2
+	recorder.Publish(
+		&metric.Metric{ID: "host-1/" + id, Type: metric.Gauge, Value: &value1},
+		&metric.Metric{ID: "host-1/" + id, Type: metric.Gauge, Value: &value2},
+	)
+
+	mockStorage.On("List", mock.Anything).Return([]*metric.Metric{{ID: "host-1/" + id, Type: metric.Gauge, Value: &value2}}, nil)
+
+	handler.ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NotContains(t, w.Body.String(), id)
+	require.Contains(t, w.Body.String(), "&lt;script&gt;")
+	require.Contains(t, w.Body.String(), `<polyline points="0.00,20.00 100.00,0.00" />`)
+}
@@ -0,0 +1,181 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+	"github.com/kuzhukin/metrics-collector/internal/server/endpoint"
+	"github.com/kuzhukin/metrics-collector/internal/server/exchange"
+	"github.com/kuzhukin/metrics-collector/internal/server/storage"
+	"github.com/kuzhukin/metrics-collector/internal/zlog"
+)
+
+var _ http.Handler = &ImportHandler{}
+
+const (
+	// max size of the imported body
+	importMaxBodySize = 32 * 1024 * 1024
+	// count of metrics which are written to the storage at once
+	importBatchSize = 1000
+	// max count of row errors in the report
+	importMaxErrors = 100
+)
+
+// HTTP handler for importing metrics, counters are added to stored values like in updates
+// POST /api/v1/import?format={csv|ndjson}&dry_run={true|false}
+type ImportHandler struct {
+	storage storage.Storage
+}
+
+func NewImportHandler(storage storage.Storage) *ImportHandler {
+	return &ImportHandler{
+		storage: storage,
+	}
+}
+
+// ImportReport - result of the import, nothing is written if there are invalid rows
+type ImportReport struct {
+	DryRun bool `json:"dry_run"`
+	// count of rows with metrics
+	Rows int `json:"rows"`
+	// count of invalid rows
+	Invalid int `json:"invalid"`
+	// count of metrics written to the storage
+	Applied int `json:"applied"`
+	// the first errors of rows
+	Errors []RowError `json:"errors,omitempty"`
+}
+
+type RowError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// ServeHTTP - validates all rows before writing, so the import is rejected entirely if any row is invalid
+func (u *ImportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		zlog.Logger.Infof("Endpoint %s supports only POST method", endpoint.ImportEndpoint)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	params := r.URL.Query()
+
+	format, err := exchange.ParseFormat(params.Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report := ImportReport{}
+
+	if dryRun := params.Get("dry_run"); dryRun != "" {
+		report.DryRun, err = strconv.ParseBool(dryRun)
+		if err != nil {
+			http.Error(w, "bad dry_run="+dryRun, http.StatusBadRequest)
+			return
+		}
+	}
+
+	inst, err := requestInstance(r)
+	if err != nil {
+		zlog.Logger.Warnf("Bad instance path=%s, err=%s", r.URL.Path, err)
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	decoder, err := exchange.NewDecoder(format, http.MaxBytesReader(w, r.Body, importMaxBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	metrics := make([]*metric.Metric, 0)
+
+	for {
+		m, err := decoder.Decode()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if errors.Is(err, exchange.ErrBadRow) {
+			report.Rows++
+			report.Invalid++
+
+			if len(report.Errors) < importMaxErrors {
+				report.Errors = append(report.Errors, RowError{Row: decoder.Row(), Error: err.Error()})
+			}
+
+			continue
+		}
+
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				http.Error(w, "body is too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			zlog.Logger.Warnf("Decode import row=%d, err=%s", decoder.Row(), err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		report.Rows++
+		metrics = append(metrics, namespaced(inst, m))
+	}
+
+	if report.DryRun || report.Invalid > 0 {
+		status := http.StatusOK
+		if !report.DryRun {
+			status = http.StatusBadRequest
+		}
+
+		writeImportReport(w, status, &report)
+
+		return
+	}
+
+	for start := 0; start < len(metrics); start += importBatchSize {
+		end := start + importBatchSize
+		if end > len(metrics) {
+			end = len(metrics)
+		}
+
+		if err := u.storage.BatchUpdate(r.Context(), metrics[start:end]); err != nil {
+			zlog.Logger.Errorf("import batch update err=%s, applied=%d", err, report.Applied)
+			writeImportReport(w, http.StatusInternalServerError, &report)
+
+			return
+		}
+
+		report.Applied = end
+	}
+
+	zlog.Logger.Infof("Metrics are imported count=%d, instance=%s", report.Applied, inst)
+	writeImportReport(w, http.StatusOK, &report)
+}
+
+func writeImportReport(w http.ResponseWriter, status int, report *ImportReport) {
+	data, err := json.Marshal(report)
+	if err != nil {
+		zlog.Logger.Errorf("Marshal import report, err=%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if _, err := w.Write(data); err != nil {
+		zlog.Logger.Errorf("Write data to response, err=%s", err)
+	}
+}
@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/kuzhukin/metrics-collector/internal/instance"
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+)
+
+// returns instance of the request from the query parameter or from the identity header
+func requestInstance(r *http.Request) (string, error) {
+	inst := instance.FromRequest(r)
+
+	if err := instance.Validate(inst); err != nil {
+		return "", err
+	}
+
+	return inst, nil
+}
+
+// returns copy of the metric with id in the instance's namespace
+func namespaced(inst string, m *metric.Metric) *metric.Metric {
+	if inst == "" {
+		return m
+	}
+
+	copy := *m
+	copy.ID = instance.Namespace(inst, m.ID)
+
+	return &copy
+}
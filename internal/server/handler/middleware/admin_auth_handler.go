@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/kuzhukin/metrics-collector/internal/zlog"
+)
+
+const bearerPrefix = "Bearer "
+
+// NewAdminAuthHandler - middleware which passes only requests with "Authorization: Bearer {token}" header,
+// the token is separate from the signature key of agents, all requests are forbidden if it's empty
+func NewAdminAuthHandler(token string) func(h http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				zlog.Logger.Warnf("Admin request path=%s is forbidden, admin token isn't configured", r.URL.Path)
+				w.WriteHeader(http.StatusForbidden)
+
+				return
+			}
+
+			provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), bearerPrefix)
+			if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				zlog.Logger.Warnf("Admin request path=%s has bad token", r.URL.Path)
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				w.WriteHeader(http.StatusUnauthorized)
+
+				return
+			}
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
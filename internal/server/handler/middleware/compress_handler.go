@@ -37,10 +37,15 @@ func CompressingHTTPHandler(h http.Handler) http.Handler {
 }
 
 var _ http.ResponseWriter = &compressResponseWriter{}
+var _ http.Flusher = &compressResponseWriter{}
 
 type compressResponseWriter struct {
 	wr http.ResponseWriter
 	zw *gzip.Writer
+
+	wroteHeader bool
+	// responses with the status without body aren't compressed
+	noBody bool
 }
 
 func newCompressResponseWriter(w http.ResponseWriter) *compressResponseWriter {
@@ -55,21 +60,67 @@ func (c *compressResponseWriter) Header() http.Header {
 }
 
 func (c *compressResponseWriter) Write(b []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+
+	if c.noBody {
+		return c.wr.Write(b)
+	}
+
 	return c.zw.Write(b)
 }
 
+// WriteHeader - bodies of all statuses are compressed, so error messages are readable by clients too
 func (c *compressResponseWriter) WriteHeader(status int) {
-	if status < 300 {
+	if c.wroteHeader {
+		return
+	}
+
+	c.wroteHeader = true
+
+	if bodyAllowed(status) {
 		c.wr.Header().Set("Content-Encoding", "gzip")
+		// length of the compressed body differs from the length set by the handler
+		c.wr.Header().Del("Content-Length")
+	} else {
+		c.noBody = true
 	}
 
 	c.wr.WriteHeader(status)
 }
 
+// Flush - writes compressed data buffered by gzip to the client, it's needed for streaming responses
+func (c *compressResponseWriter) Flush() {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+
+	if !c.noBody {
+		if err := c.zw.Flush(); err != nil {
+			return
+		}
+	}
+
+	if flusher, ok := c.wr.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Close - finishes the compressed body, nothing is written if the handler hasn't written the response
 func (c *compressResponseWriter) Close() error {
+	if !c.wroteHeader || c.noBody {
+		return nil
+	}
+
 	return c.zw.Close()
 }
 
+// informational, 204 and 304 responses don't have body
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
 type decompressReader struct {
 	r  io.ReadCloser
 	zr *gzip.Reader
@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressingHTTPHandler(t *testing.T) {
+	testCases := []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+		body    string
+		gzipped bool
+	}{
+		{
+			name: "ok",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("ok"))
+			},
+			status:  http.StatusOK,
+			body:    "ok",
+			gzipped: true,
+		},
+		{
+			name: "error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "bad metric", http.StatusBadRequest)
+			},
+			status:  http.StatusBadRequest,
+			body:    "bad metric\n",
+			gzipped: true,
+		},
+		{
+			name: "without body",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			},
+			status: http.StatusNoContent,
+		},
+		{
+			name:    "nothing is written",
+			handler: func(w http.ResponseWriter, r *http.Request) {},
+			status:  http.StatusOK,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(CompressingHTTPHandler(tc.handler))
+			defer server.Close()
+
+			req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+			require.NoError(t, err)
+			// the transport doesn't decompress the body if the header is set explicitly
+			req.Header.Set("Accept-Encoding", "gzip")
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, tc.status, resp.StatusCode)
+
+			var body io.Reader = resp.Body
+
+			if tc.gzipped {
+				require.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+
+				zr, err := gzip.NewReader(resp.Body)
+				require.NoError(t, err)
+				defer zr.Close()
+
+				body = zr
+			} else {
+				require.Empty(t, resp.Header.Get("Content-Encoding"))
+			}
+
+			data, err := io.ReadAll(body)
+			require.NoError(t, err)
+			require.Equal(t, tc.body, string(data))
+		})
+	}
+}
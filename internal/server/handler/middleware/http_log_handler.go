@@ -21,6 +21,7 @@ func LoggingHTTPHandler(h http.Handler) http.Handler {
 }
 
 var _ http.ResponseWriter = &loggingResponseWriter{}
+var _ http.Flusher = &loggingResponseWriter{}
 
 type loggingResponseWriter struct {
 	http.ResponseWriter
@@ -45,6 +46,12 @@ func (l *loggingResponseWriter) WriteHeader(status int) {
 	l.status = status
 }
 
+func (l *loggingResponseWriter) Flush() {
+	if flusher, ok := l.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 func (l *loggingResponseWriter) doRequestWithTimer(h http.Handler, r *http.Request) time.Duration {
 	start := time.Now()
 
@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"reflect"
 
+	"github.com/kuzhukin/metrics-collector/internal/instance"
 	"github.com/kuzhukin/metrics-collector/internal/zlog"
 )
 
@@ -39,7 +40,10 @@ func SignCheckHandler(h http.Handler) http.Handler {
 				return
 			}
 
-			if err := checkDataConsistency(data, secretKey, expectedHash); err != nil {
+			// signatures of requests with instance are checked with the instance's key
+			key := instance.SigningKey(secretKey, instance.FromRequest(r))
+
+			if err := checkDataConsistency(data, key, expectedHash); err != nil {
 				if errors.Is(err, ErrBadDataHash) {
 					zlog.Logger.Warnf("Bad data signature err=%s", err)
 					w.WriteHeader(http.StatusBadRequest)
@@ -58,6 +62,7 @@ func SignCheckHandler(h http.Handler) http.Handler {
 }
 
 var _ http.ResponseWriter = &signResponseWriter{}
+var _ http.Flusher = &signResponseWriter{}
 
 type signResponseWriter struct {
 	http.ResponseWriter
@@ -80,6 +85,12 @@ func (c *signResponseWriter) Write(b []byte) (int, error) {
 	return c.ResponseWriter.Write(b)
 }
 
+func (c *signResponseWriter) Flush() {
+	if flusher, ok := c.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 func SignCreateHandler(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		signResponseWriter := newSignResponseWriter(w)
@@ -0,0 +1,168 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kuzhukin/metrics-collector/internal/instance"
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+	"github.com/kuzhukin/metrics-collector/internal/server/endpoint"
+	"github.com/kuzhukin/metrics-collector/internal/server/storage"
+	"github.com/kuzhukin/metrics-collector/internal/zlog"
+)
+
+var _ http.Handler = &QueryHandler{}
+
+const (
+	queryLimitDefault = 100
+	queryLimitMax     = 1000
+)
+
+// HTTP handler for querying metrics in JSON format
+// GET /api/v1/metrics?match={glob}&regex={regexp}&kind={kind}&sort={name|kind}&limit={limit}&cursor={cursor}
+type QueryHandler struct {
+	storage storage.Storage
+}
+
+func NewQueryHandler(storage storage.Storage) *QueryHandler {
+	return &QueryHandler{
+		storage: storage,
+	}
+}
+
+// QueryResponse - page of metrics, next cursor is empty for the last page
+type QueryResponse struct {
+	Metrics    []*metric.Metric `json:"metrics"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+func (u *QueryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		zlog.Logger.Infof("Endpoint %s supports only GET method", endpoint.MetricsQueryEndpoint)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	inst, err := requestInstance(r)
+	if err != nil {
+		zlog.Logger.Warnf("Bad instance path=%s, err=%s", r.URL.Path, err)
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	query, err := parseQuery(r, inst)
+	if err != nil {
+		zlog.Logger.Warnf("Parse query=%s, err=%s", r.URL.RawQuery, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	limit := query.Limit
+	// the extra metric shows that the next page exists
+	query.Limit++
+
+	metrics, err := u.storage.Query(r.Context(), query)
+	if err != nil {
+		if errors.Is(err, storage.ErrBadQuery) || errors.Is(err, storage.ErrUnknownKind) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		zlog.Logger.Errorf("storage query=%+v, err=%s", query, err)
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	metrics = filterByInstance(inst, metrics)
+	resp := QueryResponse{Metrics: metrics}
+
+	if len(metrics) > limit {
+		resp.Metrics = metrics[:limit]
+		resp.NextCursor = encodeCursor(metrics[limit-1])
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		zlog.Logger.Errorf("Marshal query response, err=%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := w.Write(data); err != nil {
+		zlog.Logger.Errorf("Write data to response, err=%s", err)
+	}
+}
+
+func parseQuery(r *http.Request, inst string) (storage.Query, error) {
+	params := r.URL.Query()
+
+	query := storage.Query{
+		Prefix: instance.Namespace(inst, ""),
+		Match:  params.Get("match"),
+		Regexp: params.Get("regex"),
+		Kind:   metric.Kind(params.Get("kind")),
+		Sort:   storage.SortOrder(params.Get("sort")),
+		Limit:  queryLimitDefault,
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value <= 0 {
+			return storage.Query{}, fmt.Errorf("bad limit=%s", limit)
+		}
+
+		if value > queryLimitMax {
+			value = queryLimitMax
+		}
+
+		query.Limit = value
+	}
+
+	if cursor := params.Get("cursor"); cursor != "" {
+		after, err := decodeCursor(cursor)
+		if err != nil {
+			return storage.Query{}, fmt.Errorf("bad cursor, err=%w", err)
+		}
+
+		after.ID = instance.Namespace(inst, after.ID)
+		query.After = after
+	}
+
+	if err := query.Validate(); err != nil {
+		return storage.Query{}, err
+	}
+
+	return query, nil
+}
+
+// the cursor is the key of the last metric of the page, id is stored without instance namespace
+func encodeCursor(last *metric.Metric) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(string(last.Type) + "/" + last.ID))
+}
+
+func decodeCursor(cursor string) (*metric.Metric, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	kind, id, ok := strings.Cut(string(data), "/")
+	if !ok {
+		return nil, errors.New("cursor doesn't contain metric key")
+	}
+
+	return &metric.Metric{ID: id, Type: metric.Kind(kind)}, nil
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kuzhukin/metrics-collector/internal/instance"
+	"github.com/kuzhukin/metrics-collector/internal/server/storage/memorystorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryHandler(t *testing.T) {
+	s := memorystorage.New()
+	s.GaugeMetrics.Write("HeapAlloc", 1)
+	s.GaugeMetrics.Write("HeapIdle", 2)
+	s.GaugeMetrics.Write("HeapInuse", 3)
+	s.GaugeMetrics.Write("Alloc", 4)
+	s.GaugeMetrics.Write("host-1/HeapAlloc", 5)
+	s.CounterMetrics.Sum("HeapObjects", 6)
+
+	handler := NewQueryHandler(s)
+
+	query := func(target string, inst string) (int, QueryResponse) {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if inst != "" {
+			r.Header.Set(instance.IDHeader, inst)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		resp := QueryResponse{}
+		if w.Code == http.StatusOK {
+			require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		}
+
+		return w.Code, resp
+	}
+
+	ids := func(resp QueryResponse) []string {
+		ids := make([]string, 0, len(resp.Metrics))
+		for _, m := range resp.Metrics {
+			ids = append(ids, m.ID)
+		}
+
+		return ids
+	}
+
+	code, resp := query("/api/v1/metrics?match=Heap*&kind=gauge&sort=name&limit=2", "")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, []string{"HeapAlloc", "HeapIdle"}, ids(resp))
+	require.NotEmpty(t, resp.NextCursor)
+
+	code, resp = query("/api/v1/metrics?match=Heap*&kind=gauge&sort=name&limit=2&cursor="+resp.NextCursor, "")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, []string{"HeapInuse"}, ids(resp))
+	require.Empty(t, resp.NextCursor)
+
+	code, resp = query("/api/v1/metrics?regex=^Heap(Alloc|Objects)$&sort=kind", "")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, []string{"HeapObjects", "HeapAlloc"}, ids(resp))
+	require.Equal(t, int64(6), *resp.Metrics[0].Delta)
+
+	code, resp = query("/api/v1/metrics", "host-1")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, []string{"HeapAlloc"}, ids(resp))
+	require.Equal(t, 5.0, *resp.Metrics[0].Value)
+
+	code, resp = query("/api/v1/metrics?match=Unknown*", "")
+	require.Equal(t, http.StatusOK, code)
+	require.NotNil(t, resp.Metrics)
+	require.Empty(t, resp.Metrics)
+
+	for _, target := range []string{
+		"/api/v1/metrics?kind=histogram",
+		"/api/v1/metrics?sort=value",
+		"/api/v1/metrics?limit=0",
+		"/api/v1/metrics?limit=ten",
+		"/api/v1/metrics?regex=(",
+		"/api/v1/metrics?cursor=???",
+	} {
+		code, _ = query(target, "")
+		require.Equal(t, http.StatusBadRequest, code, target)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/metrics", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/kuzhukin/metrics-collector/internal/instance"
+	"github.com/kuzhukin/metrics-collector/internal/server/endpoint"
+	"github.com/kuzhukin/metrics-collector/internal/server/storage"
+	"github.com/kuzhukin/metrics-collector/internal/zlog"
+)
+
+var _ http.Handler = &ResetHandler{}
+
+// HTTP handler for setting counter to zero
+// POST /reset/counter/{name}
+type ResetHandler struct {
+	storage storage.Storage
+}
+
+func NewResetHandler(storage storage.Storage) *ResetHandler {
+	return &ResetHandler{
+		storage: storage,
+	}
+}
+
+func (u *ResetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		zlog.Logger.Infof("Endpoint %s supports only POST method", endpoint.ResetCounterEndpoint)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	name := chi.URLParam(r, "name")
+	if name == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	inst, err := requestInstance(r)
+	if err != nil {
+		zlog.Logger.Warnf("Bad instance path=%s, err=%s", r.URL.Path, err)
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	if err := u.storage.Reset(r.Context(), instance.Namespace(inst, name)); err != nil {
+		zlog.Logger.Warnf("storage reset name=%s, instance=%s err=%s", name, inst, err)
+		w.WriteHeader(storageErrorStatus(err))
+
+		return
+	}
+
+	zlog.Logger.Infof("Counter name=%s, instance=%s is reset", name, inst)
+	w.WriteHeader(http.StatusOK)
+}
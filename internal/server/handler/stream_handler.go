@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kuzhukin/metrics-collector/internal/instance"
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+	"github.com/kuzhukin/metrics-collector/internal/server/broadcaster"
+	"github.com/kuzhukin/metrics-collector/internal/server/endpoint"
+	"github.com/kuzhukin/metrics-collector/internal/zlog"
+)
+
+var _ http.Handler = &StreamHandler{}
+
+// events which aren't sent to the client yet, the client is dropped after overflow
+const streamBufferSize = 256
+
+// delay of reconnection which is suggested to clients of EventSource, in milliseconds
+const streamRetryDelay = 3000
+
+// types of server-sent events
+const (
+	streamEventUpdate    = "update"
+	streamEventDelete    = "delete"
+	streamEventHeartbeat = "heartbeat"
+	// the client has missed events and should reload all metrics, the stream is finished after it
+	streamEventResync = "resync"
+)
+
+// HTTP handler for streaming updates and deletions of metrics as server-sent events
+// GET /api/v1/stream?match={glob}&regex={regexp}&kind={kind}
+type StreamHandler struct {
+	broadcaster *broadcaster.Broadcaster
+	heartbeat   time.Duration
+}
+
+func NewStreamHandler(broadcaster *broadcaster.Broadcaster, heartbeat time.Duration) *StreamHandler {
+	return &StreamHandler{
+		broadcaster: broadcaster,
+		heartbeat:   heartbeat,
+	}
+}
+
+// StreamEvent - data of update and delete events, counters of updates contain delta of the update,
+// values of deleted metrics aren't set
+type StreamEvent struct {
+	*metric.Metric
+	Time time.Time `json:"time"`
+}
+
+func (h *StreamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		zlog.Logger.Infof("Endpoint %s supports only GET method", endpoint.StreamEndpoint)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	inst, err := requestInstance(r)
+	if err != nil {
+		zlog.Logger.Warnf("Bad instance path=%s, err=%s", r.URL.Path, err)
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	query, err := parseQuery(r, inst)
+	if err != nil {
+		zlog.Logger.Warnf("Parse query=%s, err=%s", r.URL.RawQuery, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	matcher, err := query.Matcher()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		zlog.Logger.Errorf("Response writer doesn't support flushing path=%s", r.URL.Path)
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	subscription, err := h.broadcaster.Subscribe(func(m *metric.Metric) bool {
+		return (query.Kind == "" || m.Type == query.Kind) && matcher(m.ID)
+	}, streamBufferSize)
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+
+		return
+	}
+	defer subscription.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// proxies mustn't buffer the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", streamRetryDelay); err != nil {
+		return
+	}
+
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		var err error
+
+		select {
+		case event := <-subscription.Events():
+			err = writeStreamEvent(w, inst, event)
+		case now := <-heartbeat.C:
+			err = writeServerEvent(w, streamEventHeartbeat, struct {
+				Time time.Time `json:"time"`
+			}{Time: now})
+		case <-subscription.Done():
+			// the server is stopping if the subscriber isn't evicted, clients reconnect by themselves
+			if errors.Is(subscription.Err(), broadcaster.ErrSlowConsumer) {
+				zlog.Logger.Infof("Stream client is too slow, resync is requested path=%s", r.URL.Path)
+
+				if err := writeServerEvent(w, streamEventResync, struct {
+					Reason string `json:"reason"`
+				}{Reason: subscription.Err().Error()}); err == nil {
+					flusher.Flush()
+				}
+			}
+
+			return
+		case <-r.Context().Done():
+			return
+		}
+
+		if err != nil {
+			zlog.Logger.Infof("Write stream event, err=%s", err)
+			return
+		}
+
+		flusher.Flush()
+	}
+}
+
+func writeStreamEvent(w http.ResponseWriter, inst string, event broadcaster.Event) error {
+	// published metrics are shared between subscribers, so the id is stripped in the copy
+	m := *event.Metric
+	m.ID, _ = instance.Strip(inst, m.ID)
+
+	eventType := streamEventUpdate
+	if event.Deleted {
+		eventType = streamEventDelete
+	}
+
+	return writeServerEvent(w, eventType, StreamEvent{Metric: &m, Time: event.Time})
+}
+
+func writeServerEvent(w http.ResponseWriter, eventType string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("marshal event=%s, err=%w", eventType, err)
+	}
+
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, payload); err != nil {
+		return fmt.Errorf("write event=%s, err=%w", eventType, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,200 @@
+package handler
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+	"github.com/kuzhukin/metrics-collector/internal/server/broadcaster"
+	"github.com/kuzhukin/metrics-collector/internal/server/handler/middleware"
+	"github.com/kuzhukin/metrics-collector/internal/server/storage"
+	"github.com/kuzhukin/metrics-collector/internal/server/storage/memorystorage"
+	"github.com/kuzhukin/metrics-collector/internal/server/storage/notifystorage"
+	"github.com/stretchr/testify/require"
+)
+
+type serverEvent struct {
+	Type string
+	Data string
+}
+
+// reads server-sent events from the gzipped stream
+func readServerEvents(body io.Reader) <-chan serverEvent {
+	events := make(chan serverEvent, 16)
+
+	go func() {
+		defer close(events)
+
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return
+		}
+
+		scanner := bufio.NewScanner(zr)
+		event := serverEvent{}
+
+		for scanner.Scan() {
+			line := scanner.Text()
+
+			switch {
+			case line == "":
+				events <- event
+				event = serverEvent{}
+			case strings.HasPrefix(line, "event: "):
+				event.Type = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				event.Data = strings.TrimPrefix(line, "data: ")
+			case strings.HasPrefix(line, "retry: "):
+				event.Type = "retry"
+			}
+		}
+	}()
+
+	return events
+}
+
+func nextServerEvent(t *testing.T, events <-chan serverEvent) serverEvent {
+	select {
+	case event, ok := <-events:
+		require.True(t, ok, "stream is finished")
+		return event
+	case <-time.After(time.Second * 5):
+		require.Fail(t, "event isn't received")
+		return serverEvent{}
+	}
+}
+
+func openStream(t *testing.T, ctx context.Context, url string) (*http.Response, <-chan serverEvent) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	require.NoError(t, err)
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	resp, err := http.DefaultClient.Do(r)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	require.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+
+	events := readServerEvents(resp.Body)
+	// the first frame is sent after subscription, so published metrics aren't missed after it
+	require.Equal(t, "retry", nextServerEvent(t, events).Type)
+
+	return resp, events
+}
+
+func TestStreamHandler(t *testing.T) {
+	b := broadcaster.New()
+	s := notifystorage.New(memorystorage.New(), b)
+
+	handler := middleware.LoggingHTTPHandler(middleware.CompressingHTTPHandler(NewStreamHandler(b, time.Hour)))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	resp, events := openStream(t, ctx, server.URL+"/api/v1/stream?match=Heap*")
+	defer resp.Body.Close()
+
+	value := 1.5
+	require.NoError(t, s.Update(ctx, &metric.Metric{ID: "Alloc", Type: metric.Gauge, Value: &value}))
+	require.NoError(t, s.Update(ctx, &metric.Metric{ID: "HeapAlloc", Type: metric.Gauge, Value: &value}))
+
+	event := nextServerEvent(t, events)
+	require.Equal(t, "update", event.Type)
+
+	update := StreamEvent{}
+	require.NoError(t, json.Unmarshal([]byte(event.Data), &update))
+	require.Equal(t, "HeapAlloc", update.ID)
+	require.Equal(t, metric.Gauge, update.Type)
+	require.Equal(t, value, *update.Value)
+	require.False(t, update.Time.IsZero())
+
+	require.NoError(t, s.Delete(ctx, metric.Gauge, "HeapAlloc"))
+
+	event = nextServerEvent(t, events)
+	require.Equal(t, "delete", event.Type)
+
+	deletion := StreamEvent{}
+	require.NoError(t, json.Unmarshal([]byte(event.Data), &deletion))
+	require.Equal(t, "HeapAlloc", deletion.ID)
+	require.Equal(t, metric.Gauge, deletion.Type)
+	require.Nil(t, deletion.Value)
+
+	delta := int64(1)
+	require.NoError(t, s.Update(ctx, &metric.Metric{ID: "HeapObjects", Type: metric.Counter, Delta: &delta}))
+	require.Equal(t, "update", nextServerEvent(t, events).Type)
+
+	deleted, err := s.DeleteMatching(ctx, storage.Query{Match: "Heap*"})
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+
+	event = nextServerEvent(t, events)
+	require.Equal(t, "delete", event.Type)
+	require.Contains(t, event.Data, `"id":"HeapObjects"`)
+	require.Contains(t, event.Data, `"type":"counter"`)
+	require.NotContains(t, event.Data, "delta")
+}
+
+func TestStreamHandlerHeartbeatAndResync(t *testing.T) {
+	b := broadcaster.New()
+
+	handler := middleware.CompressingHTTPHandler(NewStreamHandler(b, time.Millisecond*10))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	resp, events := openStream(t, ctx, server.URL+"/api/v1/stream")
+	defer resp.Body.Close()
+
+	require.Equal(t, "heartbeat", nextServerEvent(t, events).Type)
+
+	// the handler can't send events as fast as they are published, so the client is dropped
+	metrics := make([]*metric.Metric, 0, streamBufferSize*100)
+	for i := 0; i < cap(metrics); i++ {
+		value := float64(i)
+		metrics = append(metrics, &metric.Metric{ID: fmt.Sprintf("m%d", i), Type: metric.Gauge, Value: &value})
+	}
+
+	b.Publish(metrics...)
+
+	for {
+		event, ok := <-events
+		require.True(t, ok, "stream is finished without resync")
+
+		if event.Type == "resync" {
+			require.Contains(t, event.Data, broadcaster.ErrSlowConsumer.Error())
+			break
+		}
+	}
+
+	_, ok := <-events
+	require.False(t, ok)
+}
+
+func TestStreamHandlerBadQuery(t *testing.T) {
+	handler := NewStreamHandler(broadcaster.New(), time.Hour)
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/stream?kind=histogram", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	r = httptest.NewRequest(http.MethodPost, "/api/v1/stream", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
@@ -1,10 +1,16 @@
 package handler
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"net/http"
+	"strconv"
 
-	"github.com/kuzhukin/metrics-collector/internal/server/codec"
+	"github.com/kuzhukin/metrics-collector/internal/idempotency"
+	"github.com/kuzhukin/metrics-collector/internal/instance"
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+	"github.com/kuzhukin/metrics-collector/internal/server/dedupe"
 	"github.com/kuzhukin/metrics-collector/internal/server/parser"
 	"github.com/kuzhukin/metrics-collector/internal/server/storage"
 	"github.com/kuzhukin/metrics-collector/internal/zlog"
@@ -12,19 +18,27 @@ import (
 
 var _ http.Handler = &BatchUpdateHandler{}
 
+// the header is set in responses to batches which were applied already
+const idempotentReplayedHeader = "Idempotent-Replayed"
+
 // BatchUpdateHandler
 // HTTP handler for updating metrics in batch mode
-// POST /updates/
-// handles metrics batch update
+// POST /updates/?partial={true|false}
+// handles metrics batch update, the whole batch is rejected if any metric is invalid,
+// only invalid metrics are rejected in partial mode,
+// batch with already applied Idempotency-Key is acknowledged without applying
 type BatchUpdateHandler struct {
 	storage storage.Storage
 	parser  parser.BatchRequestParser
+	// keys of applied batches, batches aren't deduplicated if it's nil
+	dedupe *dedupe.Cache
 }
 
-func NewBatchUpdateHandler(storage storage.Storage, parser parser.BatchRequestParser) *BatchUpdateHandler {
+func NewBatchUpdateHandler(storage storage.Storage, parser parser.BatchRequestParser, dedupe *dedupe.Cache) *BatchUpdateHandler {
 	return &BatchUpdateHandler{
 		storage: storage,
 		parser:  parser,
+		dedupe:  dedupe,
 	}
 }
 
@@ -34,31 +48,125 @@ func (h *BatchUpdateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	metrics, err := h.parser.BatchParse(r)
+	partial := false
+
+	if value := r.URL.Query().Get("partial"); value != "" {
+		var err error
+
+		partial, err = strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "bad partial="+value, http.StatusBadRequest)
+			return
+		}
+	}
+
+	metrics, rejected, err := h.parser.BatchParse(r)
 	if err != nil {
-		if errors.Is(err, parser.ErrMetricNameIsNotFound) {
-			zlog.Logger.Warnf("Metric wasn't found path=%s, err=%s", r.URL.Path, err)
-			w.WriteHeader(http.StatusNotFound)
-		} else if errors.Is(err, codec.ErrBadMetricValue) {
-			zlog.Logger.Warnf("Bad metric value path=%s, err=%s", r.URL.Path, err)
-			w.WriteHeader(http.StatusBadRequest)
-		} else if errors.Is(err, parser.ErrBadMetricKind) {
-			zlog.Logger.Warnf("Bad metric kind path=%s, err=%s", r.URL.Path, err)
-			w.WriteHeader(http.StatusBadRequest)
-		} else {
-			zlog.Logger.Warnf("Parse request path=%s, err=%s", r.URL.Path, err)
-			w.WriteHeader(http.StatusBadRequest)
+		zlog.Logger.Warnf("Parse request path=%s, err=%s", r.URL.Path, err)
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	if len(rejected) > 0 {
+		zlog.Logger.Warnf("Batch has invalid metrics path=%s, rejected=%d, first=%+v", r.URL.Path, len(rejected), rejected[0])
+
+		if !partial {
+			writeBatchResult(w, rejectionStatus(rejected[0]), &metric.BatchResult{Rejected: rejected})
+			return
 		}
+	}
+
+	inst, err := requestInstance(r)
+	if err != nil {
+		zlog.Logger.Warnf("Bad instance path=%s, err=%s", r.URL.Path, err)
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
 
+	key := r.Header.Get(idempotency.Header)
+	if err := idempotency.Validate(key); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if err := h.storage.BatchUpdate(r.Context(), metrics); err != nil {
+	for i, m := range metrics {
+		metrics[i] = namespaced(inst, m)
+	}
+
+	// keys of different instances don't collide
+	if key != "" {
+		key = instance.Namespace(inst, key)
+	}
+
+	replayed, err := h.update(r.Context(), key, metrics)
+	if errors.Is(err, dedupe.ErrInProgress) {
+		zlog.Logger.Infof("Batch with idempotency key=%s, instance=%s is in progress", key, inst)
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusConflict)
+
+		return
+	}
+
+	if err != nil {
 		zlog.Logger.Errorf("batch updater metrics err=%s\n", err)
 		w.WriteHeader(http.StatusInternalServerError)
 
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	// result of the first request isn't kept, so the replayed request is acknowledged without it
+	if replayed {
+		zlog.Logger.Infof("Batch with idempotency key=%s, instance=%s is applied already", key, inst)
+		w.Header().Set(idempotentReplayedHeader, "true")
+		w.WriteHeader(http.StatusOK)
+
+		return
+	}
+
+	writeBatchResult(w, http.StatusOK, &metric.BatchResult{Accepted: len(metrics), Rejected: rejected})
+}
+
+// applies metrics once per key, returns true if the batch with the key was applied already
+func (h *BatchUpdateHandler) update(ctx context.Context, key string, metrics []*metric.Metric) (bool, error) {
+	apply := func(ctx context.Context) error {
+		if len(metrics) == 0 {
+			return nil
+		}
+
+		return h.storage.BatchUpdate(ctx, metrics)
+	}
+
+	if h.dedupe == nil || key == "" {
+		return false, apply(ctx)
+	}
+
+	return h.dedupe.Do(ctx, key, apply)
+}
+
+// statuses of rejected batches are the same as statuses of single updates
+func rejectionStatus(rejection metric.Rejection) int {
+	if rejection.Reason == metric.RejectBadName {
+		return http.StatusNotFound
+	}
+
+	return http.StatusBadRequest
+}
+
+func writeBatchResult(w http.ResponseWriter, status int, result *metric.BatchResult) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		zlog.Logger.Errorf("Marshal batch result, err=%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if _, err := w.Write(data); err != nil {
+		zlog.Logger.Errorf("Write data to response, err=%s", err)
+	}
 }
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kuzhukin/metrics-collector/internal/idempotency"
+	"github.com/kuzhukin/metrics-collector/internal/instance"
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+	"github.com/kuzhukin/metrics-collector/internal/server/dedupe"
+	"github.com/kuzhukin/metrics-collector/internal/server/parser"
+	"github.com/kuzhukin/metrics-collector/internal/server/storage/memorystorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchUpdatePartial(t *testing.T) {
+	s := memorystorage.New()
+	handler := NewBatchUpdateHandler(s, parser.New(), nil)
+
+	post := func(target string, body string) (int, metric.BatchResult) {
+		r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		result := metric.BatchResult{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+
+		return w.Code, result
+	}
+
+	body := `[{"id":"Alloc","type":"gauge","value":1.5},{"id":"PollCount","type":"counter"}]`
+
+	code, result := post("/updates/", body)
+	require.Equal(t, http.StatusBadRequest, code)
+	require.Equal(t, 0, result.Accepted)
+	require.Len(t, result.Rejected, 1)
+	require.Equal(t, metric.RejectMissingValue, result.Rejected[0].Reason)
+	require.Empty(t, s.GaugeMetrics.GetAll())
+
+	code, result = post("/updates/?partial=true", body)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, 1, result.Accepted)
+	require.Equal(t, []metric.Rejection{{
+		Index:   1,
+		ID:      "PollCount",
+		Reason:  metric.RejectMissingValue,
+		Message: result.Rejected[0].Message,
+	}}, result.Rejected)
+	require.Equal(t, map[string]float64{"Alloc": 1.5}, s.GaugeMetrics.GetAll())
+
+	code, result = post("/updates/", `[{"type":"gauge","value":1}]`)
+	require.Equal(t, http.StatusNotFound, code)
+	require.Equal(t, metric.RejectBadName, result.Rejected[0].Reason)
+
+	code, result = post("/updates/", `[{"id":"PollCount","type":"counter","delta":1}]`)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, 1, result.Accepted)
+	require.Empty(t, result.Rejected)
+}
+
+func TestBatchUpdateIdempotency(t *testing.T) {
+	s := memorystorage.New()
+	handler := NewBatchUpdateHandler(s, parser.New(), dedupe.New(10, time.Minute, nil))
+
+	post := func(key string, inst string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(`[{"id":"PollCount","type":"counter","delta":1}]`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set(idempotency.Header, key)
+		r.Header.Set(instance.IDHeader, inst)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		return w
+	}
+
+	w := post("key-1", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Empty(t, w.Header().Get(idempotentReplayedHeader))
+
+	w = post("key-1", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "true", w.Header().Get(idempotentReplayedHeader))
+
+	// keys of instances are independent
+	require.Equal(t, http.StatusOK, post("key-1", "host-1").Code)
+	require.Equal(t, http.StatusOK, post("key-2", "").Code)
+	require.Equal(t, http.StatusBadRequest, post("bad key", "").Code)
+
+	require.Equal(t, map[string]int64{"PollCount": 2, "host-1/PollCount": 1}, s.CounterMetrics.GetAll())
+}
@@ -55,7 +55,15 @@ func (u *UpdateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := u.storage.Update(r.Context(), metric); err != nil {
+	inst, err := requestInstance(r)
+	if err != nil {
+		zlog.Logger.Warnf("Bad instance path=%s, err=%s", r.URL.Path, err)
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	if err := u.storage.Update(r.Context(), namespaced(inst, metric)); err != nil {
 		zlog.Logger.Errorf("Metrics=%v updating err=%s\n", metric, err)
 		w.WriteHeader(http.StatusInternalServerError)
 
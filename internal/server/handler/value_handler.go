@@ -3,6 +3,7 @@ package handler
 import (
 	"net/http"
 
+	"github.com/kuzhukin/metrics-collector/internal/instance"
 	"github.com/kuzhukin/metrics-collector/internal/server/endpoint"
 	"github.com/kuzhukin/metrics-collector/internal/server/parser"
 	"github.com/kuzhukin/metrics-collector/internal/server/storage"
@@ -42,14 +43,24 @@ func (u *ValueHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	storedMetric, err := u.storage.Get(r.Context(), metric.Type, metric.ID)
+	inst, err := requestInstance(r)
 	if err != nil {
-		zlog.Logger.Errorf("storage get kind=%s, name=%s err=%s", metric.Type, metric.ID, err)
+		zlog.Logger.Warnf("Bad instance path=%s, err=%s", r.URL.Path, err)
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	storedMetric, err := u.storage.Get(r.Context(), metric.Type, instance.Namespace(inst, metric.ID))
+	if err != nil {
+		zlog.Logger.Errorf("storage get kind=%s, name=%s, instance=%s err=%s", metric.Type, metric.ID, inst, err)
 		w.WriteHeader(http.StatusNotFound)
 
 		return
 	}
 
+	storedMetric.ID = metric.ID
+
 	if err := response(w, r, storedMetric); err != nil {
 		zlog.Logger.Warnf("response metric=%v, err=%s", *storedMetric, err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
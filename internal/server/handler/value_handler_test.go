@@ -6,6 +6,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/kuzhukin/metrics-collector/internal/instance"
 	"github.com/kuzhukin/metrics-collector/internal/metric"
 	"github.com/kuzhukin/metrics-collector/internal/server/parser/mockparser"
 	"github.com/kuzhukin/metrics-collector/internal/server/storage/mockstorage"
@@ -54,3 +55,25 @@ func TestGetValueJSONHandler(t *testing.T) {
 	require.Equal(t, http.StatusOK, w.Code)
 	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
 }
+
+func TestGetValueHandlerWithInstance(t *testing.T) {
+	mockStorage := mockstorage.NewStorage(t)
+	mockParser := mockparser.NewRequestParser(t)
+
+	handler := NewValueHandler(mockStorage, mockParser)
+
+	r := httptest.NewRequest(http.MethodGet, "/value/gauge", nil)
+	r.Header.Set(instance.IDHeader, "host-1")
+	w := httptest.NewRecorder()
+
+	value := 1.1
+	stored := &metric.Metric{ID: "host-1/" + fakeMetric.ID, Type: metric.Gauge, Value: &value}
+
+	mockStorage.On("Get", mock.Anything, metric.Gauge, "host-1/"+fakeMetric.ID).Return(stored, nil)
+	mockParser.On("Parse", r).Return(fakeMetric, nil)
+
+	handler.ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "1.1", w.Body.String())
+}
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/kuzhukin/metrics-collector/internal/server/endpoint"
+	"github.com/kuzhukin/metrics-collector/internal/server/webhook"
+	"github.com/kuzhukin/metrics-collector/internal/zlog"
+)
+
+var _ http.Handler = &WebhooksHandler{}
+var _ http.Handler = &WebhookTestHandler{}
+
+// HTTP handler for listing webhook subscriptions and statistics of their deliveries
+// GET /api/v1/webhooks
+type WebhooksHandler struct {
+	dispatcher *webhook.Dispatcher
+}
+
+func NewWebhooksHandler(dispatcher *webhook.Dispatcher) *WebhooksHandler {
+	return &WebhooksHandler{
+		dispatcher: dispatcher,
+	}
+}
+
+// WebhooksResponse - configured subscriptions, secrets aren't returned
+type WebhooksResponse struct {
+	Subscriptions []webhook.SubscriptionStatus `json:"subscriptions"`
+}
+
+func (h *WebhooksHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		zlog.Logger.Infof("Endpoint %s supports only GET method", endpoint.WebhooksEndpoint)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	writeJSON(w, WebhooksResponse{Subscriptions: h.dispatcher.Subscriptions()})
+}
+
+// HTTP handler for sending test payload to the webhook subscription,
+// the result of the request is returned with status 200 even if the receiver has failed
+// POST /api/v1/webhooks/{name}/test
+type WebhookTestHandler struct {
+	dispatcher *webhook.Dispatcher
+}
+
+func NewWebhookTestHandler(dispatcher *webhook.Dispatcher) *WebhookTestHandler {
+	return &WebhookTestHandler{
+		dispatcher: dispatcher,
+	}
+}
+
+func (h *WebhookTestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		zlog.Logger.Infof("Endpoint %s supports only POST method", endpoint.WebhookTestEndpoint)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	name := chi.URLParam(r, "name")
+
+	result, err := h.dispatcher.Test(r.Context(), name)
+	if err != nil {
+		if errors.Is(err, webhook.ErrUnknownSubscription) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		zlog.Logger.Errorf("Test webhook name=%s, err=%s", name, err)
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	zlog.Logger.Infof("Webhook name=%s is tested, result=%+v", name, result)
+
+	writeJSON(w, result)
+}
+
+func writeJSON(w http.ResponseWriter, data any) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		zlog.Logger.Errorf("Marshal response, err=%s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := w.Write(body); err != nil {
+		zlog.Logger.Errorf("Write data to response, err=%s", err)
+	}
+}
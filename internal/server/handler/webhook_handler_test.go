@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/kuzhukin/metrics-collector/internal/server/config"
+	"github.com/kuzhukin/metrics-collector/internal/server/webhook"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookHandlers(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		if !webhook.Verify("secret", body, r.Header.Get(webhook.SignatureHeader)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	dispatcher, err := webhook.StartNew(config.WebhooksConfig{
+		Subscriptions: []config.WebhookSubscription{
+			{Name: "heap", Match: "Heap*", Kind: "gauge", URL: receiver.URL, Secret: "secret"},
+			{Name: "unsigned", URL: receiver.URL, Secret: "other"},
+		},
+	})
+	require.NoError(t, err)
+	defer dispatcher.Stop()
+
+	router := chi.NewRouter()
+	router.Handle("/api/v1/webhooks", NewWebhooksHandler(dispatcher))
+	router.Handle("/api/v1/webhooks/{name}/test", NewWebhookTestHandler(dispatcher))
+
+	do := func(method string, target string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+
+		return w
+	}
+
+	w := do(http.MethodGet, "/api/v1/webhooks")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	require.NotContains(t, w.Body.String(), "secret")
+
+	list := WebhooksResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Subscriptions, 2)
+	require.Equal(t, webhook.SubscriptionStatus{Name: "heap", Match: "Heap*", Kind: "gauge", URL: receiver.URL}, list.Subscriptions[0])
+
+	w = do(http.MethodPost, "/api/v1/webhooks/heap/test")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	result := webhook.TestResult{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	require.True(t, result.OK)
+	require.Equal(t, http.StatusNoContent, result.Status)
+
+	w = do(http.MethodPost, "/api/v1/webhooks/unsigned/test")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	result = webhook.TestResult{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	require.False(t, result.OK)
+	require.Equal(t, http.StatusUnauthorized, result.Status)
+
+	require.Equal(t, http.StatusNotFound, do(http.MethodPost, "/api/v1/webhooks/unknown/test").Code)
+	require.Equal(t, http.StatusMethodNotAllowed, do(http.MethodGet, "/api/v1/webhooks/heap/test").Code)
+	require.Equal(t, http.StatusMethodNotAllowed, do(http.MethodPost, "/api/v1/webhooks").Code)
+}
@@ -0,0 +1,87 @@
+// package history - keeps recent values of metrics in memory for the dashboard
+package history
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+)
+
+// Point - accepted update of the metric, it contains delta of the update for counters
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+type seriesKey struct {
+	kind metric.Kind
+	id   string
+}
+
+// Recorder - records the last updates of metrics, the history is lost on restart,
+// metrics above the limit of series aren't recorded
+type Recorder struct {
+	sync.RWMutex
+
+	size      int
+	maxSeries int
+	series    map[seriesKey][]Point
+}
+
+// New - creates recorder which keeps size points of each of maxSeries metrics
+func New(size int, maxSeries int) *Recorder {
+	return &Recorder{
+		size:      size,
+		maxSeries: maxSeries,
+		series:    make(map[seriesKey][]Point),
+	}
+}
+
+// Publish - records updates of the metrics, it's called after applying of updates to the storage
+func (r *Recorder) Publish(metrics ...*metric.Metric) {
+	now := time.Now()
+
+	r.Lock()
+	defer r.Unlock()
+
+	for _, m := range metrics {
+		var value float64
+
+		switch {
+		case m.Type == metric.Gauge && m.Value != nil:
+			value = *m.Value
+		case m.Type == metric.Counter && m.Delta != nil:
+			value = float64(*m.Delta)
+		default:
+			continue
+		}
+
+		key := seriesKey{kind: m.Type, id: m.ID}
+
+		points, ok := r.series[key]
+		if !ok && len(r.series) >= r.maxSeries {
+			continue
+		}
+
+		points = append(points, Point{Time: now, Value: value})
+		if len(points) > r.size {
+			points = points[len(points)-r.size:]
+		}
+
+		r.series[key] = points
+	}
+}
+
+// Points - returns recorded points of the metric from the oldest to the newest
+func (r *Recorder) Points(kind metric.Kind, id string) []Point {
+	r.RLock()
+	defer r.RUnlock()
+
+	points := r.series[seriesKey{kind: kind, id: id}]
+
+	copied := make([]Point, len(points))
+	copy(copied, points)
+
+	return copied
+}
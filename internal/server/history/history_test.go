@@ -0,0 +1,37 @@
+package history
+
+import (
+	"testing"
+
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	r := New(3, 2)
+
+	for i := 1; i <= 5; i++ {
+		value := float64(i)
+		delta := int64(i * 10)
+
+		r.Publish(
+			&metric.Metric{ID: "Alloc", Type: metric.Gauge, Value: &value},
+			&metric.Metric{ID: "PollCount", Type: metric.Counter, Delta: &delta},
+			&metric.Metric{ID: "HeapAlloc", Type: metric.Gauge, Value: &value},
+		)
+	}
+
+	values := func(points []Point) []float64 {
+		result := make([]float64, 0, len(points))
+		for _, p := range points {
+			result = append(result, p.Value)
+		}
+
+		return result
+	}
+
+	require.Equal(t, []float64{3, 4, 5}, values(r.Points(metric.Gauge, "Alloc")))
+	require.Equal(t, []float64{30, 40, 50}, values(r.Points(metric.Counter, "PollCount")))
+	require.Empty(t, r.Points(metric.Gauge, "HeapAlloc"))
+	require.Empty(t, r.Points(metric.Counter, "Alloc"))
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// newMultiplexHandler - routes gRPC requests to the gRPC server and other requests to the HTTP router,
+// gRPC requests are HTTP/2 requests with application/grpc content type, so the router middlewares don't apply to them.
+// HTTP/2 is negotiated by ALPN over TLS, plaintext connections are upgraded to HTTP/2 by h2c
+func newMultiplexHandler(grpcHandler http.Handler, httpHandler http.Handler, useTLS bool) http.Handler {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			grpcHandler.ServeHTTP(w, r)
+			return
+		}
+
+		httpHandler.ServeHTTP(w, r)
+	})
+
+	if useTLS {
+		return handler
+	}
+
+	return h2c.NewHandler(handler, &http2.Server{})
+}
@@ -15,12 +15,13 @@ type BatchRequestParser struct {
 }
 
 // BatchParse provides a mock function with given fields: r
-func (_m *BatchRequestParser) BatchParse(r *http.Request) ([]*metric.Metric, error) {
+func (_m *BatchRequestParser) BatchParse(r *http.Request) ([]*metric.Metric, []metric.Rejection, error) {
 	ret := _m.Called(r)
 
 	var r0 []*metric.Metric
-	var r1 error
-	if rf, ok := ret.Get(0).(func(*http.Request) ([]*metric.Metric, error)); ok {
+	var r1 []metric.Rejection
+	var r2 error
+	if rf, ok := ret.Get(0).(func(*http.Request) ([]*metric.Metric, []metric.Rejection, error)); ok {
 		return rf(r)
 	}
 	if rf, ok := ret.Get(0).(func(*http.Request) []*metric.Metric); ok {
@@ -31,13 +32,21 @@ func (_m *BatchRequestParser) BatchParse(r *http.Request) ([]*metric.Metric, err
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(*http.Request) error); ok {
+	if rf, ok := ret.Get(1).(func(*http.Request) []metric.Rejection); ok {
 		r1 = rf(r)
 	} else {
-		r1 = ret.Error(1)
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).([]metric.Rejection)
+		}
+	}
+
+	if rf, ok := ret.Get(2).(func(*http.Request) error); ok {
+		r2 = rf(r)
+	} else {
+		r2 = ret.Error(2)
 	}
 
-	return r0, r1
+	return r0, r1, r2
 }
 
 // NewBatchRequestParser creates a new instance of BatchRequestParser. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
@@ -11,6 +11,8 @@ import (
 
 var ErrMetricNameIsNotFound error = errors.New("metric name isn't found")
 var ErrBadMetricKind error = errors.New("bad metric kind")
+var ErrMetricValueIsNotFound error = errors.New("metric value isn't found")
+var ErrBadMetricName error = errors.New("bad metric name")
 
 //go:generate mockery --name=RequestParser --filename=parser.go --outpkg=mockparser --output=mockparser
 type RequestParser interface {
@@ -19,5 +21,6 @@ type RequestParser interface {
 
 //go:generate mockery --name=BatchRequestParser --filename=batch_parser.go --outpkg=mockparser --output=mockparser
 type BatchRequestParser interface {
-	BatchParse(r *http.Request) ([]*metric.Metric, error)
+	// returns valid metrics and rejections of invalid ones, error is returned if the batch can't be read
+	BatchParse(r *http.Request) ([]*metric.Metric, []metric.Rejection, error)
 }
@@ -7,6 +7,7 @@ import (
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/kuzhukin/metrics-collector/internal/instance"
 	"github.com/kuzhukin/metrics-collector/internal/metric"
 	"github.com/kuzhukin/metrics-collector/internal/server/codec"
 )
@@ -30,12 +31,12 @@ func (p *parserImpl) Parse(r *http.Request) (*metric.Metric, error) {
 	}
 }
 
-func (p *parserImpl) BatchParse(r *http.Request) ([]*metric.Metric, error) {
+func (p *parserImpl) BatchParse(r *http.Request) ([]*metric.Metric, []metric.Rejection, error) {
 	switch r.Header.Get("content-type") {
 	case "application/json":
 		return parseBatchMetricByJSONBody(r)
 	default:
-		return nil, errors.New("incompatible content type for batch request")
+		return nil, nil, errors.New("incompatible content type for batch request")
 	}
 }
 
@@ -86,32 +87,76 @@ func parseMetricByJSONBody(r *http.Request) (*metric.Metric, error) {
 	return metric, nil
 }
 
-func parseBatchMetricByJSONBody(r *http.Request) ([]*metric.Metric, error) {
+func parseBatchMetricByJSONBody(r *http.Request) ([]*metric.Metric, []metric.Rejection, error) {
 	data, err := io.ReadAll(r.Body)
 	if err != nil {
-		return nil, fmt.Errorf("error reading body from request, err=%w", err)
+		return nil, nil, fmt.Errorf("error reading body from request, err=%w", err)
 	}
 
 	batchMetric := metric.NewBatch()
 	if err = batchMetric.Deserialize(data); err != nil {
-		return nil, fmt.Errorf("batch metric desirialization err=%w", err)
+		return nil, nil, fmt.Errorf("batch metric desirialization err=%w", err)
 	}
 
 	metrics := make([]*metric.Metric, 0, batchMetric.Len())
-	err = batchMetric.Foreach(func(nextMetric *metric.Metric) error {
-		m, internalErr := parseMetricByJSONBodyImpl(nextMetric)
-		if internalErr != nil {
-			return fmt.Errorf("parse metric err=%w", err)
+	rejected := make([]metric.Rejection, 0)
+
+	// invalid metrics are collected instead of failing of the whole batch
+	_ = batchMetric.Foreach(func(nextMetric *metric.Metric) error {
+		index := len(metrics) + len(rejected)
+
+		m, err := parseBatchMetric(nextMetric)
+		if err != nil {
+			rejected = append(rejected, makeRejection(index, nextMetric, err))
+			return nil
 		}
 
 		metrics = append(metrics, m)
+
 		return nil
 	})
-	if err != nil {
-		return nil, fmt.Errorf("parse batch data=%v, err=%w", data, err)
+
+	return metrics, rejected, nil
+}
+
+// metrics of the batch are updated, so they should have values unlike metrics of value requests
+func parseBatchMetric(m *metric.Metric) (*metric.Metric, error) {
+	if m == nil {
+		return nil, ErrMetricNameIsNotFound
+	}
+
+	if err := checkName(m.ID); err != nil {
+		return nil, err
+	}
+
+	if err := checkKind(m.Type); err != nil {
+		return nil, fmt.Errorf("metric=%s, kind=%s, err=%w", m.ID, m.Type, err)
 	}
 
-	return metrics, nil
+	if (m.Type == metric.Gauge && m.Value == nil) || (m.Type == metric.Counter && m.Delta == nil) {
+		return nil, fmt.Errorf("metric=%s, kind=%s, err=%w", m.ID, m.Type, ErrMetricValueIsNotFound)
+	}
+
+	return m, nil
+}
+
+func makeRejection(index int, m *metric.Metric, err error) metric.Rejection {
+	rejection := metric.Rejection{Index: index, Message: err.Error()}
+
+	if m != nil {
+		rejection.ID = m.ID
+	}
+
+	switch {
+	case errors.Is(err, ErrBadMetricKind):
+		rejection.Reason = metric.RejectUnknownKind
+	case errors.Is(err, ErrMetricValueIsNotFound):
+		rejection.Reason = metric.RejectMissingValue
+	default:
+		rejection.Reason = metric.RejectBadName
+	}
+
+	return rejection
 }
 
 func parseMetricByJSONBodyImpl(metric *metric.Metric) (*metric.Metric, error) {
@@ -131,6 +176,10 @@ func checkName(name string) error {
 		return ErrMetricNameIsNotFound
 	}
 
+	if err := instance.ValidateMetricID(name); err != nil {
+		return fmt.Errorf("%w, err=%w", ErrBadMetricName, err)
+	}
+
 	return nil
 }
 
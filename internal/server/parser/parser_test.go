@@ -3,6 +3,7 @@ package parser
 import (
 	"context"
 	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/go-chi/chi/v5"
@@ -130,3 +131,55 @@ func prepareRequest(t *testing.T, params map[string]string) *http.Request {
 
 	return r.WithContext(ctx)
 }
+
+func TestBatchParse(t *testing.T) {
+	body := `[
+		{"id":"Alloc","type":"gauge","value":1.5},
+		{"id":"","type":"gauge","value":1},
+		{"id":"Hist","type":"histogram","value":1},
+		{"id":"PollCount","type":"counter","value":1},
+		null,
+		{"id":"PollCount","type":"counter","delta":2},
+		{"id":"hostA/Alloc","type":"gauge","value":1}
+	]`
+
+	r, err := http.NewRequest(http.MethodPost, endpoint.BatchUpdateEndpointJSON, strings.NewReader(body))
+	require.NoError(t, err)
+	r.Header.Set("Content-Type", "application/json")
+
+	metrics, rejected, err := New().BatchParse(r)
+	require.NoError(t, err)
+
+	require.Len(t, metrics, 2)
+	require.Equal(t, "Alloc", metrics[0].ID)
+	require.Equal(t, "PollCount", metrics[1].ID)
+
+	require.Len(t, rejected, 5)
+
+	expected := []struct {
+		index  int
+		id     string
+		reason metric.RejectReason
+	}{
+		{1, "", metric.RejectBadName},
+		{2, "Hist", metric.RejectUnknownKind},
+		{3, "PollCount", metric.RejectMissingValue},
+		{4, "", metric.RejectBadName},
+		// the client can't write into the namespace of another instance
+		{6, "hostA/Alloc", metric.RejectBadName},
+	}
+
+	for i, e := range expected {
+		require.Equal(t, e.index, rejected[i].Index)
+		require.Equal(t, e.id, rejected[i].ID)
+		require.Equal(t, e.reason, rejected[i].Reason)
+		require.NotEmpty(t, rejected[i].Message)
+	}
+
+	r, err = http.NewRequest(http.MethodPost, endpoint.BatchUpdateEndpointJSON, strings.NewReader("{"))
+	require.NoError(t, err)
+	r.Header.Set("Content-Type", "application/json")
+
+	_, _, err = New().BatchParse(r)
+	require.Error(t, err)
+}
@@ -3,30 +3,86 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/netip"
+	"sync"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/kuzhukin/metrics-collector/internal/server/broadcaster"
 	"github.com/kuzhukin/metrics-collector/internal/server/config"
+	"github.com/kuzhukin/metrics-collector/internal/server/dashboard"
+	"github.com/kuzhukin/metrics-collector/internal/server/dedupe"
 	"github.com/kuzhukin/metrics-collector/internal/server/endpoint"
 	"github.com/kuzhukin/metrics-collector/internal/server/handler"
 	"github.com/kuzhukin/metrics-collector/internal/server/handler/middleware"
+	"github.com/kuzhukin/metrics-collector/internal/server/history"
 	"github.com/kuzhukin/metrics-collector/internal/server/parser"
 	"github.com/kuzhukin/metrics-collector/internal/server/storage"
 	"github.com/kuzhukin/metrics-collector/internal/server/storage/dbstorage"
 	"github.com/kuzhukin/metrics-collector/internal/server/storage/filestorage"
 	"github.com/kuzhukin/metrics-collector/internal/server/storage/memorystorage"
+	"github.com/kuzhukin/metrics-collector/internal/server/storage/notifystorage"
+	"github.com/kuzhukin/metrics-collector/internal/server/webhook"
 	"github.com/kuzhukin/metrics-collector/internal/zlog"
 )
 
+// deadline of graceful shutdown of listeners, active requests are interrupted after it
+const shutdownTimeout = time.Second * 10
+
+// count of recent updates of each metric and max count of metrics which are shown on the dashboard's sparklines
+const (
+	historySize      = 60
+	historyMaxSeries = 10000
+)
+
+// count of recently applied idempotency keys and time during which retries of batches aren't applied again
+const (
+	dedupeSize = 100000
+	dedupeTTL  = time.Minute * 10
+)
+
+// interval of heartbeat events in streams of metric updates, they keep idle connections alive through proxies
+const streamHeartbeatInterval = time.Second * 15
+
+// storage backends release their resources by Stop
+type stopper interface {
+	Stop() error
+}
+
 type MetricServer struct {
 	// HTTP server and router
 	srvr http.Server
-	// GRPC server
+	// GRPC server, it's nil if grpc is disabled
 	grpc *GRPCMetricServer
-	// channel for waiting of server shutdown
-	wait chan struct{}
+	// listening address of GRPC server
+	grpcHostport string
+	// GRPC server is served on the HTTP listener
+	singlePort bool
+	// paths to TLS certificate and key, listeners use TLS if they are set
+	tlsCert string
+	tlsKey  string
+	// storage backend which is stopped after listeners
+	storage stopper
+	// sender of webhooks, it's stopped after listeners, so updates of finished requests are queued
+	webhooks *webhook.Dispatcher
+
+	// addresses of started listeners
+	httpAddr net.Addr
+	grpcAddr net.Addr
+
+	// running listeners
+	listeners sync.WaitGroup
+	// the first error of listeners
+	err      error
+	errOnce  sync.Once
+	stopOnce sync.Once
+	stopErr  error
+	// receives the first error of listeners and is closed after shutdown of the server
+	wait chan error
 }
 
 // StartNew - creates and starts HTTP server
@@ -41,17 +97,18 @@ func StartNew() (*MetricServer, error) {
 		return nil, fmt.Errorf("create server, err=%w", err)
 	}
 
-	server.startHTTPServer()
+	server.start()
 
 	zlog.Logger.Infof("Server started config=%+v", config)
 
 	return server, nil
 }
 
-func createServer(config *config.Config) (*MetricServer, error) {
-	var err error
+func createServer(config *config.Config) (_ *MetricServer, err error) {
 	var storage storage.Storage
 	var dbStorage *dbstorage.DBStorage
+	var backend stopper
+	var backendName string
 
 	if config.Storage.DatabaseDSN != "" {
 		dbStorage, err = dbstorage.StartNew(config.Storage.DatabaseDSN)
@@ -60,22 +117,85 @@ func createServer(config *config.Config) (*MetricServer, error) {
 		}
 
 		storage = dbStorage
+		backend = dbStorage
+		backendName = "db"
 	} else if config.Storage.FilePath != "" {
-		storage, err = filestorage.New(config.Storage)
+		fileStorage, err := filestorage.New(config.Storage)
 		if err != nil {
 			return nil, fmt.Errorf("new file storage, err=%w", err)
 		}
+
+		storage = fileStorage
+		backend = fileStorage
+		backendName = "file"
 	} else {
-		storage = memorystorage.New()
+		memoryStorage := memorystorage.New()
+
+		storage = memoryStorage
+		backend = memoryStorage
+		backendName = "memory"
+	}
+
+	var webhooks *webhook.Dispatcher
+
+	// started parts are stopped if the server can't be created, otherwise they are stopped by the server
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		if webhooks != nil {
+			webhooks.Stop()
+		}
+
+		if stopErr := backend.Stop(); stopErr != nil {
+			zlog.Logger.Errorf("Stop storage, err=%s", stopErr)
+		}
+	}()
+
+	webhooks, err = webhook.StartNew(config.Webhooks)
+	if err != nil {
+		return nil, fmt.Errorf("start webhooks, err=%w", err)
+	}
+
+	// updates are published for grpc watchers and the dashboard regardless of the storage backend
+	metricsBroadcaster := broadcaster.New()
+	metricsHistory := history.New(historySize, historyMaxSeries)
+	publishers := []notifystorage.Publisher{metricsBroadcaster, metricsHistory}
+
+	if len(config.Webhooks.Subscriptions) != 0 {
+		publishers = append(publishers, webhooks)
 	}
 
+	storage = notifystorage.New(storage, publishers...)
+
+	// applied idempotency keys are shared between server instances using the same database,
+	// the key is saved in the transaction of the batch, so concurrent retries are applied once
+	var keyStore dedupe.Store
+	if dbStorage != nil {
+		keyStore = dbStorage
+	}
+
+	dedupeCache := dedupe.New(dedupeSize, dedupeTTL, keyStore)
+
 	requestsParser := parser.New()
 
-	listHandler := handler.NewGetListHandler(storage)
+	listHandler := handler.NewGetListHandler(storage, metricsHistory)
 	updateHandler := handler.NewUpdateHandler(storage, requestsParser)
 	valueHandler := handler.NewValueHandler(storage, requestsParser)
 	pingHandler := handler.NewPingHandler(dbStorage)
-	batchUpdateHandler := handler.NewBatchUpdateHandler(storage, requestsParser)
+	batchUpdateHandler := handler.NewBatchUpdateHandler(storage, requestsParser, dedupeCache)
+	queryHandler := handler.NewQueryHandler(storage)
+	deleteHandler := handler.NewDeleteHandler(storage, requestsParser)
+	deleteMatchingHandler := handler.NewDeleteMatchingHandler(storage)
+	resetHandler := handler.NewResetHandler(storage)
+	exportHandler := handler.NewExportHandler(storage)
+	importHandler := handler.NewImportHandler(storage)
+	backupHandler := handler.NewBackupHandler(storage, backendName)
+	restoreHandler := handler.NewRestoreHandler(storage)
+	streamHandler := handler.NewStreamHandler(metricsBroadcaster, streamHeartbeatInterval)
+	webhooksHandler := handler.NewWebhooksHandler(webhooks)
+	webhookTestHandler := handler.NewWebhookTestHandler(webhooks)
 
 	router := chi.NewRouter()
 
@@ -108,56 +228,172 @@ func createServer(config *config.Config) (*MetricServer, error) {
 	router.Use(middleware.CompressingHTTPHandler)
 
 	router.Handle(endpoint.RootEndpoint, listHandler)
+	router.Handle(endpoint.StaticEndpoint, dashboard.StaticHandler(endpoint.StaticPrefix))
 	router.Handle(endpoint.UpdateEndpoint, updateHandler)
 	router.Handle(endpoint.UpdateEndpointJSON, updateHandler)
 	router.Handle(endpoint.ValueEndpoint, valueHandler)
 	router.Handle(endpoint.ValueEndpointJSON, valueHandler)
 	router.Handle(endpoint.PingEndpoint, pingHandler)
 	router.Handle(endpoint.BatchUpdateEndpointJSON, batchUpdateHandler)
+	router.Handle(endpoint.MetricsQueryEndpoint, queryHandler)
+	router.Handle(endpoint.ExportEndpoint, exportHandler)
+	router.Handle(endpoint.ImportEndpoint, importHandler)
+	router.Handle(endpoint.StreamEndpoint, streamHandler)
+
+	// admin methods replace handlers of the same routes registered above
+	admin := router.With(middleware.NewAdminAuthHandler(config.AdminToken))
+	admin.Delete(endpoint.ValueEndpoint, deleteHandler.ServeHTTP)
+	admin.Delete(endpoint.MetricsQueryEndpoint, deleteMatchingHandler.ServeHTTP)
+	admin.Post(endpoint.ResetCounterEndpoint, resetHandler.ServeHTTP)
+	admin.Get(endpoint.BackupEndpoint, backupHandler.ServeHTTP)
+	admin.Post(endpoint.RestoreEndpoint, restoreHandler.ServeHTTP)
+	admin.Get(endpoint.WebhooksEndpoint, webhooksHandler.ServeHTTP)
+	admin.Post(endpoint.WebhookTestEndpoint, webhookTestHandler.ServeHTTP)
 
 	metricServer := &MetricServer{
 		srvr: http.Server{
 			Addr:    config.Hostport,
 			Handler: router,
 		},
-		wait: make(chan struct{}),
+		grpcHostport: config.GRPCHostPort,
+		singlePort:   config.SinglePort,
+		tlsCert:      config.TLSCert,
+		tlsKey:       config.TLSKey,
+		storage:      backend,
+		webhooks:     webhooks,
+		wait:         make(chan error, 1),
 	}
 
-	if config.UseGRPC {
-		grpcServer, err := NewGrpcServer(storage, config)
+	// streams of updates are finished on shutdown, otherwise they keep their connections active until the deadline
+	metricServer.srvr.RegisterOnShutdown(metricsBroadcaster.Close)
+
+	if config.UseGRPC || config.SinglePort {
+		grpcServer, err := NewGrpcServer(storage, dbStorage, metricsBroadcaster, dedupeCache, config)
 		if err != nil {
 			return nil, fmt.Errorf("new grpc server err %w", err)
 		}
 
 		metricServer.grpc = grpcServer
+	}
 
+	if config.SinglePort {
+		metricServer.srvr.Handler = newMultiplexHandler(metricServer.grpc.HTTPHandler(), router, config.TLSCert != "")
 	}
 
 	return metricServer, nil
 }
 
-func (s *MetricServer) startHTTPServer() {
+// starts listeners, the server is stopped if any of them fails
+func (s *MetricServer) start() {
+	httpListener, err := net.Listen("tcp", s.srvr.Addr)
+	if err != nil {
+		s.fail(fmt.Errorf("http listen address=%s, err=%w", s.srvr.Addr, err))
+		return
+	}
+
+	s.httpAddr = httpListener.Addr()
+	s.serve("http", func() error {
+		var err error
+
+		// HTTP/2 is enabled by ALPN for TLS listener
+		if s.tlsCert != "" {
+			err = s.srvr.ServeTLS(httpListener, s.tlsCert, s.tlsKey)
+		} else {
+			err = s.srvr.Serve(httpListener)
+		}
+
+		if !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+
+		return nil
+	})
+
+	if s.grpc == nil || s.singlePort {
+		return
+	}
+
+	grpcListener, err := net.Listen("tcp", s.grpcHostport)
+	if err != nil {
+		s.fail(fmt.Errorf("grpc listen address=%s, err=%w", s.grpcHostport, err))
+		return
+	}
+
+	s.grpcAddr = grpcListener.Addr()
+	s.serve("grpc", func() error {
+		return s.grpc.Serve(grpcListener)
+	})
+}
+
+func (s *MetricServer) serve(name string, fn func() error) {
+	s.listeners.Add(1)
+
 	go func() {
-		defer close(s.wait)
+		defer s.listeners.Done()
 
-		if err := s.srvr.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			zlog.Logger.Errorf("Http listen and serve, address=%s, err=%s\n", s.srvr.Addr, err)
+		if err := fn(); err != nil {
+			zlog.Logger.Errorf("%s serve err=%s", name, err)
+			s.fail(fmt.Errorf("%s serve err=%w", name, err))
 		}
 	}()
 }
 
-// Stop server
+// stops the server after the first error of listeners
+func (s *MetricServer) fail(err error) {
+	s.errOnce.Do(func() {
+		s.err = err
+	})
+
+	go func() {
+		_ = s.Stop()
+	}()
+}
+
+// Stop - shutdowns listeners gracefully with deadline and stops the storage
 func (s *MetricServer) Stop() error {
-	zlog.Logger.Infof("Server stopped")
+	s.stopOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		var joinedErr error
+
+		// grpc.Server.GracefulStop doesn't support calls served by HTTP server in single-port mode,
+		// so they are finished by HTTP server shutdown and the rest of them are interrupted after it
+		if s.grpc != nil && s.singlePort {
+			s.grpc.Shutdown()
+		} else if s.grpc != nil {
+			s.grpc.GracefulStop(ctx)
+		}
 
-	if s.grpc != nil {
-		s.grpc.Stop()
-	}
+		if err := s.srvr.Shutdown(ctx); err != nil {
+			joinedErr = errors.Join(joinedErr, fmt.Errorf("http shutdown err=%w", err))
+		}
+
+		if s.grpc != nil && s.singlePort {
+			s.grpc.Stop()
+		}
+
+		s.listeners.Wait()
+		s.webhooks.Stop()
+
+		if err := s.storage.Stop(); err != nil {
+			joinedErr = errors.Join(joinedErr, fmt.Errorf("stop storage err=%w", err))
+		}
+
+		s.stopErr = joinedErr
+
+		zlog.Logger.Infof("Server stopped")
+
+		// errors after the shutdown aren't recorded
+		s.errOnce.Do(func() {})
+		s.wait <- s.err
+		close(s.wait)
+	})
 
-	return s.srvr.Shutdown(context.Background())
+	return s.stopErr
 }
 
-// Wait shutdown the server
-func (s *MetricServer) Wait() <-chan struct{} {
+// Wait - returns channel which receives the error of listeners (nil after Stop) and is closed after shutdown
+func (s *MetricServer) Wait() <-chan error {
 	return s.wait
 }
@@ -2,13 +2,33 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/kuzhukin/metrics-collector/internal/metric"
+	pb "github.com/kuzhukin/metrics-collector/internal/proto"
 	"github.com/kuzhukin/metrics-collector/internal/server/config"
+	"github.com/kuzhukin/metrics-collector/internal/server/webhook"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
 func TestServerRoutesWithParams(t *testing.T) {
@@ -164,3 +184,368 @@ func BenchmarkJSONRouter(b *testing.B) {
 // BenchmarkJSONRouter/GET_/counter-8                        221205              6259 ns/op            6523 B/op         26 allocs/op
 // PASS
 // ok      github.com/kuzhukin/metrics-collector/internal/server   12.964s
+
+func TestServerStartStop(t *testing.T) {
+	srvr, err := createServer(&config.Config{Hostport: "127.0.0.1:0", UseGRPC: true, GRPCHostPort: "127.0.0.1:0"})
+	require.NoError(t, err)
+
+	srvr.start()
+
+	resp, err := http.Post("http://"+srvr.httpAddr.String()+"/update/gauge/Alloc/1.5", "text/plain", nil)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	conn, err := grpc.Dial(srvr.grpcAddr.String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	got, err := pb.NewMetricsServiceClient(conn).Get(context.Background(), &pb.GetRequest{Type: "gauge", Id: "Alloc"})
+	require.NoError(t, err)
+	require.Equal(t, 1.5, got.Metric.Value)
+
+	require.NoError(t, srvr.Stop())
+	require.NoError(t, <-srvr.Wait())
+
+	_, err = http.Get("http://" + srvr.httpAddr.String() + "/")
+	require.Error(t, err)
+}
+
+func TestServerListenError(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer busy.Close()
+
+	srvr, err := createServer(&config.Config{Hostport: "127.0.0.1:0", UseGRPC: true, GRPCHostPort: busy.Addr().String()})
+	require.NoError(t, err)
+
+	srvr.start()
+
+	select {
+	case err := <-srvr.Wait():
+		require.ErrorContains(t, err, "grpc listen")
+	case <-time.After(time.Second * 5):
+		require.Fail(t, "server isn't stopped after listen error")
+	}
+
+	// the http listener is stopped with the grpc one
+	_, err = http.Get("http://" + srvr.httpAddr.String() + "/")
+	require.Error(t, err)
+}
+
+func TestServerCreateErrorStopsStartedParts(t *testing.T) {
+	cfg := &config.Config{
+		Hostport:  "127.0.0.1:0",
+		CryptoKey: filepath.Join(t.TempDir(), "missing.pem"),
+		Webhooks: config.WebhooksConfig{
+			QueuePath:     t.TempDir(),
+			Subscriptions: []config.WebhookSubscription{{Name: "all", URL: "http://localhost", Secret: "secret"}},
+		},
+	}
+
+	_, err := createServer(cfg)
+	require.Error(t, err)
+
+	// the queue of webhooks is released by the failed server
+	cfg.CryptoKey = ""
+
+	srvr, err := createServer(cfg)
+	require.NoError(t, err)
+
+	srvr.start()
+	require.NoError(t, srvr.Stop())
+}
+
+func TestServerSinglePort(t *testing.T) {
+	srvr, err := createServer(&config.Config{Hostport: "127.0.0.1:0", SinglePort: true})
+	require.NoError(t, err)
+
+	srvr.start()
+	defer srvr.Stop()
+
+	resp, err := http.Post("http://"+srvr.httpAddr.String()+"/update/gauge/Alloc/1.5", "text/plain", nil)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Nil(t, srvr.grpcAddr)
+
+	conn, err := grpc.Dial(srvr.httpAddr.String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	got, err := pb.NewMetricsServiceClient(conn).Get(context.Background(), &pb.GetRequest{Type: "gauge", Id: "Alloc"})
+	require.NoError(t, err)
+	require.Equal(t, 1.5, got.Metric.Value)
+
+	require.NoError(t, srvr.Stop())
+	require.NoError(t, <-srvr.Wait())
+}
+
+func TestServerSinglePortStopWithOpenStreams(t *testing.T) {
+	srvr, err := createServer(&config.Config{Hostport: "127.0.0.1:0", SinglePort: true})
+	require.NoError(t, err)
+
+	srvr.start()
+	defer srvr.Stop()
+
+	conn, err := grpc.Dial(srvr.httpAddr.String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	client := pb.NewMetricsServiceClient(conn)
+
+	watch, err := client.Watch(context.Background(), &pb.WatchRequest{})
+	require.NoError(t, err)
+
+	// the subscription is created asynchronously, so updates are sent until the first event is received
+	events := make(chan error, 1)
+	go func() {
+		for {
+			if _, err := watch.Recv(); err != nil {
+				events <- err
+				return
+			}
+
+			events <- nil
+		}
+	}()
+
+	require.Eventually(t, func() bool {
+		_, err := client.Update(context.Background(), &pb.UpdateRequest{Metric: &pb.Metric{Id: "Alloc", Type: "gauge", Value: 1}})
+		require.NoError(t, err)
+
+		select {
+		case err := <-events:
+			require.NoError(t, err)
+			return true
+		case <-time.After(time.Millisecond * 10):
+			return false
+		}
+	}, time.Second*5, time.Millisecond)
+
+	updates, err := client.StreamUpdates(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, updates.Send(&pb.Metric{Id: "PollCount", Type: "counter", Delta: 1}))
+
+	// the update is applied by the open stream
+	require.Eventually(t, func() bool {
+		_, err := client.Get(context.Background(), &pb.GetRequest{Type: "counter", Id: "PollCount"})
+		return err == nil
+	}, time.Second*5, time.Millisecond*10)
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- srvr.Stop()
+	}()
+
+	select {
+	case err := <-stopped:
+		require.NoError(t, err)
+	case <-time.After(shutdownTimeout + time.Second*5):
+		require.Fail(t, "server isn't stopped")
+	}
+
+	require.NoError(t, <-srvr.Wait())
+
+	// both streams are finished by the stop
+	for err := range events {
+		if err != nil {
+			break
+		}
+	}
+
+	_, err = updates.CloseAndRecv()
+	require.Error(t, err)
+}
+
+func TestServerSinglePortTLS(t *testing.T) {
+	certFile, keyFile, pool := writeTLSCertificate(t)
+
+	srvr, err := createServer(&config.Config{Hostport: "127.0.0.1:0", SinglePort: true, TLSCert: certFile, TLSKey: keyFile})
+	require.NoError(t, err)
+
+	srvr.start()
+	defer srvr.Stop()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}, ForceAttemptHTTP2: true}}
+
+	resp, err := client.Post("https://"+srvr.httpAddr.String()+"/update/counter/PollCount/3", "text/plain", nil)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, 2, resp.ProtoMajor)
+
+	creds := credentials.NewTLS(&tls.Config{RootCAs: pool})
+
+	conn, err := grpc.Dial(srvr.httpAddr.String(), grpc.WithTransportCredentials(creds))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	got, err := pb.NewMetricsServiceClient(conn).Get(context.Background(), &pb.GetRequest{Type: "counter", Id: "PollCount"})
+	require.NoError(t, err)
+	require.Equal(t, int64(3), got.Metric.Delta)
+
+	// plaintext gRPC isn't accepted by TLS listener
+	plainConn, err := grpc.Dial(srvr.httpAddr.String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer plainConn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err = pb.NewMetricsServiceClient(plainConn).Get(ctx, &pb.GetRequest{Type: "counter", Id: "PollCount"})
+	require.Error(t, err)
+
+	require.NoError(t, srvr.Stop())
+	require.NoError(t, <-srvr.Wait())
+}
+
+// writeTLSCertificate - writes self-signed certificate for 127.0.0.1, returns paths of the certificate and the key
+func writeTLSCertificate(t *testing.T) (string, string, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "metrics-collector"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return certFile, keyFile, pool
+}
+
+func TestServerAdminEndpoints(t *testing.T) {
+	srvr, err := createServer(&config.Config{AdminToken: "secret"})
+	require.NoError(t, err)
+
+	do := func(method string, target string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Content-Type", "text/plain")
+
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		wr := httptest.NewRecorder()
+		srvr.srvr.Handler.ServeHTTP(wr, req)
+
+		return wr
+	}
+
+	for _, target := range []string{"/update/gauge/HeapAloc/1", "/update/gauge/HeapAlloc/2", "/update/counter/PollCount/5"} {
+		require.Equal(t, http.StatusOK, do(http.MethodPost, target, "").Code)
+	}
+
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodDelete, "/value/gauge/HeapAloc", "").Code)
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodDelete, "/value/gauge/HeapAloc", "wrong").Code)
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/reset/counter/PollCount", "").Code)
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodDelete, "/api/v1/metrics?match=*", "").Code)
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/api/v1/backup", "").Code)
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/api/v1/restore", "").Code)
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/api/v1/webhooks", "").Code)
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/api/v1/webhooks/heap/test", "").Code)
+
+	require.Equal(t, http.StatusOK, do(http.MethodDelete, "/value/gauge/HeapAloc", "secret").Code)
+	require.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/value/gauge/HeapAloc", "secret").Code)
+	require.Equal(t, http.StatusNotFound, do(http.MethodGet, "/value/gauge/HeapAloc", "").Code)
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/value/gauge/HeapAlloc", "").Code)
+
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/reset/counter/PollCount", "secret").Code)
+	require.Equal(t, http.StatusNotFound, do(http.MethodPost, "/reset/counter/Unknown", "secret").Code)
+
+	resp := do(http.MethodGet, "/value/counter/PollCount", "")
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, "0", resp.Body.String())
+
+	require.Equal(t, http.StatusBadRequest, do(http.MethodDelete, "/api/v1/metrics", "secret").Code)
+
+	resp = do(http.MethodDelete, "/api/v1/metrics?match=Heap*", "secret")
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.JSONEq(t, `{"deleted": 1}`, resp.Body.String())
+
+	resp = do(http.MethodGet, "/api/v1/backup", "secret")
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, "application/gzip", resp.Header().Get("Content-Type"))
+
+	resp = do(http.MethodGet, "/api/v1/webhooks", "secret")
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.JSONEq(t, `{"subscriptions": []}`, resp.Body.String())
+	require.Equal(t, http.StatusNotFound, do(http.MethodPost, "/api/v1/webhooks/heap/test", "secret").Code)
+
+	// admin endpoints are forbidden without configured token
+	srvr, err = createServer(&config.Config{})
+	require.NoError(t, err)
+
+	require.Equal(t, http.StatusForbidden, do(http.MethodDelete, "/value/counter/PollCount", "").Code)
+}
+
+func TestServerWebhooks(t *testing.T) {
+	received := make(chan webhook.Payload, 10)
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.True(t, webhook.Verify("secret", body, r.Header.Get(webhook.SignatureHeader)))
+
+		payload := webhook.Payload{}
+		require.NoError(t, json.Unmarshal(body, &payload))
+		received <- payload
+	}))
+	defer receiver.Close()
+
+	srvr, err := createServer(&config.Config{
+		Hostport: "localhost:0",
+		Webhooks: config.WebhooksConfig{
+			QueuePath:     t.TempDir(),
+			Subscriptions: []config.WebhookSubscription{{Name: "polls", Match: "Poll*", URL: receiver.URL, Secret: "secret"}},
+		},
+	})
+	require.NoError(t, err)
+
+	srvr.start()
+	defer func() { require.NoError(t, srvr.Stop()) }()
+
+	for _, target := range []string{"/update/gauge/Alloc/1", "/update/counter/PollCount/5"} {
+		resp, err := http.Post("http://"+srvr.httpAddr.String()+target, "text/plain", nil)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	select {
+	case payload := <-received:
+		require.Equal(t, "polls", payload.Subscription)
+		require.Len(t, payload.Events, 1)
+		require.Equal(t, webhook.EventUpdate, payload.Events[0].Type)
+		require.Equal(t, "PollCount", payload.Events[0].Metric.ID)
+		require.Equal(t, int64(5), *payload.Events[0].Metric.Delta)
+	case <-time.After(time.Second * 5):
+		require.Fail(t, "webhook isn't received")
+	}
+}
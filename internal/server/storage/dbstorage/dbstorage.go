@@ -11,6 +11,7 @@ import (
 	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/kuzhukin/metrics-collector/internal/metric"
+	"github.com/kuzhukin/metrics-collector/internal/server/dedupe"
 	"github.com/kuzhukin/metrics-collector/internal/server/storage"
 	"github.com/kuzhukin/metrics-collector/internal/zlog"
 )
@@ -38,6 +39,7 @@ type DBStorage struct {
 }
 
 var _ storage.Storage = &DBStorage{}
+var _ dedupe.Store = &DBStorage{}
 
 func StartNew(dataSourceName string) (*DBStorage, error) {
 	db, err := sql.Open("pgx", dataSourceName)
@@ -61,7 +63,7 @@ func (s *DBStorage) createTables() error {
 		err = errors.Join(err, s.createTableForKind(kind))
 	}
 
-	return err
+	return errors.Join(err, s.createTable(createIdempotencyKeysTableQuery))
 }
 
 func (s *DBStorage) createTableForKind(kind metric.Kind) error {
@@ -70,15 +72,18 @@ func (s *DBStorage) createTableForKind(kind metric.Kind) error {
 		return fmt.Errorf("build create table query for kind=%v, err=%w", kind, err)
 	}
 
+	return s.createTable(query)
+}
+
+func (s *DBStorage) createTable(query string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), createTablesTimeout)
 	defer cancel()
 
-	_, err = s.db.ExecContext(ctx, query)
-	if err != nil {
+	if _, err := s.db.ExecContext(ctx, query); err != nil {
 		return fmt.Errorf("exec create table query, err=%w", err)
 	}
 
-	return err
+	return nil
 }
 
 func (s *DBStorage) Stop() error {
@@ -126,6 +131,119 @@ func (s *DBStorage) Update(ctx context.Context, m *metric.Metric) error {
 	return nil
 }
 
+func (s *DBStorage) Delete(ctx context.Context, kind metric.Kind, name string) error {
+	query, args, err := buildDeleteQuery(name, kind)
+	if err != nil {
+		return fmt.Errorf("build delete query, err=%w", err)
+	}
+
+	return s.execForMetric(ctx, name, query, args)
+}
+
+func (s *DBStorage) Reset(ctx context.Context, name string) error {
+	return s.execForMetric(ctx, name, resetCounterMetricQuery, []interface{}{name})
+}
+
+// executes query which changes the single metric, returns ErrUnknownMetric if the metric doesn't exist
+func (s *DBStorage) execForMetric(ctx context.Context, name string, query string, args []interface{}) error {
+	execFunc := func() (*sql.Result, error) {
+		ctx, cancel := context.WithTimeout(ctx, updateMetricTimeout)
+		defer cancel()
+
+		res, err := s.db.ExecContext(ctx, query, args...)
+		if err != nil {
+			return nil, fmt.Errorf("exec query for metric=%s, err=%w", name, err)
+		}
+
+		return &res, nil
+	}
+
+	res, err := doQuery(execFunc)
+	if err != nil {
+		return fmt.Errorf("do query, err=%w", err)
+	}
+
+	affected, err := (*res).RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get affected rows, err=%w", err)
+	}
+
+	if affected == 0 {
+		return fmt.Errorf("name=%s, err=%w", name, storage.ErrUnknownMetric)
+	}
+
+	return nil
+}
+
+// DeleteMatching - deletes metrics of all matched kinds in the single transaction
+func (s *DBStorage) DeleteMatching(ctx context.Context, q storage.Query) (int, error) {
+	if err := q.Validate(); err != nil {
+		return 0, err
+	}
+
+	kinds := compatibleMetricKinds
+	if q.Kind != "" {
+		kinds = []metric.Kind{q.Kind}
+	}
+
+	deleteFunc := func() (*int64, error) {
+		deleted, err := s.deleteMatching(ctx, &q, kinds)
+		if err != nil {
+			return nil, err
+		}
+
+		return &deleted, nil
+	}
+
+	deleted, err := doQuery(deleteFunc)
+	if err != nil {
+		return 0, fmt.Errorf("do query, err=%w", err)
+	}
+
+	return int(*deleted), nil
+}
+
+func (s *DBStorage) deleteMatching(ctx context.Context, q *storage.Query, kinds []metric.Kind) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, updateAllMetricTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin tx, err=%w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	deleted := int64(0)
+
+	for _, kind := range kinds {
+		query, args, err := buildDeleteMatchingQuery(q, kind)
+		if err != nil {
+			return 0, fmt.Errorf("build delete query for kind=%s, err=%w", kind, err)
+		}
+
+		res, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return 0, fmt.Errorf("exec delete for kind=%s, err=%w", kind, err)
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("get affected rows, err=%w", err)
+		}
+
+		deleted += affected
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit tx, err=%w", err)
+	}
+
+	return deleted, nil
+}
+
+// BatchUpdate - applies metrics in one transaction, the idempotency key of the context is saved in it too
 func (s *DBStorage) BatchUpdate(ctx context.Context, metrics []*metric.Metric) error {
 	groupedMetrics := groupMetricsByKind(metrics)
 
@@ -138,7 +256,32 @@ func (s *DBStorage) BatchUpdate(ctx context.Context, metrics []*metric.Metric) e
 	}
 
 	if _, err := doQuery(query); err != nil {
-		return fmt.Errorf("do query, error")
+		return fmt.Errorf("do query, err=%w", err)
+	}
+
+	return nil
+}
+
+// saves the idempotency key of the context in the transaction of the batch,
+// returns dedupe.ErrApplied if the key is saved by another request
+func claimKey(ctx context.Context, tx *sql.Tx) error {
+	claim, ok := dedupe.ClaimFromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	res, err := tx.ExecContext(ctx, claimIdempotencyKeyQuery, claim.Key, claim.ExpireAt, claim.At)
+	if err != nil {
+		return fmt.Errorf("claim idempotency key, err=%w", err)
+	}
+
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected, err=%w", err)
+	}
+
+	if inserted == 0 {
+		return dedupe.ErrApplied
 	}
 
 	return nil
@@ -156,6 +299,10 @@ func (s *DBStorage) updateMetrics(ctx context.Context, metricsByKind map[metric.
 		_ = tx.Rollback()
 	}()
 
+	if err := claimKey(ctx, tx); err != nil {
+		return err
+	}
+
 	statements := make([]*sql.Stmt, 0)
 	defer func() {
 		for _, st := range statements {
@@ -249,34 +396,120 @@ func (s *DBStorage) Get(ctx context.Context, kind metric.Kind, name string) (*me
 	return nil, storage.ErrUnknownMetric
 }
 
+// List - metrics of both kinds are read by the single statement, so the list is consistent snapshot
 func (s *DBStorage) List(ctx context.Context) ([]*metric.Metric, error) {
-	acc := make([]*metric.Metric, 0)
+	return s.Query(ctx, storage.Query{})
+}
+
+// Restore - metrics are written in the single transaction, stored metrics are removed before it in replace mode
+func (s *DBStorage) Restore(ctx context.Context, metrics []*metric.Metric, mode storage.RestoreMode) error {
+	if mode != storage.RestoreMerge && mode != storage.RestoreReplace {
+		return fmt.Errorf("mode=%s, err=%w", mode, storage.ErrUnknownRestoreMode)
+	}
+
+	if err := storage.ValidateMetrics(metrics); err != nil {
+		return err
+	}
+
+	groupedMetrics := groupMetricsByKind(metrics)
+
+	restoreFunc := func() (*struct{}, error) {
+		if err := s.restoreMetrics(ctx, groupedMetrics, mode); err != nil {
+			return nil, fmt.Errorf("restore metrics, err=%w", err)
+		}
+
+		return nil, nil
+	}
+
+	if _, err := doQuery(restoreFunc); err != nil {
+		return fmt.Errorf("do query, err=%w", err)
+	}
+
+	return nil
+}
+
+func (s *DBStorage) restoreMetrics(ctx context.Context, metricsByKind map[metric.Kind][]*metric.Metric, mode storage.RestoreMode) error {
+	ctx, cancel := context.WithTimeout(ctx, updateAllMetricTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx, err=%w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
 
 	for _, kind := range compatibleMetricKinds {
-		metrics, err := s.getAll(ctx, kind)
+		if mode == storage.RestoreReplace {
+			query, _, err := buildDeleteMatchingQuery(&storage.Query{}, kind)
+			if err != nil {
+				return fmt.Errorf("build delete query for kind=%s, err=%w", kind, err)
+			}
+
+			if _, err := tx.ExecContext(ctx, query); err != nil {
+				return fmt.Errorf("exec delete for kind=%s, err=%w", kind, err)
+			}
+		}
+
+		if len(metricsByKind[kind]) == 0 {
+			continue
+		}
+
+		query, err := getRestoreQueryByKind(kind)
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("get restore query for kind=%s, err=%w", kind, err)
 		}
 
-		acc = append(acc, metrics...)
+		if err := execForAll(ctx, tx, query, metricsByKind[kind]); err != nil {
+			return err
+		}
 	}
 
-	return acc, nil
+	return tx.Commit()
 }
 
-func (s *DBStorage) getAll(ctx context.Context, kind metric.Kind) ([]*metric.Metric, error) {
-	query, err := buildGetAllQuery(kind)
+// executes the prepared statement with arguments of each metric
+func execForAll(ctx context.Context, tx *sql.Tx, query string, metrics []*metric.Metric) error {
+	stmt, err := tx.PrepareContext(ctx, query)
 	if err != nil {
-		return nil, fmt.Errorf("build get all query for kind=%v, err=%w", kind, err)
+		return fmt.Errorf("prepare query, err=%w", err)
 	}
+	defer stmt.Close()
 
-	queryFunc := func() (*sql.Rows, error) {
-		ctx, cancel := context.WithTimeout(ctx, getAllMetricsTimeout)
-		defer cancel()
+	for _, m := range metrics {
+		args, err := prepareArgsForUpdate(m)
+		if err != nil {
+			return fmt.Errorf("prepare args for metric name=%s, kind=%s, err=%w", m.ID, m.Type, err)
+		}
+
+		if _, err := stmt.ExecContext(ctx, args...); err != nil {
+			return fmt.Errorf("stmt exec, err=%w", err)
+		}
+	}
+
+	return nil
+}
+
+// Query - filters, sorts and pages metrics in the database
+func (s *DBStorage) Query(ctx context.Context, q storage.Query) ([]*metric.Metric, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	query, args, err := buildQueryMetricsQuery(&q)
+	if err != nil {
+		return nil, fmt.Errorf("build query, err=%w", err)
+	}
 
-		rows, err := s.db.QueryContext(ctx, query)
+	// rows are read after the query, so the context isn't canceled by the query function
+	ctx, cancel := context.WithTimeout(ctx, getAllMetricsTimeout)
+	defer cancel()
+
+	queryFunc := func() (*sql.Rows, error) {
+		rows, err := s.db.QueryContext(ctx, query, args...)
 		if err != nil {
-			return nil, fmt.Errorf("query all metrics with kind=%s, err=%w", kind, err)
+			return nil, fmt.Errorf("query metrics, err=%w", err)
 		}
 
 		return rows, nil
@@ -288,15 +521,10 @@ func (s *DBStorage) getAll(ctx context.Context, kind metric.Kind) ([]*metric.Met
 	}
 	defer rows.Close()
 
-	parser, err := makeParserForKind(kind)
-	if err != nil {
-		return nil, err
-	}
-
 	metrics := make([]*metric.Metric, 0)
 
 	for rows.Next() {
-		m, err := parser(rows)
+		m, err := parseMetric(rows)
 		if err != nil {
 			return nil, fmt.Errorf("parse rows, err=%w", err)
 		}
@@ -311,6 +539,28 @@ func (s *DBStorage) getAll(ctx context.Context, kind metric.Kind) ([]*metric.Met
 	return metrics, nil
 }
 
+func parseMetric(rows *sql.Rows) (*metric.Metric, error) {
+	var id, kind string
+	var delta sql.NullInt64
+	var value sql.NullFloat64
+
+	if err := rows.Scan(&id, &kind, &delta, &value); err != nil {
+		return nil, fmt.Errorf("scan metric row, err=%w", err)
+	}
+
+	m := &metric.Metric{ID: id, Type: metric.Kind(kind)}
+
+	if delta.Valid {
+		m.Delta = &delta.Int64
+	}
+
+	if value.Valid {
+		m.Value = &value.Float64
+	}
+
+	return m, nil
+}
+
 func makeParserForKind(kind metric.Kind) (func(rows *sql.Rows) (*metric.Metric, error), error) {
 	switch kind {
 	case metric.Gauge:
@@ -347,6 +597,53 @@ func parse[T int64 | float64](rows *sql.Rows) (string, T, error) {
 	return name, value, nil
 }
 
+// HasKey - checks that the idempotency key is saved and isn't expired at the time
+func (s *DBStorage) HasKey(ctx context.Context, key string, at time.Time) (bool, error) {
+	queryFunc := func() (*bool, error) {
+		ctx, cancel := context.WithTimeout(ctx, getMetricTimeout)
+		defer cancel()
+
+		exists := false
+		if err := s.db.QueryRowContext(ctx, hasIdempotencyKeyQuery, key, at).Scan(&exists); err != nil {
+			return nil, fmt.Errorf("query idempotency key, err=%w", err)
+		}
+
+		return &exists, nil
+	}
+
+	exists, err := doQuery(queryFunc)
+	if err != nil {
+		return false, fmt.Errorf("do query, err=%w", err)
+	}
+
+	return *exists, nil
+}
+
+// DeleteExpiredKeys - removes idempotency keys which are expired at the time
+func (s *DBStorage) DeleteExpiredKeys(ctx context.Context, at time.Time) error {
+	return s.exec(ctx, deleteExpiredIdempotencyKeysQuery, at)
+}
+
+func (s *DBStorage) exec(ctx context.Context, query string, args ...interface{}) error {
+	execFunc := func() (*sql.Result, error) {
+		ctx, cancel := context.WithTimeout(ctx, updateMetricTimeout)
+		defer cancel()
+
+		res, err := s.db.ExecContext(ctx, query, args...)
+		if err != nil {
+			return nil, fmt.Errorf("exec query, err=%w", err)
+		}
+
+		return &res, nil
+	}
+
+	if _, err := doQuery(execFunc); err != nil {
+		return fmt.Errorf("do query, err=%w", err)
+	}
+
+	return nil
+}
+
 func doQuery[T any](queryFunc func() (*T, error)) (*T, error) {
 	var commonErr error
 	max := len(tryingIntervals)
@@ -0,0 +1,63 @@
+package dbstorage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+	"github.com/kuzhukin/metrics-collector/internal/server/dedupe"
+	"github.com/kuzhukin/metrics-collector/internal/server/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// the database is used only if TEST_DATABASE_DSN is set
+func TestBatchUpdateOnceWithSharedKeys(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN isn't set")
+	}
+
+	db, err := StartNew(dsn)
+	require.NoError(t, err)
+	defer func() { _ = db.Stop() }()
+
+	ctx := context.Background()
+
+	_, err = db.DeleteMatching(ctx, storage.Query{})
+	require.NoError(t, err)
+
+	// caches of two servers using the same database
+	caches := []*dedupe.Cache{dedupe.New(10, time.Minute, db), dedupe.New(10, time.Minute, db)}
+	key := fmt.Sprintf("batch-%d", time.Now().UnixNano())
+
+	apply := func(ctx context.Context) error {
+		delta := int64(1)
+		return db.BatchUpdate(ctx, []*metric.Metric{{ID: "PollCount", Type: metric.Counter, Delta: &delta}})
+	}
+
+	replays := make([]bool, len(caches))
+
+	wg := sync.WaitGroup{}
+	for i, cache := range caches {
+		wg.Add(1)
+
+		go func(i int, cache *dedupe.Cache) {
+			defer wg.Done()
+
+			replayed, err := cache.Do(ctx, key, apply)
+			require.NoError(t, err)
+			replays[i] = replayed
+		}(i, cache)
+	}
+	wg.Wait()
+
+	require.ElementsMatch(t, []bool{false, true}, replays)
+
+	m, err := db.Get(ctx, metric.Counter, "PollCount")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), *m.Delta)
+}
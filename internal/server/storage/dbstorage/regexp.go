@@ -0,0 +1,148 @@
+package dbstorage
+
+import (
+	"errors"
+	"fmt"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// postgresRegexp - converts go regular expression to postgres one with the same matches.
+// Postgres expressions differ from go ones in escapes, classes and newline handling,
+// so the parsed expression is written again with explicit characters and classes.
+// The expression should be validated by storage.Query.Validate before converting
+func postgresRegexp(expr string) (string, error) {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return "", fmt.Errorf("parse regexp=%s, err=%w", expr, err)
+	}
+
+	var b strings.Builder
+
+	if err := writePostgresRegexp(&b, re); err != nil {
+		return "", fmt.Errorf("convert regexp=%s, err=%w", expr, err)
+	}
+
+	return b.String(), nil
+}
+
+func writePostgresRegexp(b *strings.Builder, re *syntax.Regexp) error {
+	switch re.Op {
+	case syntax.OpEmptyMatch:
+		b.WriteString(`(?:)`)
+	case syntax.OpLiteral:
+		for _, r := range re.Rune {
+			if re.Flags&syntax.FoldCase != 0 {
+				writeFoldedRune(b, r)
+			} else {
+				writeRune(b, r)
+			}
+		}
+	case syntax.OpCharClass:
+		if len(re.Rune) == 0 {
+			return errors.New("empty character class")
+		}
+
+		b.WriteByte('[')
+		for i := 0; i < len(re.Rune); i += 2 {
+			writeEscapedRune(b, re.Rune[i])
+			if re.Rune[i+1] != re.Rune[i] {
+				b.WriteByte('-')
+				writeEscapedRune(b, re.Rune[i+1])
+			}
+		}
+		b.WriteByte(']')
+	case syntax.OpAnyCharNotNL:
+		b.WriteString(`[^\u000A]`)
+	case syntax.OpAnyChar:
+		// dot matches newline in postgres by default
+		b.WriteByte('.')
+	case syntax.OpBeginText:
+		b.WriteByte('^')
+	case syntax.OpEndText:
+		b.WriteByte('$')
+	case syntax.OpCapture:
+		return writeGroup(b, re.Sub[0], "")
+	case syntax.OpStar:
+		return writeGroup(b, re.Sub[0], "*")
+	case syntax.OpPlus:
+		return writeGroup(b, re.Sub[0], "+")
+	case syntax.OpQuest:
+		return writeGroup(b, re.Sub[0], "?")
+	case syntax.OpRepeat:
+		repeat := "{" + strconv.Itoa(re.Min)
+		switch {
+		case re.Max < 0:
+			repeat += ",}"
+		case re.Max != re.Min:
+			repeat += "," + strconv.Itoa(re.Max) + "}"
+		default:
+			repeat += "}"
+		}
+
+		return writeGroup(b, re.Sub[0], repeat)
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if err := writePostgresRegexp(b, sub); err != nil {
+				return err
+			}
+		}
+	case syntax.OpAlternate:
+		b.WriteString(`(?:`)
+		for i, sub := range re.Sub {
+			if i > 0 {
+				b.WriteByte('|')
+			}
+
+			if err := writePostgresRegexp(b, sub); err != nil {
+				return err
+			}
+		}
+		b.WriteByte(')')
+	default:
+		return fmt.Errorf("unsupported operator=%s", re.Op)
+	}
+
+	return nil
+}
+
+// greediness of repetitions doesn't change whether the id matches, so all of them are greedy
+func writeGroup(b *strings.Builder, sub *syntax.Regexp, suffix string) error {
+	b.WriteString(`(?:`)
+	if err := writePostgresRegexp(b, sub); err != nil {
+		return err
+	}
+	b.WriteString(`)` + suffix)
+
+	return nil
+}
+
+func writeFoldedRune(b *strings.Builder, r rune) {
+	b.WriteByte('[')
+	writeEscapedRune(b, r)
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		writeEscapedRune(b, f)
+	}
+	b.WriteByte(']')
+}
+
+func writeRune(b *strings.Builder, r rune) {
+	if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+		b.WriteRune(r)
+		return
+	}
+
+	writeEscapedRune(b, r)
+}
+
+// escapes have fixed length, so following digits aren't a part of them
+func writeEscapedRune(b *strings.Builder, r rune) {
+	if r > 0xFFFF {
+		fmt.Fprintf(b, `\U%08X`, r)
+		return
+	}
+
+	fmt.Fprintf(b, `\u%04X`, r)
+}
@@ -0,0 +1,115 @@
+package dbstorage
+
+import (
+	"context"
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+	"github.com/kuzhukin/metrics-collector/internal/server/storage"
+	"github.com/kuzhukin/metrics-collector/internal/server/storage/memorystorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostgresRegexp(t *testing.T) {
+	testCases := []struct {
+		expr string
+		want string
+	}{
+		{`Alloc$`, `Alloc$`},
+		{`^Heap(Alloc|Idle)`, `^Heap(?:(?:Alloc|Idle))`},
+		{`\d+`, `(?:[\u0030-\u0039])+`},
+		{`a.b`, `a[^\u000A]b`},
+		{`(?s)a.b`, `a.b`},
+		{`(?i)k`, `[\u004B\u006B\u212A]`},
+		{`x{2,3}y{2,}z{2}`, `(?:x){2,3}(?:y){2,}(?:z){2}`},
+		{`a\.b\\`, `a\u002Eb\u005C`},
+		{`[^a]`, `[\u0000-\u0060\u0062-\U0010FFFF]`},
+	}
+
+	for _, tc := range testCases {
+		got, err := postgresRegexp(tc.expr)
+		require.NoError(t, err, tc.expr)
+		require.Equal(t, tc.want, got, tc.expr)
+	}
+
+	_, err := postgresRegexp(`\bAlloc`)
+	require.Error(t, err)
+}
+
+// postgres escapes of characters are written in go syntax, so converted expressions can be checked by go
+var postgresEscape = regexp.MustCompile(`\\[uU]([0-9A-F]+)`)
+
+func TestPostgresRegexpMatches(t *testing.T) {
+	ids := []string{"Alloc", "HeapAlloc", "heapalloc", "Heap.Alloc", "Heap\nAlloc", "PollCount", "k", "K", "go_gc_pauses_seconds_p99", "x1", "x12", "x123", ""}
+
+	for _, expr := range []string{`Alloc$`, `^Heap.Alloc`, `(?i)heap`, `(?s)p.*A`, `x\d{2}`, `x\d{2}$`, `[^a-z]`, `^$`, `_p(50|99)$`, `[[:upper:]]{2,}`, `\pL\.`} {
+		converted, err := postgresRegexp(expr)
+		require.NoError(t, err, expr)
+
+		// dot of postgres expressions matches newline
+		goExpr := `(?s)` + postgresEscape.ReplaceAllString(converted, `\x{$1}`)
+
+		want := regexp.MustCompile(expr)
+		got := regexp.MustCompile(goExpr)
+
+		for _, id := range ids {
+			require.Equal(t, want.MatchString(id), got.MatchString(id), "expr=%s, converted=%s, id=%q", expr, converted, id)
+		}
+	}
+}
+
+// the database is used only if TEST_DATABASE_DSN is set
+func TestQueryMatchesMemoryStorage(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN isn't set")
+	}
+
+	db, err := StartNew(dsn)
+	require.NoError(t, err)
+	defer func() { _ = db.Stop() }()
+
+	ctx := context.Background()
+
+	_, err = db.DeleteMatching(ctx, storage.Query{})
+	require.NoError(t, err)
+
+	mem := memorystorage.New()
+
+	for _, id := range []string{"Alloc", "HeapAlloc", "heapalloc", "Heap.Alloc", "Heap\nAlloc", "PollCount", "K", "host-1/HeapAlloc", "x12", "x123"} {
+		value := float64(len(id))
+		m := &metric.Metric{ID: id, Type: metric.Gauge, Value: &value}
+
+		require.NoError(t, db.Update(ctx, m))
+		require.NoError(t, mem.Update(ctx, m))
+	}
+
+	for _, q := range []storage.Query{
+		{Regexp: `Alloc$`},
+		{Regexp: `^Heap.Alloc`},
+		{Regexp: `(?i)heap`},
+		{Regexp: `(?s)p.*A`},
+		{Regexp: `x\d{2}$`},
+		{Regexp: `\w+\.`},
+		{Prefix: "host-1/", Regexp: `^Heap`},
+	} {
+		want, err := mem.Query(ctx, q)
+		require.NoError(t, err)
+
+		got, err := db.Query(ctx, q)
+		require.NoError(t, err)
+
+		require.Equal(t, metricIDs(want), metricIDs(got), "regexp=%s", q.Regexp)
+	}
+}
+
+func metricIDs(metrics []*metric.Metric) []string {
+	ids := make([]string, 0, len(metrics))
+	for _, m := range metrics {
+		ids = append(ids, m.ID)
+	}
+
+	return ids
+}
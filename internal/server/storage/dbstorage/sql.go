@@ -1,6 +1,11 @@
 package dbstorage
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
 	"github.com/kuzhukin/metrics-collector/internal/metric"
 	"github.com/kuzhukin/metrics-collector/internal/server/storage"
 )
@@ -26,6 +31,20 @@ func buildCreateMetricsTableQuery(kind metric.Kind) (string, error) {
 	}
 }
 
+// keys of applied batches for deduplication of retries
+const createIdempotencyKeysTableQuery = `CREATE TABLE IF NOT EXISTS idempotency_keys (
+	key text PRIMARY KEY,
+	expire_at timestamptz NOT NULL
+);`
+
+const hasIdempotencyKeyQuery = `SELECT EXISTS (SELECT 1 FROM idempotency_keys WHERE key = $1 AND expire_at > $2);`
+
+// nothing is inserted if the key is saved and isn't expired, so the batch is applied once by all servers
+const claimIdempotencyKeyQuery = `INSERT INTO idempotency_keys (key, expire_at) VALUES ($1, $2) ` +
+	`ON CONFLICT (key) DO UPDATE SET expire_at = excluded.expire_at WHERE idempotency_keys.expire_at <= $3;`
+
+const deleteExpiredIdempotencyKeysQuery = `DELETE FROM idempotency_keys WHERE expire_at <= $1;`
+
 const updateGaugeMetricQuery = `INSERT INTO gauge_metrics (id, value) VALUES ($1, $2) ` +
 	`ON CONFLICT (id) DO UPDATE SET value = excluded.value;`
 
@@ -65,6 +84,21 @@ func prepareArgsForUpdate(m *metric.Metric) ([]interface{}, error) {
 	}
 }
 
+// values of restored counters replace stored ones
+const restoreCounterMetricQuery = `INSERT INTO counter_metrics (id, value) VALUES ($1, $2) ` +
+	`ON CONFLICT (id) DO UPDATE SET value = excluded.value;`
+
+func getRestoreQueryByKind(k metric.Kind) (string, error) {
+	switch k {
+	case metric.Gauge:
+		return updateGaugeMetricQuery, nil
+	case metric.Counter:
+		return restoreCounterMetricQuery, nil
+	default:
+		return "", storage.ErrUnknownKind
+	}
+}
+
 const getGaugeMetricQuery = `SELECT id, value FROM gauge_metrics WHERE id = $1;`
 const getCounterMetricQuery = `SELECT id, value FROM counter_metrics WHERE id = $1;`
 
@@ -79,16 +113,152 @@ func buildGetQuery(id string, kind metric.Kind) (string, []interface{}, error) {
 	}
 }
 
-const getAllGaugeMetricsQuery = `SELECT * FROM gauge_metrics;`
-const getAllCounterMetricsQuery = `SELECT * FROM counter_metrics;`
+// metrics of both kinds with the same columns, delta or value is NULL according to the kind
+const queryMetricsSource = `SELECT id, kind, delta, value FROM (` +
+	`SELECT id, 'gauge' AS kind, NULL::bigint AS delta, value FROM gauge_metrics ` +
+	`UNION ALL ` +
+	`SELECT id, 'counter' AS kind, value AS delta, NULL::double precision AS value FROM counter_metrics` +
+	`) AS metrics`
+
+// ids are compared bytewise like in the memory storage
+const (
+	orderByName = `id COLLATE "C", kind`
+	orderByKind = `kind, id COLLATE "C"`
+)
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// placeholders of arguments are numbered in order of adding
+type queryArgs []interface{}
+
+func (a *queryArgs) add(value interface{}) string {
+	*a = append(*a, value)
+	return "$" + strconv.Itoa(len(*a))
+}
+
+// returns conditions of ids by the prefix and patterns of the query
+func buildIDConditions(q *storage.Query, args *queryArgs) ([]string, error) {
+	conditions := make([]string, 0)
+
+	if q.Prefix != "" || q.Match != "" {
+		pattern := likeEscaper.Replace(q.Prefix) + globToLike(q.Match)
+		conditions = append(conditions, `id LIKE `+args.add(pattern)+` ESCAPE '\'`)
+	}
+
+	if q.Regexp != "" {
+		re, err := postgresRegexp(q.Regexp)
+		if err != nil {
+			return nil, fmt.Errorf("%s, err=%w", err, storage.ErrBadQuery)
+		}
+
+		start := utf8.RuneCountInString(q.Prefix) + 1
+		conditions = append(conditions, `substr(id, `+args.add(start)+`) ~ `+args.add(re))
+	}
+
+	return conditions, nil
+}
+
+func buildQueryMetricsQuery(q *storage.Query) (string, []interface{}, error) {
+	args := make(queryArgs, 0)
+	arg := args.add
+
+	conditions, err := buildIDConditions(q, &args)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if q.Kind != "" {
+		conditions = append(conditions, `kind = `+arg(string(q.Kind)))
+	}
+
+	order := orderByName
+	if q.Sort == storage.SortByKind {
+		order = orderByKind
+	}
+
+	if q.After != nil {
+		if q.Sort == storage.SortByKind {
+			conditions = append(conditions, `(kind, id COLLATE "C") > (`+arg(string(q.After.Type))+`, `+arg(q.After.ID)+`)`)
+		} else {
+			conditions = append(conditions, `(id COLLATE "C", kind) > (`+arg(q.After.ID)+`, `+arg(string(q.After.Type))+`)`)
+		}
+	}
+
+	query := queryMetricsSource
+
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, ` AND `)
+	}
+
+	query += ` ORDER BY ` + order
+
+	if q.Limit > 0 {
+		query += ` LIMIT ` + arg(q.Limit)
+	}
+
+	return query + `;`, args, nil
+}
+
+const deleteGaugeMetricQuery = `DELETE FROM gauge_metrics WHERE id = $1;`
+const deleteCounterMetricQuery = `DELETE FROM counter_metrics WHERE id = $1;`
+
+func buildDeleteQuery(id string, kind metric.Kind) (string, []interface{}, error) {
+	switch kind {
+	case metric.Gauge:
+		return deleteGaugeMetricQuery, []interface{}{id}, nil
+	case metric.Counter:
+		return deleteCounterMetricQuery, []interface{}{id}, nil
+	default:
+		return "", nil, storage.ErrUnknownKind
+	}
+}
+
+func buildDeleteMatchingQuery(q *storage.Query, kind metric.Kind) (string, []interface{}, error) {
+	var query string
 
-func buildGetAllQuery(kind metric.Kind) (string, error) {
 	switch kind {
 	case metric.Gauge:
-		return getAllGaugeMetricsQuery, nil
+		query = `DELETE FROM gauge_metrics`
 	case metric.Counter:
-		return getAllCounterMetricsQuery, nil
+		query = `DELETE FROM counter_metrics`
 	default:
-		return "", storage.ErrUnknownKind
+		return "", nil, storage.ErrUnknownKind
+	}
+
+	args := make(queryArgs, 0)
+
+	conditions, err := buildIDConditions(q, &args)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, ` AND `)
 	}
+
+	return query + `;`, args, nil
+}
+
+const resetCounterMetricQuery = `UPDATE counter_metrics SET value = 0 WHERE id = $1;`
+
+// converts glob pattern to LIKE pattern, empty pattern matches all
+func globToLike(glob string) string {
+	if glob == "" {
+		return "%"
+	}
+
+	var b strings.Builder
+
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString("%")
+		case '?':
+			b.WriteString("_")
+		default:
+			b.WriteString(likeEscaper.Replace(string(r)))
+		}
+	}
+
+	return b.String()
 }
@@ -0,0 +1,51 @@
+package dbstorage
+
+import (
+	"testing"
+
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+	"github.com/kuzhukin/metrics-collector/internal/server/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildQueryMetricsQuery(t *testing.T) {
+	query, args, err := buildQueryMetricsQuery(&storage.Query{})
+	require.NoError(t, err)
+	require.Equal(t, queryMetricsSource+` ORDER BY `+orderByName+`;`, query)
+	require.Empty(t, args)
+
+	query, args, err = buildQueryMetricsQuery(&storage.Query{
+		Prefix: "host_1/",
+		Match:  "Heap*",
+		Regexp: "Alloc$",
+		Kind:   metric.Gauge,
+		Sort:   storage.SortByKind,
+		Limit:  10,
+		After:  &metric.Metric{ID: "host_1/HeapAlloc", Type: metric.Gauge},
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, queryMetricsSource+
+		` WHERE id LIKE $1 ESCAPE '\'`+
+		` AND substr(id, $2) ~ $3`+
+		` AND kind = $4`+
+		` AND (kind, id COLLATE "C") > ($5, $6)`+
+		` ORDER BY `+orderByKind+
+		` LIMIT $7;`, query)
+	require.Equal(t, []interface{}{`host\_1/Heap%`, 8, "Alloc$", "gauge", "gauge", "host_1/HeapAlloc", 10}, args)
+}
+
+func TestGlobToLike(t *testing.T) {
+	require.Equal(t, "%", globToLike(""))
+	require.Equal(t, `Heap%\_\%_`, globToLike("Heap*_%?"))
+}
+
+func TestBuildDeleteMatchingQuery(t *testing.T) {
+	query, args, err := buildDeleteMatchingQuery(&storage.Query{Prefix: "host-1/", Match: "Heap*"}, metric.Counter)
+	require.NoError(t, err)
+	require.Equal(t, `DELETE FROM counter_metrics WHERE id LIKE $1 ESCAPE '\';`, query)
+	require.Equal(t, []interface{}{`host-1/Heap%`}, args)
+
+	_, _, err = buildDeleteMatchingQuery(&storage.Query{}, "histogram")
+	require.ErrorIs(t, err, storage.ErrUnknownKind)
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/kuzhukin/metrics-collector/internal/metric"
@@ -22,6 +23,10 @@ type FileStorage struct {
 
 	filepath string
 	interval time.Duration
+	// concurrent syncs are serialized, so the file isn't replaced by an older snapshot
+	syncLock sync.Mutex
+	// stops the syncer
+	done chan struct{}
 }
 
 func New(config config.StorageConfig) (*FileStorage, error) {
@@ -33,6 +38,7 @@ func New(config config.StorageConfig) (*FileStorage, error) {
 
 		filepath: config.FilePath,
 		interval: time.Second * time.Duration(config.Interval),
+		done:     make(chan struct{}),
 	}
 
 	if config.Restore {
@@ -76,6 +82,44 @@ func (s *FileStorage) Get(ctx context.Context, kind metric.Kind, name string) (*
 	return s.memoryStorage.Get(ctx, kind, name)
 }
 
+func (s *FileStorage) Query(ctx context.Context, q storage.Query) ([]*metric.Metric, error) {
+	return s.memoryStorage.Query(ctx, q)
+}
+
+// Delete - removes the metric and stores metrics to the file immediately, so deletion isn't lost on restart
+func (s *FileStorage) Delete(ctx context.Context, kind metric.Kind, name string) error {
+	if err := s.memoryStorage.Delete(ctx, kind, name); err != nil {
+		return err
+	}
+
+	return s.sync()
+}
+
+func (s *FileStorage) DeleteMatching(ctx context.Context, q storage.Query) (int, error) {
+	deleted, err := s.memoryStorage.DeleteMatching(ctx, q)
+	if err != nil || deleted == 0 {
+		return deleted, err
+	}
+
+	return deleted, s.sync()
+}
+
+func (s *FileStorage) Reset(ctx context.Context, name string) error {
+	if err := s.memoryStorage.Reset(ctx, name); err != nil {
+		return err
+	}
+
+	return s.sync()
+}
+
+func (s *FileStorage) Restore(ctx context.Context, metrics []*metric.Metric, mode storage.RestoreMode) error {
+	if err := s.memoryStorage.Restore(ctx, metrics, mode); err != nil {
+		return err
+	}
+
+	return s.sync()
+}
+
 func (s *FileStorage) List(ctx context.Context) ([]*metric.Metric, error) {
 	return s.memoryStorage.List(ctx)
 }
@@ -86,9 +130,13 @@ func (s *FileStorage) startSyncer() {
 		defer sync.Stop()
 
 		for {
-			<-sync.C
-			if err := s.sync(); err != nil {
-				zlog.Logger.Errorf("sync metrics err=%w", err)
+			select {
+			case <-sync.C:
+				if err := s.sync(); err != nil {
+					zlog.Logger.Errorf("sync metrics err=%w", err)
+				}
+			case <-s.done:
+				return
 			}
 		}
 	}()
@@ -119,15 +167,25 @@ func (s *FileStorage) restore() error {
 	return nil
 }
 
+// the file is replaced atomically, so it isn't left half-written if the server is crashed
 func (s *FileStorage) sync() error {
+	s.syncLock.Lock()
+	defer s.syncLock.Unlock()
+
 	data, err := s.serialize()
 	if err != nil {
 		return fmt.Errorf("serialize, err=%w", err)
 	}
 
-	err = os.WriteFile(s.filepath, data, 0644)
-	if err != nil {
-		return fmt.Errorf("write metrics to file=%s, err=%w", s.filepath, err)
+	temp := s.filepath + ".tmp"
+
+	if err := os.WriteFile(temp, data, 0644); err != nil {
+		return fmt.Errorf("write metrics to file=%s, err=%w", temp, err)
+	}
+
+	if err := os.Rename(temp, s.filepath); err != nil {
+		_ = os.Remove(temp)
+		return fmt.Errorf("rename metrics file=%s, err=%w", temp, err)
 	}
 
 	return nil
@@ -160,8 +218,11 @@ func (s *FileStorage) serialize() ([]byte, error) {
 	return data, nil
 }
 
+// Stop - stops the syncer and stores metrics to the file
 func (s *FileStorage) Stop() error {
-	return nil
+	close(s.done)
+
+	return s.sync()
 }
 
 func convertToTransportMetrics[T int64 | float64](metrics map[string]T, kind metric.Kind) ([]*metric.Metric, error) {
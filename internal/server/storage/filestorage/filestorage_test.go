@@ -0,0 +1,86 @@
+package filestorage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+	"github.com/kuzhukin/metrics-collector/internal/server/config"
+	"github.com/kuzhukin/metrics-collector/internal/server/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeletePersisted(t *testing.T) {
+	ctx := context.Background()
+	storageConfig := config.StorageConfig{FilePath: filepath.Join(t.TempDir(), "metrics.json"), Interval: 300, Restore: true}
+
+	s, err := New(storageConfig)
+	require.NoError(t, err)
+
+	value := 1.5
+	delta := int64(10)
+	require.NoError(t, s.BatchUpdate(ctx, []*metric.Metric{
+		{ID: "HeapAloc", Type: metric.Gauge, Value: &value},
+		{ID: "HeapAlloc", Type: metric.Gauge, Value: &value},
+		{ID: "PollCount", Type: metric.Counter, Delta: &delta},
+	}))
+
+	require.NoError(t, s.Delete(ctx, metric.Gauge, "HeapAloc"))
+	require.NoError(t, s.Reset(ctx, "PollCount"))
+
+	// changes are stored without waiting for the sync interval
+	restored, err := New(storageConfig)
+	require.NoError(t, err)
+
+	_, err = restored.Get(ctx, metric.Gauge, "HeapAloc")
+	require.ErrorIs(t, err, storage.ErrUnknownMetric)
+
+	counter, err := restored.Get(ctx, metric.Counter, "PollCount")
+	require.NoError(t, err)
+	require.Equal(t, int64(0), *counter.Delta)
+
+	require.NoError(t, s.Stop())
+	require.NoError(t, restored.Stop())
+}
+
+func TestConcurrentSyncs(t *testing.T) {
+	ctx := context.Background()
+	storageConfig := config.StorageConfig{FilePath: filepath.Join(t.TempDir(), "metrics.json"), Restore: true}
+
+	s, err := New(storageConfig)
+	require.NoError(t, err)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			value := float64(i)
+			id := fmt.Sprintf("Gauge%d", i)
+
+			require.NoError(t, s.Update(ctx, &metric.Metric{ID: id, Type: metric.Gauge, Value: &value}))
+			require.NoError(t, s.Delete(ctx, metric.Gauge, id))
+		}(i)
+	}
+	wg.Wait()
+
+	// the last written snapshot contains all deletions
+	restored, err := New(storageConfig)
+	require.NoError(t, err)
+
+	metrics, err := restored.List(ctx)
+	require.NoError(t, err)
+	require.Empty(t, metrics)
+
+	_, err = os.Stat(storageConfig.FilePath + ".tmp")
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	require.NoError(t, s.Stop())
+	require.NoError(t, restored.Stop())
+}
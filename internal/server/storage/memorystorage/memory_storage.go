@@ -69,17 +69,140 @@ func (s *MemoryStorage) Get(_ context.Context, kind metric.Kind, name string) (*
 	}
 }
 
+// List - both kinds are read under locks, so the list is consistent snapshot of the storage
 func (s *MemoryStorage) List(_ context.Context) ([]*metric.Metric, error) {
-	allGauges := s.GaugeMetrics.GetAll()
-	allCounters := s.CounterMetrics.GetAll()
+	s.GaugeMetrics.RLock()
+	defer s.GaugeMetrics.RUnlock()
 
-	list := make([]*metric.Metric, 0, len(allCounters)+len(allGauges))
-	list = addMetricsToList(allGauges, metric.Gauge, list)
-	list = addMetricsToList(allCounters, metric.Counter, list)
+	s.CounterMetrics.RLock()
+	defer s.CounterMetrics.RUnlock()
+
+	list := make([]*metric.Metric, 0, len(s.CounterMetrics.storage)+len(s.GaugeMetrics.storage))
+	list = addMetricsToList(s.GaugeMetrics.storage, metric.Gauge, list)
+	list = addMetricsToList(s.CounterMetrics.storage, metric.Counter, list)
 
 	return list, nil
 }
 
+// Query - filters metrics without copying of the storage, only the page of matched metrics is kept in memory
+func (s *MemoryStorage) Query(_ context.Context, q storage.Query) ([]*metric.Metric, error) {
+	match, err := q.Matcher()
+	if err != nil {
+		return nil, err
+	}
+
+	page := newPage(&q)
+
+	if q.Kind == "" || q.Kind == metric.Gauge {
+		s.GaugeMetrics.Range(func(id string, value float64) {
+			if match(id) {
+				page.add(&metric.Metric{ID: id, Type: metric.Gauge, Value: &value})
+			}
+		})
+	}
+
+	if q.Kind == "" || q.Kind == metric.Counter {
+		s.CounterMetrics.Range(func(id string, delta int64) {
+			if match(id) {
+				page.add(&metric.Metric{ID: id, Type: metric.Counter, Delta: &delta})
+			}
+		})
+	}
+
+	return page.result(), nil
+}
+
+func (s *MemoryStorage) Delete(_ context.Context, kind metric.Kind, name string) error {
+	var ok bool
+
+	switch kind {
+	case metric.Gauge:
+		ok = s.GaugeMetrics.Delete(name)
+	case metric.Counter:
+		ok = s.CounterMetrics.Delete(name)
+	default:
+		return storage.ErrUnknownKind
+	}
+
+	if !ok {
+		return fmt.Errorf("name=%s, err=%w", name, storage.ErrUnknownMetric)
+	}
+
+	return nil
+}
+
+func (s *MemoryStorage) DeleteMatching(_ context.Context, q storage.Query) (int, error) {
+	match, err := q.Matcher()
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+
+	if q.Kind == "" || q.Kind == metric.Gauge {
+		deleted += s.GaugeMetrics.DeleteFunc(match)
+	}
+
+	if q.Kind == "" || q.Kind == metric.Counter {
+		deleted += s.CounterMetrics.DeleteFunc(match)
+	}
+
+	return deleted, nil
+}
+
+func (s *MemoryStorage) Reset(_ context.Context, name string) error {
+	if !s.CounterMetrics.Replace(name, 0) {
+		return fmt.Errorf("name=%s, err=%w", name, storage.ErrUnknownMetric)
+	}
+
+	return nil
+}
+
+// Restore - both kinds are changed under locks, so readers of the list don't see partially restored state
+func (s *MemoryStorage) Restore(_ context.Context, metrics []*metric.Metric, mode storage.RestoreMode) error {
+	if mode != storage.RestoreMerge && mode != storage.RestoreReplace {
+		return fmt.Errorf("mode=%s, err=%w", mode, storage.ErrUnknownRestoreMode)
+	}
+
+	if err := storage.ValidateMetrics(metrics); err != nil {
+		return err
+	}
+
+	gauges := make(map[string]float64)
+	counters := make(map[string]int64)
+
+	for _, m := range metrics {
+		if m.Type == metric.Gauge {
+			gauges[m.ID] = *m.Value
+		} else {
+			counters[m.ID] = *m.Delta
+		}
+	}
+
+	s.GaugeMetrics.Lock()
+	defer s.GaugeMetrics.Unlock()
+
+	s.CounterMetrics.Lock()
+	defer s.CounterMetrics.Unlock()
+
+	if mode == storage.RestoreReplace {
+		s.GaugeMetrics.storage = gauges
+		s.CounterMetrics.storage = counters
+
+		return nil
+	}
+
+	for id, value := range gauges {
+		s.GaugeMetrics.storage[id] = value
+	}
+
+	for id, value := range counters {
+		s.CounterMetrics.storage[id] = value
+	}
+
+	return nil
+}
+
 func (s *MemoryStorage) Stop() error {
 	return nil
 }
@@ -0,0 +1,158 @@
+package memorystorage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+	"github.com/kuzhukin/metrics-collector/internal/server/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuery(t *testing.T) {
+	s := New()
+	ctx := context.Background()
+
+	s.GaugeMetrics.Write("HeapAlloc", 1)
+	s.GaugeMetrics.Write("HeapIdle", 2)
+	s.GaugeMetrics.Write("Alloc", 3)
+	s.GaugeMetrics.Write("host-1/HeapAlloc", 4)
+	s.CounterMetrics.Sum("HeapAlloc", 5)
+	s.CounterMetrics.Sum("PollCount", 6)
+
+	testCases := []struct {
+		name  string
+		query storage.Query
+		want  []string
+	}{
+		{"all", storage.Query{}, []string{"Alloc", "HeapAlloc", "HeapAlloc", "HeapIdle", "PollCount", "host-1/HeapAlloc"}},
+		{"glob", storage.Query{Match: "Heap*"}, []string{"HeapAlloc", "HeapAlloc", "HeapIdle"}},
+		{"glob single character", storage.Query{Match: "?lloc"}, []string{"Alloc"}},
+		{"regexp", storage.Query{Regexp: "Id|Count"}, []string{"HeapIdle", "PollCount"}},
+		{"kind", storage.Query{Match: "Heap*", Kind: metric.Counter}, []string{"HeapAlloc"}},
+		{"prefix", storage.Query{Prefix: "host-1/", Match: "Heap*"}, []string{"host-1/HeapAlloc"}},
+		{"sort by kind", storage.Query{Sort: storage.SortByKind, Limit: 3}, []string{"HeapAlloc", "PollCount", "Alloc"}},
+		{"after", storage.Query{After: &metric.Metric{ID: "HeapAlloc", Type: metric.Counter}, Limit: 2}, []string{"HeapAlloc", "HeapIdle"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			metrics, err := s.Query(ctx, tc.query)
+			require.NoError(t, err)
+
+			ids := make([]string, 0, len(metrics))
+			for _, m := range metrics {
+				ids = append(ids, m.ID)
+			}
+
+			require.Equal(t, tc.want, ids)
+		})
+	}
+
+	_, err := s.Query(ctx, storage.Query{Regexp: "("})
+	require.ErrorIs(t, err, storage.ErrBadQuery)
+
+	// syntax which isn't supported by the database storage is rejected by all storages
+	for _, re := range []string{`\bAlloc`, `(?m)^Alloc$`, `a{256}`} {
+		_, err = s.Query(ctx, storage.Query{Regexp: re})
+		require.ErrorIs(t, err, storage.ErrBadQuery, re)
+	}
+
+	_, err = s.Query(ctx, storage.Query{Kind: "histogram"})
+	require.ErrorIs(t, err, storage.ErrUnknownKind)
+}
+
+func TestQueryPages(t *testing.T) {
+	s := New()
+
+	for i := 0; i < 1000; i++ {
+		s.GaugeMetrics.Write(fmt.Sprintf("metric-%04d", i), float64(i))
+	}
+
+	var after *metric.Metric
+	count := 0
+
+	for {
+		page, err := s.Query(context.Background(), storage.Query{Limit: 30, After: after})
+		require.NoError(t, err)
+
+		if len(page) == 0 {
+			break
+		}
+
+		for _, m := range page {
+			require.Equal(t, fmt.Sprintf("metric-%04d", count), m.ID)
+			count++
+		}
+
+		after = page[len(page)-1]
+	}
+
+	require.Equal(t, 1000, count)
+}
+
+func TestDeleteAndReset(t *testing.T) {
+	s := New()
+	ctx := context.Background()
+
+	s.GaugeMetrics.Write("HeapAloc", 1)
+	s.GaugeMetrics.Write("HeapIdle", 2)
+	s.GaugeMetrics.Write("host-1/HeapIdle", 3)
+	s.CounterMetrics.Sum("PollCount", 10)
+	s.CounterMetrics.Sum("HeapObjects", 4)
+
+	require.NoError(t, s.Delete(ctx, metric.Gauge, "HeapAloc"))
+	require.ErrorIs(t, s.Delete(ctx, metric.Gauge, "HeapAloc"), storage.ErrUnknownMetric)
+	require.ErrorIs(t, s.Delete(ctx, metric.Counter, "HeapIdle"), storage.ErrUnknownMetric)
+	require.ErrorIs(t, s.Delete(ctx, "histogram", "HeapIdle"), storage.ErrUnknownKind)
+
+	deleted, err := s.DeleteMatching(ctx, storage.Query{Match: "Heap*"})
+	require.NoError(t, err)
+	require.Equal(t, 2, deleted)
+
+	_, ok := s.GaugeMetrics.Get("host-1/HeapIdle")
+	require.True(t, ok)
+
+	require.NoError(t, s.Reset(ctx, "PollCount"))
+	require.ErrorIs(t, s.Reset(ctx, "Unknown"), storage.ErrUnknownMetric)
+
+	counter, err := s.Get(ctx, metric.Counter, "PollCount")
+	require.NoError(t, err)
+	require.Equal(t, int64(0), *counter.Delta)
+}
+
+func TestRestore(t *testing.T) {
+	s := New()
+	ctx := context.Background()
+
+	s.GaugeMetrics.Write("Alloc", 1)
+	s.GaugeMetrics.Write("HeapIdle", 2)
+	s.CounterMetrics.Sum("PollCount", 10)
+
+	gauge := 5.0
+	delta := int64(3)
+	restored := []*metric.Metric{
+		{ID: "Alloc", Type: metric.Gauge, Value: &gauge},
+		{ID: "PollCount", Type: metric.Counter, Delta: &delta},
+	}
+
+	require.NoError(t, s.Restore(ctx, restored, storage.RestoreMerge))
+	require.Equal(t, map[string]float64{"Alloc": 5, "HeapIdle": 2}, s.GaugeMetrics.GetAll())
+	require.Equal(t, map[string]int64{"PollCount": 3}, s.CounterMetrics.GetAll())
+
+	require.NoError(t, s.Restore(ctx, restored[1:], storage.RestoreReplace))
+	require.Empty(t, s.GaugeMetrics.GetAll())
+	require.Equal(t, map[string]int64{"PollCount": 3}, s.CounterMetrics.GetAll())
+
+	unknown := []*metric.Metric{{ID: "Alloc", Type: "histogram"}}
+	require.ErrorIs(t, s.Restore(ctx, unknown, storage.RestoreReplace), storage.ErrUnknownKind)
+
+	// metrics without values are rejected before changing of the storage
+	for _, m := range []*metric.Metric{{ID: "Alloc", Type: metric.Gauge}, {ID: "PollCount", Type: metric.Counter}, {Type: metric.Gauge, Value: &gauge}} {
+		require.ErrorIs(t, s.Restore(ctx, []*metric.Metric{m}, storage.RestoreReplace), storage.ErrBadMetric)
+	}
+
+	require.ErrorIs(t, s.Restore(ctx, restored, "append"), storage.ErrUnknownRestoreMode)
+	require.Equal(t, map[string]int64{"PollCount": 3}, s.CounterMetrics.GetAll())
+}
@@ -0,0 +1,49 @@
+package memorystorage
+
+import (
+	"sort"
+
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+	"github.com/kuzhukin/metrics-collector/internal/server/storage"
+)
+
+// page - selects the first metrics in the order of the query which follow its cursor,
+// metrics are sorted and truncated when the buffer is twice as large as the limit
+type page struct {
+	query   *storage.Query
+	metrics []*metric.Metric
+}
+
+func newPage(q *storage.Query) *page {
+	return &page{query: q}
+}
+
+func (p *page) add(m *metric.Metric) {
+	if p.query.After != nil && !p.query.Less(p.query.After, m) {
+		return
+	}
+
+	p.metrics = append(p.metrics, m)
+
+	if p.query.Limit > 0 && len(p.metrics) >= 2*p.query.Limit {
+		p.truncate()
+	}
+}
+
+func (p *page) result() []*metric.Metric {
+	p.truncate()
+
+	if p.metrics == nil {
+		return []*metric.Metric{}
+	}
+
+	return p.metrics
+}
+
+func (p *page) truncate() {
+	sort.Slice(p.metrics, func(i, j int) bool { return p.query.Less(p.metrics[i], p.metrics[j]) })
+
+	if p.query.Limit > 0 && len(p.metrics) > p.query.Limit {
+		p.metrics = p.metrics[:p.query.Limit]
+	}
+}
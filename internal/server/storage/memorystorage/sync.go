@@ -49,6 +49,58 @@ func (s *SyncStorage[T]) GetAll() map[string]T {
 	return copy
 }
 
+// Replace - sets value of the existing key, returns false if the key doesn't exist
+func (s *SyncStorage[T]) Replace(k string, value T) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.storage[k]; !ok {
+		return false
+	}
+
+	s.storage[k] = value
+
+	return true
+}
+
+// Delete - returns false if the key doesn't exist
+func (s *SyncStorage[T]) Delete(k string) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	_, ok := s.storage[k]
+	delete(s.storage, k)
+
+	return ok
+}
+
+// DeleteFunc - deletes keys for which fn returns true, returns count of deleted keys
+func (s *SyncStorage[T]) DeleteFunc(fn func(k string) bool) int {
+	s.Lock()
+	defer s.Unlock()
+
+	deleted := 0
+
+	for k := range s.storage {
+		if fn(k) {
+			delete(s.storage, k)
+			deleted++
+		}
+	}
+
+	return deleted
+}
+
+// Range - calls fn for all values under read lock, so fn shouldn't block
+func (s *SyncStorage[T]) Range(fn func(k string, value T)) {
+	s.RLock()
+	defer s.RUnlock()
+
+	for k, v := range s.storage {
+		fn(k, v)
+	}
+}
+
 func (s *SyncStorage[T]) SetAll(m map[string]T) {
 	s.Lock()
 	defer s.Unlock()
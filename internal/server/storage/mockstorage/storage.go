@@ -7,6 +7,8 @@ import (
 
 	metric "github.com/kuzhukin/metrics-collector/internal/metric"
 	mock "github.com/stretchr/testify/mock"
+
+	storage "github.com/kuzhukin/metrics-collector/internal/server/storage"
 )
 
 // Storage is an autogenerated mock type for the Storage type
@@ -28,6 +30,44 @@ func (_m *Storage) BatchUpdate(ctx context.Context, m []*metric.Metric) error {
 	return r0
 }
 
+// Delete provides a mock function with given fields: ctx, kind, name
+func (_m *Storage) Delete(ctx context.Context, kind metric.Kind, name string) error {
+	ret := _m.Called(ctx, kind, name)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, metric.Kind, string) error); ok {
+		r0 = rf(ctx, kind, name)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteMatching provides a mock function with given fields: ctx, q
+func (_m *Storage) DeleteMatching(ctx context.Context, q storage.Query) (int, error) {
+	ret := _m.Called(ctx, q)
+
+	var r0 int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, storage.Query) (int, error)); ok {
+		return rf(ctx, q)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, storage.Query) int); ok {
+		r0 = rf(ctx, q)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, storage.Query) error); ok {
+		r1 = rf(ctx, q)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Get provides a mock function with given fields: ctx, kind, name
 func (_m *Storage) Get(ctx context.Context, kind metric.Kind, name string) (*metric.Metric, error) {
 	ret := _m.Called(ctx, kind, name)
@@ -80,6 +120,60 @@ func (_m *Storage) List(ctx context.Context) ([]*metric.Metric, error) {
 	return r0, r1
 }
 
+// Query provides a mock function with given fields: ctx, q
+func (_m *Storage) Query(ctx context.Context, q storage.Query) ([]*metric.Metric, error) {
+	ret := _m.Called(ctx, q)
+
+	var r0 []*metric.Metric
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, storage.Query) ([]*metric.Metric, error)); ok {
+		return rf(ctx, q)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, storage.Query) []*metric.Metric); ok {
+		r0 = rf(ctx, q)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*metric.Metric)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, storage.Query) error); ok {
+		r1 = rf(ctx, q)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Reset provides a mock function with given fields: ctx, name
+func (_m *Storage) Reset(ctx context.Context, name string) error {
+	ret := _m.Called(ctx, name)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, name)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Restore provides a mock function with given fields: ctx, metrics, mode
+func (_m *Storage) Restore(ctx context.Context, metrics []*metric.Metric, mode storage.RestoreMode) error {
+	ret := _m.Called(ctx, metrics, mode)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, []*metric.Metric, storage.RestoreMode) error); ok {
+		r0 = rf(ctx, metrics, mode)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Update provides a mock function with given fields: ctx, m
 func (_m *Storage) Update(ctx context.Context, m *metric.Metric) error {
 	ret := _m.Called(ctx, m)
@@ -0,0 +1,137 @@
+// package notifystorage - storage decorator which publishes accepted updates
+package notifystorage
+
+import (
+	"context"
+
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+	"github.com/kuzhukin/metrics-collector/internal/server/storage"
+)
+
+var _ storage.Storage = &NotifyStorage{}
+
+// Publisher - receives metrics which were stored successfully
+type Publisher interface {
+	Publish(metrics ...*metric.Metric)
+}
+
+// DeletePublisher - receives metrics which were deleted, publishers which don't implement it receive only updates
+type DeletePublisher interface {
+	PublishDeleted(metrics ...*metric.Metric)
+}
+
+// NotifyStorage - publishes updates and deletions after they are applied to the wrapped storage.
+// Reset and Restore aren't published: they set absolute values, but published updates are applied deltas,
+// so subscribers should resynchronize after administrative restores and resets
+type NotifyStorage struct {
+	storage.Storage
+	publishers []Publisher
+}
+
+func New(storage storage.Storage, publishers ...Publisher) *NotifyStorage {
+	return &NotifyStorage{Storage: storage, publishers: publishers}
+}
+
+func (s *NotifyStorage) Update(ctx context.Context, m *metric.Metric) error {
+	if err := s.Storage.Update(ctx, m); err != nil {
+		return err
+	}
+
+	s.publish(clone(m))
+
+	return nil
+}
+
+func (s *NotifyStorage) BatchUpdate(ctx context.Context, metrics []*metric.Metric) error {
+	if err := s.Storage.BatchUpdate(ctx, metrics); err != nil {
+		return err
+	}
+
+	cloned := make([]*metric.Metric, 0, len(metrics))
+	for _, m := range metrics {
+		cloned = append(cloned, clone(m))
+	}
+
+	s.publish(cloned...)
+
+	return nil
+}
+
+func (s *NotifyStorage) Delete(ctx context.Context, kind metric.Kind, name string) error {
+	if err := s.Storage.Delete(ctx, kind, name); err != nil {
+		return err
+	}
+
+	s.publishDeleted(&metric.Metric{ID: name, Type: kind})
+
+	return nil
+}
+
+// DeleteMatching - the storage doesn't return deleted metrics, so they are queried before deletion,
+// metrics which are created between the query and the deletion aren't published
+func (s *NotifyStorage) DeleteMatching(ctx context.Context, q storage.Query) (int, error) {
+	if !s.hasDeletePublishers() {
+		return s.Storage.DeleteMatching(ctx, q)
+	}
+
+	matched, err := s.Storage.Query(ctx, storage.Query{Prefix: q.Prefix, Match: q.Match, Regexp: q.Regexp, Kind: q.Kind})
+	if err != nil {
+		return 0, err
+	}
+
+	deleted, err := s.Storage.DeleteMatching(ctx, q)
+	if err != nil || deleted == 0 {
+		return deleted, err
+	}
+
+	keys := make([]*metric.Metric, 0, len(matched))
+	for _, m := range matched {
+		keys = append(keys, &metric.Metric{ID: m.ID, Type: m.Type})
+	}
+
+	s.publishDeleted(keys...)
+
+	return deleted, nil
+}
+
+// published metrics are shared between publishers, so they shouldn't be changed
+func (s *NotifyStorage) publish(metrics ...*metric.Metric) {
+	for _, p := range s.publishers {
+		p.Publish(metrics...)
+	}
+}
+
+func (s *NotifyStorage) publishDeleted(metrics ...*metric.Metric) {
+	for _, p := range s.publishers {
+		if deletePublisher, ok := p.(DeletePublisher); ok {
+			deletePublisher.PublishDeleted(metrics...)
+		}
+	}
+}
+
+func (s *NotifyStorage) hasDeletePublishers() bool {
+	for _, p := range s.publishers {
+		if _, ok := p.(DeletePublisher); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// published metrics are shared between subscribers, so they don't refer to values of the caller
+func clone(m *metric.Metric) *metric.Metric {
+	cloned := &metric.Metric{ID: m.ID, Type: m.Type}
+
+	if m.Delta != nil {
+		delta := *m.Delta
+		cloned.Delta = &delta
+	}
+
+	if m.Value != nil {
+		value := *m.Value
+		cloned.Value = &value
+	}
+
+	return cloned
+}
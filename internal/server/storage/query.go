@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+)
+
+var ErrBadQuery = errors.New("bad metrics query")
+
+// max count of repetitions in regular expressions of queries, databases don't support larger counts
+const maxRegexpRepeat = 255
+
+type SortOrder string
+
+const (
+	// metrics are sorted by id, then by kind, it's default order
+	SortByName SortOrder = "name"
+	// metrics are sorted by kind, then by id
+	SortByKind SortOrder = "kind"
+)
+
+// Query - filter, order and page of metrics, ids are compared bytewise
+type Query struct {
+	// literal prefix of ids, e.g. namespace of the instance
+	Prefix string
+	// glob pattern of the id after the prefix, * matches any sequence, ? matches single character
+	Match string
+	// regular expression which should be found in the id after the prefix,
+	// its syntax is limited to the subset which is supported by all storages
+	Regexp string
+	// only metrics of the kind are returned if it's set
+	Kind metric.Kind
+	Sort SortOrder
+	// max count of returned metrics, all matched metrics are returned if it isn't positive
+	Limit int
+	// key of the last metric of the previous page, only its id and type are used
+	After *metric.Metric
+}
+
+// Validate - checks the query before execution
+func (q *Query) Validate() error {
+	if q.Kind != "" && q.Kind != metric.Gauge && q.Kind != metric.Counter {
+		return fmt.Errorf("kind=%s, err=%w", q.Kind, ErrUnknownKind)
+	}
+
+	if q.Sort != "" && q.Sort != SortByName && q.Sort != SortByKind {
+		return fmt.Errorf("sort=%s, err=%w", q.Sort, ErrBadQuery)
+	}
+
+	if q.Regexp != "" {
+		re, err := syntax.Parse(q.Regexp, syntax.Perl)
+		if err != nil {
+			return fmt.Errorf("regexp=%s, err=%w", q.Regexp, errors.Join(ErrBadQuery, err))
+		}
+
+		if err := checkPortableRegexp(re); err != nil {
+			return fmt.Errorf("regexp=%s, err=%w", q.Regexp, err)
+		}
+	}
+
+	return nil
+}
+
+// line anchors and word boundaries have different meaning in databases, so they aren't supported
+func checkPortableRegexp(re *syntax.Regexp) error {
+	switch re.Op {
+	case syntax.OpBeginLine, syntax.OpEndLine:
+		return fmt.Errorf("multi-line mode isn't supported, err=%w", ErrBadQuery)
+	case syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return fmt.Errorf("word boundaries aren't supported, err=%w", ErrBadQuery)
+	case syntax.OpNoMatch:
+		return fmt.Errorf("expression doesn't match anything, err=%w", ErrBadQuery)
+	case syntax.OpRepeat:
+		if re.Min > maxRegexpRepeat || re.Max > maxRegexpRepeat {
+			return fmt.Errorf("repetition count is greater than %d, err=%w", maxRegexpRepeat, ErrBadQuery)
+		}
+	}
+
+	for _, sub := range re.Sub {
+		if err := checkPortableRegexp(sub); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Matcher - returns function which checks id of the metric by the prefix and patterns of the query
+func (q *Query) Matcher() (func(id string) bool, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	var glob, re *regexp.Regexp
+
+	if q.Match != "" {
+		glob = regexp.MustCompile(GlobToRegexp(q.Match))
+	}
+
+	if q.Regexp != "" {
+		re = regexp.MustCompile(q.Regexp)
+	}
+
+	return func(id string) bool {
+		rest, ok := strings.CutPrefix(id, q.Prefix)
+		if !ok {
+			return false
+		}
+
+		return (glob == nil || glob.MatchString(rest)) && (re == nil || re.MatchString(rest))
+	}, nil
+}
+
+// Less - compares keys of metrics in the order of the query
+func (q *Query) Less(a, b *metric.Metric) bool {
+	if q.Sort == SortByKind {
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+
+		return a.ID < b.ID
+	}
+
+	if a.ID != b.ID {
+		return a.ID < b.ID
+	}
+
+	return a.Type < b.Type
+}
+
+// GlobToRegexp - converts glob pattern to anchored regular expression
+func GlobToRegexp(glob string) string {
+	var b strings.Builder
+
+	b.WriteString(`^(?s)`)
+
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	b.WriteString(`$`)
+
+	return b.String()
+}
@@ -4,12 +4,24 @@ package storage
 import (
 	"context"
 	"errors"
+	"fmt"
 
 	"github.com/kuzhukin/metrics-collector/internal/metric"
 )
 
 var ErrUnknownMetric = errors.New("unknown metric name")
 var ErrUnknownKind = errors.New("unknown metric kind")
+var ErrUnknownRestoreMode = errors.New("unknown restore mode")
+var ErrBadMetric = errors.New("bad metric")
+
+type RestoreMode string
+
+const (
+	// restored metrics overwrite stored ones with the same ids, other metrics are kept
+	RestoreMerge RestoreMode = "merge"
+	// all stored metrics are replaced by restored ones
+	RestoreReplace RestoreMode = "replace"
+)
 
 //go:generate mockery --name=Storage --filename=storage.go --outpkg=mockstorage --output=mockstorage
 type Storage interface {
@@ -19,6 +31,41 @@ type Storage interface {
 	BatchUpdate(ctx context.Context, m []*metric.Metric) error
 	// returns a metric by kind and name
 	Get(ctx context.Context, kind metric.Kind, name string) (*metric.Metric, error)
-	// returns all metrics from the storage
+	// returns consistent snapshot of all metrics from the storage
 	List(ctx context.Context) ([]*metric.Metric, error)
+	// returns metrics which match the query in the order of the query
+	Query(ctx context.Context, q Query) ([]*metric.Metric, error)
+	// deletes a metric by kind and name
+	Delete(ctx context.Context, kind metric.Kind, name string) error
+	// deletes metrics which match filters of the query, order and paging are ignored, returns count of deleted metrics
+	DeleteMatching(ctx context.Context, q Query) (int, error)
+	// sets value of the counter to zero
+	Reset(ctx context.Context, name string) error
+	// atomically sets values of the metrics, counters aren't summed with stored values
+	Restore(ctx context.Context, metrics []*metric.Metric, mode RestoreMode) error
+}
+
+// ValidateMetrics - checks that metrics have ids, known kinds and values of their kinds like imported rows,
+// storages check restored metrics before changing of the stored ones
+func ValidateMetrics(metrics []*metric.Metric) error {
+	for _, m := range metrics {
+		if m.ID == "" {
+			return fmt.Errorf("empty id, err=%w", ErrBadMetric)
+		}
+
+		switch m.Type {
+		case metric.Gauge:
+			if m.Value == nil {
+				return fmt.Errorf("gauge id=%s without value, err=%w", m.ID, ErrBadMetric)
+			}
+		case metric.Counter:
+			if m.Delta == nil {
+				return fmt.Errorf("counter id=%s without delta, err=%w", m.ID, ErrBadMetric)
+			}
+		default:
+			return fmt.Errorf("metric=%s, kind=%s, err=%w", m.ID, m.Type, ErrUnknownKind)
+		}
+	}
+
+	return nil
 }
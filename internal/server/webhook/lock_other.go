@@ -0,0 +1,30 @@
+//go:build !unix
+
+package webhook
+
+import (
+	"fmt"
+	"os"
+)
+
+// the file can't be locked on this system, so the lock file isn't removed if the server is crashed
+func lockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0o600)
+	if os.IsExist(err) {
+		return nil, fmt.Errorf("lock file=%s, err=%w", path, ErrQueueLocked)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("open lock file=%s, err=%w", path, err)
+	}
+
+	return f, nil
+}
+
+func unlockFile(f *os.File) error {
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(f.Name())
+}
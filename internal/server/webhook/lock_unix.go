@@ -0,0 +1,28 @@
+//go:build unix
+
+package webhook
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// the lock is released by the system if the server is crashed
+func lockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open lock file=%s, err=%w", path, err)
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("lock file=%s, err=%w", path, ErrQueueLocked)
+	}
+
+	return f, nil
+}
+
+func unlockFile(f *os.File) error {
+	return f.Close()
+}
@@ -0,0 +1,176 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	deliveryFileExt = ".json"
+	tempFileExt     = ".tmp"
+	// servers would deliver and remove deliveries of each other if they used the same directory
+	lockFileName = "queue.lock"
+)
+
+var ErrQueueLocked = errors.New("webhooks queue is used by another server")
+
+// delivery - payload which isn't delivered to the subscription yet
+type delivery struct {
+	ID           string          `json:"id"`
+	Subscription string          `json:"subscription"`
+	Body         json.RawMessage `json:"body"`
+	Attempts     int             `json:"attempts"`
+	NextAttempt  time.Time       `json:"next_attempt"`
+	LastError    string          `json:"last_error,omitempty"`
+}
+
+// deliveryHeap - deliveries ordered by time of the next attempt
+type deliveryHeap []*delivery
+
+func (h deliveryHeap) Len() int           { return len(h) }
+func (h deliveryHeap) Less(i, j int) bool { return h[i].NextAttempt.Before(h[j].NextAttempt) }
+func (h deliveryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *deliveryHeap) Push(x any) {
+	*h = append(*h, x.(*delivery))
+}
+
+func (h *deliveryHeap) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+
+	return last
+}
+
+// queue - locked directory with a file per delivery, so deliveries survive restarts of the server,
+// deliveries are kept only in memory if the directory isn't set
+type queue struct {
+	dir  string
+	lock *os.File
+}
+
+func openQueue(dir string) (*queue, error) {
+	if dir == "" {
+		return &queue{}, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create queue dir=%s, err=%w", dir, err)
+	}
+
+	lock, err := lockFile(filepath.Join(dir, lockFileName))
+	if err != nil {
+		return nil, fmt.Errorf("lock queue dir=%s, err=%w", dir, err)
+	}
+
+	return &queue{dir: dir, lock: lock}, nil
+}
+
+// close - releases the directory, files are kept for the next start
+func (q *queue) close() error {
+	if q.lock == nil {
+		return nil
+	}
+
+	if err := unlockFile(q.lock); err != nil {
+		return fmt.Errorf("unlock queue dir=%s, err=%w", q.dir, err)
+	}
+
+	q.lock = nil
+
+	return nil
+}
+
+// load - returns stored deliveries, unreadable files are skipped and returned in the error
+func (q *queue) load() ([]*delivery, error) {
+	if q.dir == "" {
+		return nil, nil
+	}
+
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, fmt.Errorf("read queue dir=%s, err=%w", q.dir, err)
+	}
+
+	var deliveries []*delivery
+	var joinedErr error
+
+	for _, entry := range entries {
+		path := filepath.Join(q.dir, entry.Name())
+
+		// leftovers of interrupted writes
+		if strings.HasSuffix(entry.Name(), tempFileExt) {
+			_ = os.Remove(path)
+			continue
+		}
+
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), deliveryFileExt) {
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			joinedErr = errors.Join(joinedErr, fmt.Errorf("read delivery file=%s, err=%w", path, err))
+			continue
+		}
+
+		d := &delivery{}
+		if err := json.Unmarshal(data, d); err != nil || d.ID+deliveryFileExt != entry.Name() {
+			joinedErr = errors.Join(joinedErr, fmt.Errorf("bad delivery file=%s, err=%v", path, err))
+			continue
+		}
+
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, joinedErr
+}
+
+// save - replaces stored delivery atomically
+func (q *queue) save(d *delivery) error {
+	if q.dir == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("marshal delivery err=%w", err)
+	}
+
+	path := q.path(d.ID)
+	temp := path + tempFileExt
+
+	if err := os.WriteFile(temp, data, 0o600); err != nil {
+		return fmt.Errorf("write delivery file=%s, err=%w", temp, err)
+	}
+
+	if err := os.Rename(temp, path); err != nil {
+		_ = os.Remove(temp)
+		return fmt.Errorf("rename delivery file=%s, err=%w", temp, err)
+	}
+
+	return nil
+}
+
+func (q *queue) remove(id string) error {
+	if q.dir == "" {
+		return nil
+	}
+
+	if err := os.Remove(q.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove delivery id=%s, err=%w", id, err)
+	}
+
+	return nil
+}
+
+func (q *queue) path(id string) string {
+	return filepath.Join(q.dir, id+deliveryFileExt)
+}
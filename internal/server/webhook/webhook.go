@@ -0,0 +1,623 @@
+// package webhook - signed notifications about updates of metrics which are delivered through persistent retry queue
+package webhook
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/kuzhukin/metrics-collector/internal/idempotency"
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+	"github.com/kuzhukin/metrics-collector/internal/server/config"
+	"github.com/kuzhukin/metrics-collector/internal/server/storage"
+	"github.com/kuzhukin/metrics-collector/internal/zlog"
+)
+
+// headers of webhook requests
+const (
+	// id of the delivery, it's the same in retries, so receivers can deduplicate them
+	IDHeader = "X-Webhook-ID"
+	// HMAC-SHA256 of the body with the secret of the subscription: sha256={hex}
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+const (
+	// max count of undelivered payloads, new payloads are dropped after it
+	maxQueueSize = 10000
+	// deliveries are dropped after the last attempt
+	maxAttempts = 10
+	// delay before the first retry, it's doubled with every retry
+	retryBaseDelay = time.Second
+	retryMaxDelay  = time.Minute * 10
+	// count of concurrent requests to receivers
+	workersCount = 4
+	// changes of the queue which aren't written to the disk yet, publishing waits for the disk after it
+	maxUnwrittenChanges = maxQueueSize
+	// timeout of the single request
+	requestTimeout = time.Second * 10
+	// responses of receivers aren't used, they are read partially for reusing connections
+	maxResponseSize = 64 * 1024
+)
+
+var ErrBadSubscription = errors.New("bad webhook subscription")
+var ErrUnknownSubscription = errors.New("unknown webhook subscription")
+var ErrDeliveryFailed = errors.New("webhook isn't accepted by receiver")
+
+type EventType string
+
+const (
+	EventUpdate EventType = "update"
+	EventDelete EventType = "delete"
+	// sent by the admin API for checking of the receiver
+	EventTest EventType = "test"
+)
+
+// Payload - body of webhook request
+type Payload struct {
+	ID           string    `json:"id"`
+	Subscription string    `json:"subscription"`
+	Time         time.Time `json:"time"`
+	Events       []Event   `json:"events"`
+}
+
+// Event - counters of updates contain delta of the update, values of deleted metrics aren't set
+type Event struct {
+	Type   EventType      `json:"type"`
+	Metric *metric.Metric `json:"metric,omitempty"`
+}
+
+// SubscriptionStatus - subscription without its secret and statistics of its deliveries since the start of the server
+type SubscriptionStatus struct {
+	Name  string `json:"name"`
+	Match string `json:"match,omitempty"`
+	Kind  string `json:"kind,omitempty"`
+	URL   string `json:"url"`
+	// undelivered payloads in the queue
+	Pending   int `json:"pending"`
+	Delivered int `json:"delivered"`
+	// payloads which are dropped after the last attempt
+	Failed int `json:"failed"`
+	// payloads which are dropped because the queue is full
+	Dropped      int        `json:"dropped"`
+	LastError    string     `json:"last_error,omitempty"`
+	LastDelivery *time.Time `json:"last_delivery,omitempty"`
+}
+
+// TestResult - result of the test request to the receiver
+type TestResult struct {
+	OK bool `json:"ok"`
+	// status of the response, it's zero if the request has failed
+	Status     int    `json:"status,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+type subscription struct {
+	config.WebhookSubscription
+	matcher *regexp.Regexp
+
+	// statistics are protected by the mutex of the dispatcher
+	delivered    int
+	failed       int
+	dropped      int
+	lastError    string
+	lastDelivery time.Time
+}
+
+// Dispatcher - publisher of the notify storage which sends matched updates and deletions to subscriptions,
+// payloads are sent in the order of publishing, but retries can reorder them
+type Dispatcher struct {
+	sync.Mutex
+
+	subscriptions []*subscription
+	byName        map[string]*subscription
+	client        *http.Client
+	queue         *queue
+
+	pending map[string]*delivery
+	// pending deliveries which aren't sent now
+	waiting  deliveryHeap
+	inFlight int
+
+	// changes of the queue are written to the disk by the single goroutine in the order of changes,
+	// so publishing doesn't wait for the disk, it's nil if the queue is kept only in memory
+	writes  chan queueChange
+	writer  sync.WaitGroup
+	stopped bool
+
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+
+	// wakes up the scheduler after changes of the queue
+	wake chan struct{}
+	// cancels requests and stops the scheduler
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// StartNew - validates subscriptions, loads undelivered payloads and starts delivering,
+// the queue isn't opened if there are no subscriptions
+func StartNew(config config.WebhooksConfig) (*Dispatcher, error) {
+	d, err := newDispatcher(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(d.subscriptions) != 0 {
+		d.start()
+	}
+
+	return d, nil
+}
+
+func newDispatcher(config config.WebhooksConfig) (*Dispatcher, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	d := &Dispatcher{
+		byName:      make(map[string]*subscription, len(config.Subscriptions)),
+		client:      &http.Client{Timeout: requestTimeout},
+		queue:       &queue{},
+		pending:     make(map[string]*delivery),
+		maxAttempts: maxAttempts,
+		baseDelay:   retryBaseDelay,
+		maxDelay:    retryMaxDelay,
+		wake:        make(chan struct{}, 1),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+
+	for _, s := range config.Subscriptions {
+		sub, err := newSubscription(s)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+
+		if _, ok := d.byName[sub.Name]; ok {
+			cancel()
+			return nil, fmt.Errorf("duplicated name=%s, err=%w", sub.Name, ErrBadSubscription)
+		}
+
+		d.subscriptions = append(d.subscriptions, sub)
+		d.byName[sub.Name] = sub
+	}
+
+	if len(d.subscriptions) == 0 {
+		return d, nil
+	}
+
+	queue, err := openQueue(config.QueuePath)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	d.queue = queue
+
+	deliveries, err := queue.load()
+	if err != nil {
+		zlog.Logger.Warnf("Load webhooks queue, err=%s", err)
+	}
+
+	for _, delivery := range deliveries {
+		// payloads of removed subscriptions can't be delivered
+		if _, ok := d.byName[delivery.Subscription]; !ok {
+			zlog.Logger.Warnf("Drop webhook id=%s of unknown subscription=%s", delivery.ID, delivery.Subscription)
+			_ = queue.remove(delivery.ID)
+
+			continue
+		}
+
+		d.pending[delivery.ID] = delivery
+		d.waiting = append(d.waiting, delivery)
+	}
+
+	heap.Init(&d.waiting)
+
+	return d, nil
+}
+
+func newSubscription(s config.WebhookSubscription) (*subscription, error) {
+	if s.Name == "" {
+		return nil, fmt.Errorf("empty name, err=%w", ErrBadSubscription)
+	}
+
+	target, err := url.Parse(s.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("name=%s has bad url=%s, err=%w", s.Name, s.URL, ErrBadSubscription)
+	}
+
+	if kind := metric.Kind(s.Kind); kind != "" && kind != metric.Gauge && kind != metric.Counter {
+		return nil, fmt.Errorf("name=%s has unknown kind=%s, err=%w", s.Name, s.Kind, ErrBadSubscription)
+	}
+
+	if s.Secret == "" {
+		return nil, fmt.Errorf("name=%s has empty secret, err=%w", s.Name, ErrBadSubscription)
+	}
+
+	sub := &subscription{WebhookSubscription: s}
+
+	if s.Match != "" {
+		sub.matcher = regexp.MustCompile(storage.GlobToRegexp(s.Match))
+	}
+
+	return sub, nil
+}
+
+func (s *subscription) matches(m *metric.Metric) bool {
+	if s.Kind != "" && metric.Kind(s.Kind) != m.Type {
+		return false
+	}
+
+	return s.matcher == nil || s.matcher.MatchString(m.ID)
+}
+
+// Publish - queues updates of the metrics for matching subscriptions
+func (d *Dispatcher) Publish(metrics ...*metric.Metric) {
+	d.enqueue(EventUpdate, metrics)
+}
+
+// PublishDeleted - queues deletions of the metrics for matching subscriptions
+func (d *Dispatcher) PublishDeleted(metrics ...*metric.Metric) {
+	d.enqueue(EventDelete, metrics)
+}
+
+// matched metrics of the single publishing are sent in the single payload
+func (d *Dispatcher) enqueue(eventType EventType, metrics []*metric.Metric) {
+	now := time.Now()
+	queued := false
+
+	for _, sub := range d.subscriptions {
+		var events []Event
+
+		for _, m := range metrics {
+			if sub.matches(m) {
+				events = append(events, Event{Type: eventType, Metric: m})
+			}
+		}
+
+		if len(events) == 0 {
+			continue
+		}
+
+		id := idempotency.NewKey()
+
+		body, err := json.Marshal(Payload{ID: id, Subscription: sub.Name, Time: now, Events: events})
+		if err != nil {
+			zlog.Logger.Errorf("Marshal webhook subscription=%s, err=%s", sub.Name, err)
+			continue
+		}
+
+		if d.add(sub, &delivery{ID: id, Subscription: sub.Name, Body: body, NextAttempt: now}) {
+			queued = true
+		}
+	}
+
+	if queued {
+		d.notify()
+	}
+}
+
+func (d *Dispatcher) add(sub *subscription, delivery *delivery) bool {
+	d.Lock()
+	defer d.Unlock()
+
+	if len(d.pending) >= maxQueueSize {
+		sub.dropped++
+		zlog.Logger.Warnf("Webhooks queue is full, payload of subscription=%s is dropped", sub.Name)
+
+		return false
+	}
+
+	d.pending[delivery.ID] = delivery
+	heap.Push(&d.waiting, delivery)
+	d.write(delivery, false)
+
+	return true
+}
+
+// queueChange - saved or removed delivery, the delivery is copied because it's changed by attempts
+type queueChange struct {
+	delivery delivery
+	remove   bool
+}
+
+// passes the change to the writer, it's called under the lock, so changes are written in the order of changes
+func (d *Dispatcher) write(delivery *delivery, remove bool) {
+	if d.writes == nil || d.stopped {
+		return
+	}
+
+	d.writes <- queueChange{delivery: *delivery, remove: remove}
+}
+
+// the payload is still delivered if it isn't persisted, but it's lost after restart
+func (d *Dispatcher) writeChanges() {
+	for change := range d.writes {
+		if change.remove {
+			if err := d.queue.remove(change.delivery.ID); err != nil {
+				zlog.Logger.Errorf("Remove webhook id=%s, err=%s", change.delivery.ID, err)
+			}
+
+			continue
+		}
+
+		if err := d.queue.save(&change.delivery); err != nil {
+			zlog.Logger.Errorf("Save webhook id=%s, err=%s", change.delivery.ID, err)
+		}
+	}
+}
+
+func (d *Dispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Dispatcher) start() {
+	if d.queue.dir != "" {
+		d.writes = make(chan queueChange, maxUnwrittenChanges)
+		d.writer.Add(1)
+
+		go func() {
+			defer d.writer.Done()
+
+			d.writeChanges()
+		}()
+	}
+
+	d.wg.Add(1)
+
+	go func() {
+		defer d.wg.Done()
+
+		d.schedule()
+	}()
+}
+
+// Stop - interrupts requests and stops delivering, undelivered payloads stay in the queue
+func (d *Dispatcher) Stop() {
+	d.cancel()
+	d.wg.Wait()
+
+	d.Lock()
+	stopped := d.stopped
+	d.stopped = true
+	d.Unlock()
+
+	if stopped {
+		return
+	}
+
+	// all changes are written before releasing of the queue
+	if d.writes != nil {
+		close(d.writes)
+		d.writer.Wait()
+	}
+
+	if err := d.queue.close(); err != nil {
+		zlog.Logger.Errorf("Close webhooks queue, err=%s", err)
+	}
+}
+
+// starts due deliveries and sleeps until the next one
+func (d *Dispatcher) schedule() {
+	for {
+		next := d.startDue()
+
+		var timer *time.Timer
+		var wait <-chan time.Time
+
+		if !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			wait = timer.C
+		}
+
+		select {
+		case <-d.wake:
+		case <-wait:
+		case <-d.ctx.Done():
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+
+		if d.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// returns time of the next attempt which isn't started, it's zero if there are no such attempts
+func (d *Dispatcher) startDue() time.Time {
+	d.Lock()
+	defer d.Unlock()
+
+	now := time.Now()
+
+	for len(d.waiting) > 0 {
+		due := d.waiting[0]
+		if due.NextAttempt.After(now) {
+			return due.NextAttempt
+		}
+
+		// finished workers wake up the scheduler
+		if d.inFlight >= workersCount {
+			return time.Time{}
+		}
+
+		heap.Pop(&d.waiting)
+		d.inFlight++
+		d.wg.Add(1)
+
+		go func(due *delivery) {
+			defer d.wg.Done()
+
+			d.attempt(due)
+		}(due)
+	}
+
+	return time.Time{}
+}
+
+func (d *Dispatcher) attempt(delivery *delivery) {
+	sub := d.byName[delivery.Subscription]
+	_, err := d.send(d.ctx, sub, delivery.ID, delivery.Body)
+
+	defer d.notify()
+
+	d.Lock()
+	defer d.Unlock()
+
+	d.inFlight--
+
+	// the attempt is interrupted by stopping, it's repeated after restart
+	if d.ctx.Err() != nil {
+		return
+	}
+
+	if err == nil {
+		sub.delivered++
+		sub.lastDelivery = time.Now()
+		d.drop(delivery)
+
+		return
+	}
+
+	delivery.Attempts++
+	delivery.LastError = err.Error()
+	sub.lastError = err.Error()
+
+	if delivery.Attempts >= d.maxAttempts {
+		zlog.Logger.Warnf("Webhook id=%s of subscription=%s is dropped after %d attempts, err=%s",
+			delivery.ID, sub.Name, delivery.Attempts, err)
+
+		sub.failed++
+		d.drop(delivery)
+
+		return
+	}
+
+	delivery.NextAttempt = time.Now().Add(d.retryDelay(delivery.Attempts))
+	heap.Push(&d.waiting, delivery)
+	d.write(delivery, false)
+}
+
+// removes the delivery from the queue, it's called under the lock
+func (d *Dispatcher) drop(delivery *delivery) {
+	delete(d.pending, delivery.ID)
+	d.write(delivery, true)
+}
+
+// exponential backoff with jitter: random delay in [delay/2, delay), delay = min(maxDelay, baseDelay * 2^(attempts-1))
+func (d *Dispatcher) retryDelay(attempts int) time.Duration {
+	delay := d.maxDelay
+	if attempts-1 < 32 && d.baseDelay<<(attempts-1) < d.maxDelay {
+		delay = d.baseDelay << (attempts - 1)
+	}
+
+	half := delay / 2
+
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// sends signed payload and returns status of the response
+func (d *Dispatcher) send(ctx context.Context, sub *subscription, id string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("make request url=%s, err=%w", sub.URL, err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(IDHeader, id)
+	req.Header.Set(SignatureHeader, Sign(sub.Secret, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("send request url=%s, err=%w", sub.URL, err)
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseSize))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("status=%d, err=%w", resp.StatusCode, ErrDeliveryFailed)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// Subscriptions - returns configured subscriptions and statistics of their deliveries
+func (d *Dispatcher) Subscriptions() []SubscriptionStatus {
+	d.Lock()
+	defer d.Unlock()
+
+	pending := make(map[string]int, len(d.subscriptions))
+	for _, delivery := range d.pending {
+		pending[delivery.Subscription]++
+	}
+
+	statuses := make([]SubscriptionStatus, 0, len(d.subscriptions))
+
+	for _, sub := range d.subscriptions {
+		status := SubscriptionStatus{
+			Name:      sub.Name,
+			Match:     sub.Match,
+			Kind:      sub.Kind,
+			URL:       sub.URL,
+			Pending:   pending[sub.Name],
+			Delivered: sub.delivered,
+			Failed:    sub.failed,
+			Dropped:   sub.dropped,
+			LastError: sub.lastError,
+		}
+
+		if !sub.lastDelivery.IsZero() {
+			lastDelivery := sub.lastDelivery
+			status.LastDelivery = &lastDelivery
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
+
+// Test - sends test payload to the subscription immediately, it isn't queued and retried
+func (d *Dispatcher) Test(ctx context.Context, name string) (*TestResult, error) {
+	sub, ok := d.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("name=%s, err=%w", name, ErrUnknownSubscription)
+	}
+
+	id := idempotency.NewKey()
+
+	body, err := json.Marshal(Payload{ID: id, Subscription: name, Time: time.Now(), Events: []Event{{Type: EventTest}}})
+	if err != nil {
+		return nil, fmt.Errorf("marshal test payload err=%w", err)
+	}
+
+	start := time.Now()
+	status, err := d.send(ctx, sub, id, body)
+
+	result := &TestResult{OK: err == nil, Status: status, DurationMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	return result, nil
+}
@@ -0,0 +1,318 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kuzhukin/metrics-collector/internal/metric"
+	"github.com/kuzhukin/metrics-collector/internal/server/config"
+	"github.com/stretchr/testify/require"
+)
+
+type receiver struct {
+	sync.Mutex
+
+	server   *httptest.Server
+	secret   string
+	payloads []Payload
+	ids      []string
+	// responses with the status before the successful one
+	failures int
+	status   int
+}
+
+func newReceiver(t *testing.T, secret string) *receiver {
+	r := &receiver{secret: secret, status: http.StatusInternalServerError}
+
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+
+		if !Verify(r.secret, body, req.Header.Get(SignatureHeader)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		r.Lock()
+		defer r.Unlock()
+
+		r.ids = append(r.ids, req.Header.Get(IDHeader))
+
+		if r.failures > 0 {
+			r.failures--
+			w.WriteHeader(r.status)
+
+			return
+		}
+
+		payload := Payload{}
+		require.NoError(t, json.Unmarshal(body, &payload))
+		r.payloads = append(r.payloads, payload)
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(r.server.Close)
+
+	return r
+}
+
+func (r *receiver) received() []Payload {
+	r.Lock()
+	defer r.Unlock()
+
+	return append([]Payload(nil), r.payloads...)
+}
+
+func newGauge(id string, value float64) *metric.Metric {
+	return &metric.Metric{ID: id, Type: metric.Gauge, Value: &value}
+}
+
+func startDispatcher(t *testing.T, cfg config.WebhooksConfig) *Dispatcher {
+	d, err := newDispatcher(cfg)
+	require.NoError(t, err)
+
+	d.baseDelay = time.Millisecond * 10
+	d.maxDelay = time.Millisecond * 50
+	d.start()
+
+	return d
+}
+
+func TestDispatcherDelivery(t *testing.T) {
+	heap := newReceiver(t, "heap-secret")
+	counters := newReceiver(t, "counters-secret")
+
+	d := startDispatcher(t, config.WebhooksConfig{
+		QueuePath: t.TempDir(),
+		Subscriptions: []config.WebhookSubscription{
+			{Name: "heap", Match: "Heap*", URL: heap.server.URL, Secret: "heap-secret"},
+			{Name: "counters", Kind: "counter", URL: counters.server.URL, Secret: "counters-secret"},
+		},
+	})
+	defer d.Stop()
+
+	delta := int64(5)
+	d.Publish(newGauge("HeapAlloc", 1), newGauge("Alloc", 2), &metric.Metric{ID: "PollCount", Type: metric.Counter, Delta: &delta})
+	d.PublishDeleted(&metric.Metric{ID: "HeapIdle", Type: metric.Gauge})
+
+	require.Eventually(t, func() bool {
+		return len(heap.received()) == 2 && len(counters.received()) == 1
+	}, time.Second*5, time.Millisecond*10)
+
+	heapPayloads := heap.received()
+	events := append(heapPayloads[0].Events, heapPayloads[1].Events...)
+	require.ElementsMatch(t, []Event{
+		{Type: EventUpdate, Metric: newGauge("HeapAlloc", 1)},
+		{Type: EventDelete, Metric: &metric.Metric{ID: "HeapIdle", Type: metric.Gauge}},
+	}, events)
+	require.Equal(t, "heap", heapPayloads[0].Subscription)
+
+	countersPayload := counters.received()[0]
+	require.Len(t, countersPayload.Events, 1)
+	require.Equal(t, "PollCount", countersPayload.Events[0].Metric.ID)
+	require.Equal(t, delta, *countersPayload.Events[0].Metric.Delta)
+
+	require.Eventually(t, func() bool {
+		for _, status := range d.Subscriptions() {
+			if status.Pending != 0 {
+				return false
+			}
+		}
+
+		return true
+	}, time.Second*5, time.Millisecond*10)
+
+	statuses := d.Subscriptions()
+	require.Len(t, statuses, 2)
+	require.Equal(t, "heap", statuses[0].Name)
+	require.Equal(t, 2, statuses[0].Delivered)
+	require.NotNil(t, statuses[0].LastDelivery)
+}
+
+func TestDispatcherRetry(t *testing.T) {
+	r := newReceiver(t, "secret")
+	r.failures = 2
+
+	d := startDispatcher(t, config.WebhooksConfig{
+		Subscriptions: []config.WebhookSubscription{{Name: "all", URL: r.server.URL, Secret: "secret"}},
+	})
+	defer d.Stop()
+
+	d.Publish(newGauge("Alloc", 1))
+
+	require.Eventually(t, func() bool {
+		return len(r.received()) == 1
+	}, time.Second*5, time.Millisecond*10)
+
+	r.Lock()
+	// retries are sent with the same id
+	require.Len(t, r.ids, 3)
+	require.Equal(t, r.ids[0], r.ids[2])
+	require.Equal(t, r.ids[0], r.payloads[0].ID)
+	r.Unlock()
+
+	require.Eventually(t, func() bool {
+		status := d.Subscriptions()[0]
+		return status.Delivered == 1 && status.Pending == 0
+	}, time.Second*5, time.Millisecond*10)
+	require.Contains(t, d.Subscriptions()[0].LastError, "status=500")
+}
+
+func TestDispatcherDropsAfterLastAttempt(t *testing.T) {
+	r := newReceiver(t, "secret")
+	r.failures = 100
+
+	d, err := newDispatcher(config.WebhooksConfig{
+		Subscriptions: []config.WebhookSubscription{{Name: "all", URL: r.server.URL, Secret: "secret"}},
+	})
+	require.NoError(t, err)
+
+	d.baseDelay = time.Millisecond
+	d.maxDelay = time.Millisecond
+	d.maxAttempts = 3
+	d.start()
+	defer d.Stop()
+
+	d.Publish(newGauge("Alloc", 1))
+
+	require.Eventually(t, func() bool {
+		status := d.Subscriptions()[0]
+		return status.Failed == 1 && status.Pending == 0
+	}, time.Second*5, time.Millisecond*10)
+
+	r.Lock()
+	require.Len(t, r.ids, 3)
+	r.Unlock()
+}
+
+func TestDispatcherPersistentQueue(t *testing.T) {
+	r := newReceiver(t, "secret")
+	r.failures = 1000
+	r.status = http.StatusServiceUnavailable
+
+	cfg := config.WebhooksConfig{
+		QueuePath:     t.TempDir(),
+		Subscriptions: []config.WebhookSubscription{{Name: "all", URL: r.server.URL, Secret: "secret"}},
+	}
+
+	d := startDispatcher(t, cfg)
+	d.Publish(newGauge("Alloc", 1))
+
+	require.Eventually(t, func() bool {
+		return d.Subscriptions()[0].LastError != ""
+	}, time.Second*5, time.Millisecond*10)
+
+	d.Stop()
+
+	r.Lock()
+	r.failures = 0
+	r.Unlock()
+
+	// the payload is delivered after restart
+	d = startDispatcher(t, cfg)
+	defer func() { d.Stop() }()
+
+	require.Eventually(t, func() bool {
+		return d.Subscriptions()[0].Pending == 0
+	}, time.Second*5, time.Millisecond*10)
+
+	// the request interrupted by stopping can be received too, so receivers deduplicate payloads by id
+	received := r.received()
+	require.NotEmpty(t, received)
+
+	for _, payload := range received {
+		require.Equal(t, received[0].ID, payload.ID)
+		require.Equal(t, newGauge("Alloc", 1), payload.Events[0].Metric)
+	}
+
+	// payloads of removed subscriptions are dropped
+	d.Stop()
+
+	cfg.Subscriptions[0].Name = "renamed"
+	d = startDispatcher(t, cfg)
+	require.Equal(t, 0, d.Subscriptions()[0].Pending)
+}
+
+func TestDispatcherQueueLock(t *testing.T) {
+	cfg := config.WebhooksConfig{
+		QueuePath:     t.TempDir(),
+		Subscriptions: []config.WebhookSubscription{{Name: "all", URL: "http://localhost", Secret: "secret"}},
+	}
+
+	d := startDispatcher(t, cfg)
+
+	// another server can't use the same queue
+	_, err := newDispatcher(cfg)
+	require.ErrorIs(t, err, ErrQueueLocked)
+
+	d.Stop()
+
+	d = startDispatcher(t, cfg)
+	d.Stop()
+}
+
+func TestDispatcherTest(t *testing.T) {
+	r := newReceiver(t, "secret")
+
+	d, err := StartNew(config.WebhooksConfig{
+		Subscriptions: []config.WebhookSubscription{
+			{Name: "good", URL: r.server.URL, Secret: "secret"},
+			{Name: "wrong-secret", URL: r.server.URL, Secret: "wrong"},
+		},
+	})
+	require.NoError(t, err)
+	defer d.Stop()
+
+	result, err := d.Test(context.Background(), "good")
+	require.NoError(t, err)
+	require.True(t, result.OK)
+	require.Equal(t, http.StatusOK, result.Status)
+	require.Equal(t, EventTest, r.received()[0].Events[0].Type)
+
+	result, err = d.Test(context.Background(), "wrong-secret")
+	require.NoError(t, err)
+	require.False(t, result.OK)
+	require.Equal(t, http.StatusUnauthorized, result.Status)
+	require.NotEmpty(t, result.Error)
+
+	_, err = d.Test(context.Background(), "unknown")
+	require.ErrorIs(t, err, ErrUnknownSubscription)
+
+	// test payloads aren't counted in statistics
+	require.Equal(t, 0, d.Subscriptions()[0].Delivered)
+}
+
+func TestBadSubscriptions(t *testing.T) {
+	for _, sub := range []config.WebhookSubscription{
+		{URL: "http://localhost", Secret: "secret"},
+		{Name: "relative", URL: "/hook", Secret: "secret"},
+		{Name: "ftp", URL: "ftp://localhost/hook", Secret: "secret"},
+		{Name: "kind", URL: "http://localhost", Kind: "histogram", Secret: "secret"},
+		{Name: "secret", URL: "http://localhost"},
+	} {
+		_, err := StartNew(config.WebhooksConfig{Subscriptions: []config.WebhookSubscription{sub}})
+		require.ErrorIs(t, err, ErrBadSubscription, sub.Name)
+	}
+
+	_, err := StartNew(config.WebhooksConfig{Subscriptions: []config.WebhookSubscription{
+		{Name: "twice", URL: "http://localhost", Secret: "secret"},
+		{Name: "twice", URL: "http://localhost", Secret: "secret"},
+	}})
+	require.ErrorIs(t, err, ErrBadSubscription)
+}
+
+func TestSignature(t *testing.T) {
+	payload := []byte(`{"id":"1"}`)
+	signature := Sign("secret", payload)
+
+	require.True(t, Verify("secret", payload, signature))
+	require.False(t, Verify("other", payload, signature))
+	require.False(t, Verify("secret", []byte(`{"id":"2"}`), signature))
+	require.False(t, Verify("secret", payload, signature[len(signaturePrefix):]))
+}